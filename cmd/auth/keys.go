@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/korikhin/auth/internal/lib/jwt"
+)
+
+const keysUsage = `usage:
+  keys generate [--alg ES256] [--kid ID] [--out DIR]  Generate a key pair
+  keys inspect [--alg ALG] FILE                      Print the JWK and thumbprint of a PEM key
+  keys jwks [--alg ALG] DIR                          Print the JWK set of a keys directory`
+
+func runKeys(args []string) error {
+	if len(args) == 0 {
+		return errors.New(keysUsage)
+	}
+
+	switch args[0] {
+	case "generate":
+		return keysGenerate(args[1:])
+	case "inspect":
+		return keysInspect(args[1:])
+	case "jwks":
+		return keysJWKS(args[1:])
+	default:
+		return errors.New(keysUsage)
+	}
+}
+
+func keysGenerate(args []string) error {
+	fs := flag.NewFlagSet("keys generate", flag.ContinueOnError)
+	alg := fs.String("alg", "ES256", "Signing algorithm")
+	kid := fs.String("kid", "", "Key ID, the key thumbprint if empty")
+	out := fs.String("out", ".", "Output directory")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	pk, err := jwt.GenerateKey(*alg)
+	if err != nil {
+		return err
+	}
+
+	privData, err := jwt.EncodePrivateKey(pk)
+	if err != nil {
+		return err
+	}
+	pubData, err := jwt.EncodePublicKey(pk.Public())
+	if err != nil {
+		return err
+	}
+
+	// The key is shown as the server and the other commands see it
+	// with no algorithm configured
+	k, err := jwt.ParseKey(privData, "")
+	if err != nil {
+		return err
+	}
+	if *kid != "" {
+		k.ID = *kid
+	}
+
+	privName, pubName := jwt.FileNames(k.ID)
+	privPath := filepath.Join(*out, privName)
+	pubPath := filepath.Join(*out, pubName)
+
+	if err := os.MkdirAll(*out, 0o700); err != nil {
+		return err
+	}
+	if err := writeNew(privPath, privData, 0o600); err != nil {
+		return err
+	}
+	if err := writeNew(pubPath, pubData, 0o644); err != nil {
+		return err
+	}
+
+	fmt.Printf("private key: %s\npublic key:  %s\n", privPath, pubPath)
+	if err := printKey(os.Stdout, k); err != nil {
+		return err
+	}
+
+	// RSA keys do not carry the algorithm, they default to RS256
+	if k.Method.Alg() != *alg {
+		fmt.Printf("required:    `jwt.algorithm: %s` (and `--alg %s` for inspect and jwks), the key alone means %s\n", *alg, *alg, k.Method.Alg())
+	}
+
+	return nil
+}
+
+func keysInspect(args []string) error {
+	fs := flag.NewFlagSet("keys inspect", flag.ContinueOnError)
+	alg := fs.String("alg", "", "Preferred algorithm for RSA keys")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New(keysUsage)
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	k, err := jwt.ParseKey(data, *alg)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("type:        %s\n", map[bool]string{true: "private", false: "public"}[k.Private != nil])
+	return printKey(os.Stdout, k)
+}
+
+func keysJWKS(args []string) error {
+	fs := flag.NewFlagSet("keys jwks", flag.ContinueOnError)
+	alg := fs.String("alg", "", "Preferred algorithm for RSA keys")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New(keysUsage)
+	}
+
+	keys, err := jwt.ReadKeysDir(fs.Arg(0), *alg)
+	if err != nil {
+		return err
+	}
+
+	set := jwt.JWKSet{Keys: make([]jwt.JWK, 0, len(keys))}
+	for _, k := range keys {
+		set.Keys = append(set.Keys, k.JWK())
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(set)
+}
+
+func printKey(w io.Writer, k *jwt.Key) error {
+	data, err := json.MarshalIndent(k.JWK(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "kid:         %s\nthumbprint:  %s\njwk:\n%s\n", k.ID, jwt.Thumbprint(k.Public), data)
+	return err
+}
+
+// writeNew refuses to overwrite existing keys.
+func writeNew(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
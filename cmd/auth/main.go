@@ -5,6 +5,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
@@ -14,24 +15,65 @@ import (
 
 	"github.com/korikhin/auth/internal/config"
 	"github.com/korikhin/auth/internal/http-server/handlers"
+	"github.com/korikhin/auth/internal/lib/api"
 	"github.com/korikhin/auth/internal/lib/jwt"
+	"github.com/korikhin/auth/internal/lib/lockout"
 	"github.com/korikhin/auth/internal/lib/logger"
-	storage "github.com/korikhin/auth/internal/storage/postgres"
+	"github.com/korikhin/auth/internal/lib/notify"
+	"github.com/korikhin/auth/internal/lib/password"
+	"github.com/korikhin/auth/internal/storage"
+	"github.com/korikhin/auth/internal/storage/memory"
+	"github.com/korikhin/auth/internal/storage/postgres"
 
 	logMW "github.com/korikhin/auth/internal/http-server/middleware/logger"
 	reqMW "github.com/korikhin/auth/internal/http-server/middleware/request"
+	httplib "github.com/korikhin/auth/internal/lib/http"
 	corMW "github.com/korikhin/auth/internal/lib/http/cors"
 )
 
 func usage() {
 	w := flag.CommandLine.Output()
-	_, _ = fmt.Fprintln(w, "Authentication Server\nFlags:")
+	_, _ = fmt.Fprintln(w, "Authentication Server\nCommands:")
+	_, _ = fmt.Fprintln(w, "  migrate up|down|status|version  Manage the storage schema and exit")
+	_, _ = fmt.Fprintln(w, "  users set-role <email> <role>   Assign a role, e.g. to bootstrap an admin, and exit")
+	_, _ = fmt.Fprintln(w, "  keys generate|inspect|jwks      Manage signing keys, no config required")
+	_, _ = fmt.Fprintln(w, "Flags:")
 
 	flag.VisitAll(func(f *flag.Flag) {
 		_, _ = fmt.Fprintf(w, "  --%-15s %s (default: %s)\n", f.Name, f.Usage, f.DefValue)
 	})
 }
 
+func newStorage(ctx context.Context, c config.Storage) (storage.Storage, error) {
+	switch c.Driver {
+	case config.Postgres:
+		s, err := postgres.New(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case config.Memory:
+		return memory.New(c), nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver: %q", c.Driver)
+	}
+}
+
+func migrateOnStart(ctx context.Context, s storage.Storage, log *slog.Logger) error {
+	m, ok := s.(storage.Migrator)
+	if !ok {
+		log.Info("storage driver has no schema, skipping migrations")
+		return nil
+	}
+
+	done, err := m.MigrateUp(ctx)
+	for _, d := range done {
+		log.Info("migration applied", slog.Uint64("version", d.Version), slog.String("name", d.Name))
+	}
+
+	return err
+}
+
 // TODO: Tests please
 func main() {
 	flag.Usage = usage
@@ -40,32 +82,103 @@ func main() {
 	flag.StringVar(&configPath, "config", "", "Path to config file")
 	flag.Parse()
 
+	// Key management works offline, without config and storage
+	if args := flag.Args(); len(args) > 0 && args[0] == "keys" {
+		if err := runKeys(args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Config and Logger setup
 	config := config.MustLoad(configPath)
 	log := logger.New(config.Stage)
 
 	log.Info("starting auth service...", logger.Stage(config.Stage))
 	log.Debug("debug messages are enabled")
+	log.Info("using storage driver", slog.String("driver", string(config.Storage.Driver)))
 
 	// Storage setup
-	storage, err := storage.New(context.Background(), config.Storage)
+	storage, err := newStorage(context.Background(), config.Storage)
 	if err != nil {
 		log.Error("failed to initialize storage", logger.Error(err))
 		os.Exit(1)
 	}
 
+	if args := flag.Args(); len(args) > 0 {
+		var err error
+		switch args[0] {
+		case "migrate":
+			err = runMigrate(context.Background(), storage, args[1:])
+		case "users":
+			err = runUsers(context.Background(), storage, args[1:])
+		default:
+			err = fmt.Errorf("unknown command: %s", args[0])
+		}
+		storage.Stop()
+		if err != nil {
+			log.Error("command failed", logger.Error(err))
+			os.Exit(1)
+		}
+		return
+	}
+
+	if config.Storage.MigrateOnStart {
+		if err := migrateOnStart(context.Background(), storage, log); err != nil {
+			log.Error("failed to migrate storage", logger.Error(err))
+			os.Exit(1)
+		}
+	}
+
+	hasher, err := password.New(config.Password)
+	if err != nil {
+		log.Error("failed to initialize password hashing", logger.Error(err))
+		os.Exit(1)
+	}
+
+	policy, err := password.NewPolicy(config.Password)
+	if err != nil {
+		log.Error("failed to initialize password policy", logger.Error(err))
+		os.Exit(1)
+	}
+	api.SetPasswordPolicy(policy)
+
+	guard := lockout.New(config.Lockout, storage)
+
+	notifier, err := notify.New(config.Notifier, log)
+	if err != nil {
+		log.Error("failed to initialize notifier", logger.Error(err))
+		os.Exit(1)
+	}
+
+	proxies, err := httplib.ParseProxies(config.HTTPServer.TrustedProxies)
+	if err != nil {
+		log.Error("failed to parse trusted proxies", logger.Error(err))
+		os.Exit(1)
+	}
+
 	corMW := corMW.New(config.CORS)
 	ridMW := reqMW.ID()
+	ripMW := reqMW.RealIP(proxies)
 	logMW := logMW.New(log)
 
 	// Router setup
 	router := handlers.NewRouter()
-	router.Use(corMW, ridMW, logMW)
+	router.Use(corMW, ridMW, ripMW, logMW)
 
 	jwtService := jwt.NewService(config.JWT)
 
-	handlers.Public(router, log, jwtService, storage)
-	handlers.Protected(router, log, jwtService, storage)
+	api := handlers.API(router)
+	handlers.WellKnown(router, jwtService)
+	handlers.Public(api, log, jwtService, storage, hasher, guard, notifier, config.Verification, config.Registration, config.RateLimit.Public)
+	handlers.Protected(api, log, jwtService, storage, hasher, notifier, config.MFA, config.Verification, config.RateLimit.Protected)
+	handlers.Introspection(api, log, jwtService, storage, config.Introspection, config.RateLimit.Introspection)
+	handlers.PasswordReset(api, log, storage, hasher, notifier, config.Password, config.RateLimit.PasswordReset)
+	handlers.WebAuthn(api, log, jwtService, storage, config.WebAuthn, config.Verification, config.RateLimit.Public, config.RateLimit.Protected)
+	handlers.OAuth(router, log, jwtService, storage, config.OAuth, config.RateLimit.Public, config.RateLimit.Protected)
+	handlers.OAuthClients(api, log, jwtService, storage, config.OAuth, config.MFA, config.RateLimit.Protected)
+	handlers.Federation(api, log, jwtService, storage, config.Federation, config.Verification, config.RateLimit.Public)
 
 	// Server setup
 	server := &http.Server{
@@ -113,6 +226,32 @@ func main() {
 		}
 	}()
 
+	// Keyring reload on SIGHUP and, optionally, periodically
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		log := log.With(logger.Component("system/keys"))
+
+		var tick <-chan time.Time
+		if config.JWT.ReloadInterval > 0 {
+			ticker := time.NewTicker(config.JWT.ReloadInterval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		for {
+			select {
+			case <-reload:
+			case <-tick:
+			}
+			if err := jwtService.Reload(); err != nil {
+				log.Error("failed to reload keys, keeping the current ones", logger.Error(err))
+				continue
+			}
+			log.Info("keys reloaded")
+		}
+	}()
+
 	shutdownSignal := <-shutdown
 	log.Info("recieved shutdown signal", logger.Signal(shutdownSignal))
 
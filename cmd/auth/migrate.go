@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/korikhin/auth/internal/storage"
+)
+
+const migrateUsage = "usage: migrate up|down|status|version"
+
+var errNotMigrator = errors.New("storage driver does not support migrations")
+
+func runMigrate(ctx context.Context, s storage.Storage, args []string) error {
+	m, ok := s.(storage.Migrator)
+	if !ok {
+		return errNotMigrator
+	}
+	if len(args) != 1 {
+		return errors.New(migrateUsage)
+	}
+
+	switch args[0] {
+	case "up":
+		done, err := m.MigrateUp(ctx)
+		for _, d := range done {
+			fmt.Printf("applied %d_%s\n", d.Version, d.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(done) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	case "down":
+		done, err := m.MigrateDown(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("rolled back %d_%s\n", done.Version, done.Name)
+	case "status":
+		states, err := m.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, st := range states {
+			appliedAt := "pending"
+			if st.Applied {
+				appliedAt = st.AppliedAt.UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", st.Version, st.Name, appliedAt)
+		}
+		return w.Flush()
+	case "version":
+		v, err := m.SchemaVersion(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Println(v)
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/storage"
+)
+
+const usersUsage = "usage: users set-role [--permissions p1,p2] <email> user|admin"
+
+var roles = []string{models.RoleUser, models.RoleAdmin}
+
+func runUsers(ctx context.Context, s storage.Storage, args []string) error {
+	if len(args) == 0 {
+		return errors.New(usersUsage)
+	}
+
+	switch args[0] {
+	case "set-role":
+		return usersSetRole(ctx, s, args[1:])
+	default:
+		return errors.New(usersUsage)
+	}
+}
+
+// usersSetRole assigns the role, it is the way to bootstrap the first admin.
+// The role is picked up by access tokens issued from then on.
+func usersSetRole(ctx context.Context, s storage.Storage, args []string) error {
+	fs := flag.NewFlagSet("users set-role", flag.ContinueOnError)
+	perms := fs.String("permissions", "", "Comma-separated permissions, replace the current ones")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errors.New(usersUsage)
+	}
+
+	email, role := fs.Arg(0), fs.Arg(1)
+	if !slices.Contains(roles, role) {
+		return fmt.Errorf("unknown role: %q", role)
+	}
+
+	var permissions []string
+	for _, p := range strings.Split(*perms, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			permissions = append(permissions, p)
+		}
+	}
+
+	ctxStorage, cancel := context.WithTimeout(ctx, s.Options().WriteTimeout)
+	defer cancel()
+
+	user, err := s.UserByEmail(ctxStorage, email)
+	if err != nil {
+		return err
+	}
+	if err := s.SetUserRole(ctxStorage, user.ID, role, permissions); err != nil {
+		return err
+	}
+
+	fmt.Printf("user %s (%s) is now %s\n", user.ID, user.Email, role)
+	return nil
+}
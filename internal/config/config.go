@@ -16,12 +16,31 @@ import (
 
 type Stage string
 
+type Driver string
+
+type NotifierDriver string
+
+type PasswordAlgorithm string
+
+type RateLimitKey string
+
 type Config struct {
-	Stage      Stage `yaml:"-" koanf:"stg"`
-	CORS       `yaml:"cors" koanf:"cors"`
-	HTTPServer `yaml:"http-server" koanf:"http-server"`
-	JWT        `yaml:"jwt" koanf:"jwt"`
-	Storage    `yaml:"storage" koanf:"storage"`
+	Stage         Stage `yaml:"-" koanf:"stg"`
+	CORS          `yaml:"cors" koanf:"cors"`
+	HTTPServer    `yaml:"http-server" koanf:"http-server"`
+	JWT           `yaml:"jwt" koanf:"jwt"`
+	Storage       `yaml:"storage" koanf:"storage"`
+	Introspection `yaml:"introspection" koanf:"introspection"`
+	Password      `yaml:"password" koanf:"password"`
+	Verification  `yaml:"verification" koanf:"verification"`
+	Registration  `yaml:"registration" koanf:"registration"`
+	Lockout       `yaml:"lockout" koanf:"lockout"`
+	MFA           `yaml:"mfa" koanf:"mfa"`
+	WebAuthn      `yaml:"webauthn" koanf:"webauthn"`
+	OAuth         `yaml:"oauth" koanf:"oauth"`
+	Federation    `yaml:"federation" koanf:"federation"`
+	RateLimit     `yaml:"rate-limit" koanf:"rate-limit"`
+	Notifier      `yaml:"notifier" koanf:"notifier"`
 }
 
 type HTTPServer struct {
@@ -31,6 +50,10 @@ type HTTPServer struct {
 	IdleTimeout     time.Duration `yaml:"idle-timeout" koanf:"idle-timeout"`
 	ShutdownTimeout time.Duration `yaml:"shutdown-timeout" koanf:"shutdown-timeout"`
 	HealthTimeout   time.Duration `yaml:"health-timeout" koanf:"health-timeout"`
+
+	// TrustedProxies are the networks (CIDR) allowed to set the client
+	// address with `X-Forwarded-For`, empty means the header is ignored
+	TrustedProxies []string `yaml:"trusted-proxies" koanf:"trusted-proxies"`
 }
 
 type CORS struct {
@@ -39,20 +62,177 @@ type CORS struct {
 }
 
 type JWT struct {
-	Issuer     string        `yaml:"issuer" koanf:"issuer"`
-	AccessTTL  time.Duration `yaml:"access-ttl" koanf:"access-ttl"`
-	RefreshTTL time.Duration `yaml:"refresh-ttl" koanf:"refresh-ttl"`
-	Leeway     time.Duration `yaml:"leeway" koanf:"leeway"`
+	Issuer         string        `yaml:"issuer" koanf:"issuer"`
+	AccessTTL      time.Duration `yaml:"access-ttl" koanf:"access-ttl"`
+	RefreshTTL     time.Duration `yaml:"refresh-ttl" koanf:"refresh-ttl"`
+	VerifyTTL      time.Duration `yaml:"verify-ttl" koanf:"verify-ttl"`
+	MFATTL         time.Duration `yaml:"mfa-ttl" koanf:"mfa-ttl"`
+	Leeway         time.Duration `yaml:"leeway" koanf:"leeway"`
+	KeysDir        string        `yaml:"keys-dir" koanf:"keys-dir"`
+	ActiveKey      string        `yaml:"active-key" koanf:"active-key"`
+	ReloadInterval time.Duration `yaml:"reload-interval" koanf:"reload-interval"`
+	Algorithm      string        `yaml:"algorithm" koanf:"algorithm"`
+	KeyID          string        `yaml:"key-id" koanf:"key-id"`
+	PrivateKey     string        `yaml:"private-key" koanf:"private-key"`
+	PrivateKeyFile string        `yaml:"private-key-file" koanf:"private-key-file"`
+
+	// ImplicitRefresh lets the middleware reissue tokens when the access
+	// token is expired, instead of requiring `POST /v1/auth/refresh`
+	ImplicitRefresh bool `yaml:"implicit-refresh" koanf:"implicit-refresh"`
+}
+
+// Introspection is disabled unless the client is configured.
+type Introspection struct {
+	ClientID     string `yaml:"client-id" koanf:"client-id"`
+	ClientSecret string `yaml:"client-secret" koanf:"client-secret"`
+}
+
+type Password struct {
+	// Algorithm for new hashes, existing ones are upgraded on login
+	Algorithm         PasswordAlgorithm `yaml:"algorithm" koanf:"algorithm"`
+	BcryptCost        int               `yaml:"bcrypt-cost" koanf:"bcrypt-cost"`
+	Argon2Memory      uint32            `yaml:"argon2-memory" koanf:"argon2-memory"`
+	Argon2Iterations  uint32            `yaml:"argon2-iterations" koanf:"argon2-iterations"`
+	Argon2Parallelism uint8             `yaml:"argon2-parallelism" koanf:"argon2-parallelism"`
+
+	// Policy for new passwords, lengths are in characters
+	MinLength     int  `yaml:"min-length" koanf:"min-length"`
+	MaxLength     int  `yaml:"max-length" koanf:"max-length"`
+	RequireUpper  bool `yaml:"require-upper" koanf:"require-upper"`
+	RequireLower  bool `yaml:"require-lower" koanf:"require-lower"`
+	RequireDigit  bool `yaml:"require-digit" koanf:"require-digit"`
+	RequireSymbol bool `yaml:"require-symbol" koanf:"require-symbol"`
+	// BannedFile lists passwords that are refused, one per line
+	BannedFile string `yaml:"banned-file" koanf:"banned-file"`
+
+	ResetTTL time.Duration `yaml:"reset-ttl" koanf:"reset-ttl"`
+	// ResetURL is the page the reset token is appended to as `?token=`
+	ResetURL string `yaml:"reset-url" koanf:"reset-url"`
+}
+
+type Verification struct {
+	// URL is the page the verification token is appended to as `?token=`
+	URL string `yaml:"url" koanf:"url"`
+	// Required makes login refuse accounts with unverified email
+	Required bool `yaml:"required" koanf:"required"`
+}
+
+type Registration struct {
+	// Uniform makes registration respond the same whether the email is
+	// taken or not, the owner of the existing account is notified instead
+	Uniform bool `yaml:"uniform" koanf:"uniform"`
+}
+
+type MFA struct {
+	// Issuer is the account provider shown in authenticator apps
+	Issuer string `yaml:"issuer" koanf:"issuer"`
+	// AdminRequired restricts the admin endpoints to sessions
+	// authenticated with the second factor
+	AdminRequired bool `yaml:"admin-required" koanf:"admin-required"`
+}
+
+// WebAuthn (passkeys) is disabled unless the relying party is configured.
+type WebAuthn struct {
+	// RPID is the domain passkeys are scoped to, e.g. `example.com`
+	RPID   string `yaml:"rp-id" koanf:"rp-id"`
+	RPName string `yaml:"rp-name" koanf:"rp-name"`
+	// Origins allowed to run the ceremonies, `https://<rp-id>` if empty
+	Origins []string `yaml:"origins" koanf:"origins"`
+	// UserVerification requires a PIN or biometrics, not only presence
+	UserVerification bool          `yaml:"user-verification" koanf:"user-verification"`
+	Timeout          time.Duration `yaml:"timeout" koanf:"timeout"`
+}
+
+// OAuth makes the service an authorization server for the clients
+// registered by the admins.
+type OAuth struct {
+	Enabled bool `yaml:"enabled" koanf:"enabled"`
+	// CodeTTL is the time to exchange an authorization code
+	CodeTTL time.Duration `yaml:"code-ttl" koanf:"code-ttl"`
+	// AuthorizeURL is the frontend page showing the consent screen,
+	// advertised as the authorization endpoint in the OpenID Connect
+	// discovery document
+	AuthorizeURL string `yaml:"authorize-url" koanf:"authorize-url"`
+}
+
+// Federation lets users log in with external OpenID Connect providers.
+type Federation struct {
+	Providers []IdentityProvider `yaml:"providers" koanf:"providers"`
+	// RedirectURL is the frontend page the browser is sent to once the login
+	// is over. Errors are passed as `?error=`, MFA challenges as `#mfa_token=`
+	RedirectURL string `yaml:"redirect-url" koanf:"redirect-url"`
+	// StateTTL is the time to complete the login at the provider
+	StateTTL time.Duration `yaml:"state-ttl" koanf:"state-ttl"`
+	// Timeout of the requests to the providers
+	Timeout time.Duration `yaml:"timeout" koanf:"timeout"`
+}
+
+type IdentityProvider struct {
+	// Name identifies the provider in the login URLs
+	Name         string `yaml:"name" koanf:"name"`
+	Issuer       string `yaml:"issuer" koanf:"issuer"`
+	ClientID     string `yaml:"client-id" koanf:"client-id"`
+	ClientSecret string `yaml:"client-secret" koanf:"client-secret"`
+	// CallbackURL is the public URL of the callback endpoint,
+	// as registered with the provider
+	CallbackURL string `yaml:"callback-url" koanf:"callback-url"`
+	// Scopes default to `openid email`
+	Scopes []string `yaml:"scopes" koanf:"scopes"`
+}
+
+// Lockout throttles failed logins per account and per client address.
+// Once the threshold is reached, every failure locks the key for twice
+// as long as the previous one, starting with BaseDelay up to MaxDelay.
+type Lockout struct {
+	Enabled          bool `yaml:"enabled" koanf:"enabled"`
+	AccountThreshold int  `yaml:"account-threshold" koanf:"account-threshold"`
+	IPThreshold      int  `yaml:"ip-threshold" koanf:"ip-threshold"`
+	// Window after which failures are forgotten
+	Window    time.Duration `yaml:"window" koanf:"window"`
+	BaseDelay time.Duration `yaml:"base-delay" koanf:"base-delay"`
+	MaxDelay  time.Duration `yaml:"max-delay" koanf:"max-delay"`
+}
+
+// RateLimit holds the limits of the route groups,
+// the buckets are kept in memory of each instance.
+type RateLimit struct {
+	Public        Limit `yaml:"public" koanf:"public"`
+	Protected     Limit `yaml:"protected" koanf:"protected"`
+	Introspection Limit `yaml:"introspection" koanf:"introspection"`
+	PasswordReset Limit `yaml:"password-reset" koanf:"password-reset"`
+}
+
+// Limit is a token bucket of Burst tokens refilled with Requests per Period.
+type Limit struct {
+	// Requests of 0 disables the limit
+	Requests int           `yaml:"requests" koanf:"requests"`
+	Period   time.Duration `yaml:"period" koanf:"period"`
+	// Burst defaults to Requests
+	Burst int          `yaml:"burst" koanf:"burst"`
+	Key   RateLimitKey `yaml:"key" koanf:"key"`
+}
+
+type Notifier struct {
+	Driver NotifierDriver `yaml:"driver" koanf:"driver"`
+	Path   string         `yaml:"path" koanf:"path"`
+
+	// SMTP
+	From         string `yaml:"from" koanf:"from"`
+	SMTPAddress  string `yaml:"smtp-address" koanf:"smtp-address"`
+	SMTPUsername string `yaml:"smtp-username" koanf:"smtp-username"`
+	SMTPPassword string `yaml:"smtp-password" koanf:"smtp-password"`
 }
 
 type Storage struct {
-	URL          string        `yaml:"url" koanf:"url"`
-	MinConns     int32         `yaml:"min-conns" koanf:"min-conns"`
-	MaxConns     int32         `yaml:"max-conns" koanf:"max-conns"`
-	StartTimeout time.Duration `yaml:"start-timeout" koanf:"start-timeout"`
-	ReadTimeout  time.Duration `yaml:"read-timeout" koanf:"read-timeout"`
-	WriteTimeout time.Duration `yaml:"write-timeout" koanf:"write-timeout"`
-	IdleTimeout  time.Duration `yaml:"idle-timeout" koanf:"idle-timeout"`
+	Driver         Driver        `yaml:"driver" koanf:"driver"`
+	URL            string        `yaml:"url" koanf:"url"`
+	MigrateOnStart bool          `yaml:"migrate-on-start" koanf:"migrate-on-start"`
+	MinConns       int32         `yaml:"min-conns" koanf:"min-conns"`
+	MaxConns       int32         `yaml:"max-conns" koanf:"max-conns"`
+	StartTimeout   time.Duration `yaml:"start-timeout" koanf:"start-timeout"`
+	ReadTimeout    time.Duration `yaml:"read-timeout" koanf:"read-timeout"`
+	WriteTimeout   time.Duration `yaml:"write-timeout" koanf:"write-timeout"`
+	IdleTimeout    time.Duration `yaml:"idle-timeout" koanf:"idle-timeout"`
 }
 
 // Development stages
@@ -62,6 +242,32 @@ const (
 	Prod  Stage = "prod"
 )
 
+// Storage drivers
+const (
+	Postgres Driver = "postgres"
+	Memory   Driver = "memory"
+)
+
+// Password hashing algorithms
+const (
+	Argon2id PasswordAlgorithm = "argon2id"
+	Bcrypt   PasswordAlgorithm = "bcrypt"
+)
+
+// Rate limit keys
+const (
+	RateLimitByIP      RateLimitKey = "ip"
+	RateLimitBySubject RateLimitKey = "subject"
+	RateLimitByRoute   RateLimitKey = "route"
+)
+
+// Notifier drivers
+const (
+	NotifierLog  NotifierDriver = "log"
+	NotifierFile NotifierDriver = "file"
+	NotifierSMTP NotifierDriver = "smtp"
+)
+
 const (
 	envPrefix     = "AUTH_SERVER__PREFIX"
 	envStage      = "STG"
@@ -107,10 +313,41 @@ func MustLoad(path string) *Config {
 	if err := k.UnmarshalWithConf("", cfg, koanf.UnmarshalConf{Tag: Tag}); err != nil {
 		log.Fatalf("error: %v", err)
 	}
+	if err := cfg.RateLimit.validate(); err != nil {
+		log.Fatalf("error: %v", err)
+	}
 
 	return cfg
 }
 
+// validate rejects limits that would be silently off or misapplied.
+func (c RateLimit) validate() error {
+	for _, g := range []struct {
+		name string
+		l    Limit
+	}{
+		{"public", c.Public},
+		{"protected", c.Protected},
+		{"introspection", c.Introspection},
+		{"password-reset", c.PasswordReset},
+	} {
+		name, l := g.name, g.l
+		if l.Requests < 0 || l.Burst < 0 {
+			return fmt.Errorf("rate-limit.%s: requests and burst must not be negative", name)
+		}
+		if l.Requests > 0 && l.Period <= 0 {
+			return fmt.Errorf("rate-limit.%s: period must be positive when requests is set", name)
+		}
+		switch l.Key {
+		case "", RateLimitByIP, RateLimitBySubject, RateLimitByRoute:
+		default:
+			return fmt.Errorf("rate-limit.%s: unknown key %q", name, l.Key)
+		}
+	}
+
+	return nil
+}
+
 func envParser(p string) func(string) string {
 	return func(s string) string {
 		s = strings.TrimPrefix(s, p)
@@ -136,11 +373,63 @@ func defaultConfig() *Config {
 			HealthTimeout:   15 * time.Minute,
 		},
 		JWT: JWT{
-			AccessTTL:  15 * time.Minute,
-			RefreshTTL: 24 * time.Hour,
-			Leeway:     0 * time.Second,
+			AccessTTL:      15 * time.Minute,
+			RefreshTTL:     24 * time.Hour,
+			VerifyTTL:      24 * time.Hour,
+			MFATTL:         5 * time.Minute,
+			Leeway:         0 * time.Second,
+			KeysDir:        "/etc/secrets", // Prefix `/etc` added for Render.com deployment
+			ReloadInterval: 0,
+
+			ImplicitRefresh: false,
+		},
+		Password: Password{
+			// OWASP recommendations
+			Algorithm:         Argon2id,
+			BcryptCost:        12,
+			Argon2Memory:      19 * 1024,
+			Argon2Iterations:  2,
+			Argon2Parallelism: 1,
+
+			MinLength: 8,
+			MaxLength: 64,
+
+			ResetTTL: 1 * time.Hour,
+		},
+		MFA: MFA{
+			Issuer:        "Auth",
+			AdminRequired: true,
+		},
+		WebAuthn: WebAuthn{
+			RPName:  "Auth",
+			Timeout: 5 * time.Minute,
+		},
+		OAuth: OAuth{
+			CodeTTL: 1 * time.Minute,
+		},
+		Federation: Federation{
+			StateTTL: 10 * time.Minute,
+			Timeout:  10 * time.Second,
+		},
+		Lockout: Lockout{
+			Enabled:          true,
+			AccountThreshold: 5,
+			IPThreshold:      20,
+			Window:           15 * time.Minute,
+			BaseDelay:        30 * time.Second,
+			MaxDelay:         15 * time.Minute,
+		},
+		RateLimit: RateLimit{
+			Public:        Limit{Requests: 60, Period: time.Minute, Key: RateLimitByIP},
+			Protected:     Limit{Requests: 120, Period: time.Minute, Key: RateLimitBySubject},
+			Introspection: Limit{Requests: 600, Period: time.Minute, Key: RateLimitByIP},
+			PasswordReset: Limit{Requests: 5, Period: time.Minute, Key: RateLimitByIP},
+		},
+		Notifier: Notifier{
+			Driver: NotifierLog,
 		},
 		Storage: Storage{
+			Driver:       Postgres,
 			MinConns:     1,
 			MaxConns:     1,
 			StartTimeout: 60 * time.Second,
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// APIKey is a long-lived credential of the user for machine access,
+// only its hash is stored. The prefix is kept to tell the keys apart.
+type APIKey struct {
+	ID     string   `json:"id"`
+	UserID string   `json:"user_id"`
+	Name   string   `json:"name"`
+	Prefix string   `json:"prefix"`
+	Hash   []byte   `json:"-"`
+	Scopes []string `json:"scopes"`
+	// ExpiresAt is zero for keys that do not expire
+	ExpiresAt  time.Time `json:"expires_at,omitempty"`
+	LastUsedAt time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Active reports whether the key may be used at the time.
+func (k *APIKey) Active(at time.Time) bool {
+	return k.RevokedAt.IsZero() && (k.ExpiresAt.IsZero() || at.Before(k.ExpiresAt))
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Identity links an account at an external identity provider to the user.
+// Accounts are identified by the issuer and the subject, which is stable
+// unlike the email (OpenID Connect Core, section 5.7).
+type Identity struct {
+	Issuer   string `json:"issuer"`
+	Subject  string `json:"subject"`
+	UserID   string `json:"user_id"`
+	Provider string `json:"provider"`
+	// Email is the one asserted by the provider when the link was made
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ExternalLogin is a pending login at an external identity provider,
+// only the hash of the state is stored.
+type ExternalLogin struct {
+	StateHash    []byte
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
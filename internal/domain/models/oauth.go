@@ -0,0 +1,59 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// OAuth grant types
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+)
+
+// Client is an application registered to obtain tokens with OAuth 2.0.
+type Client struct {
+	ID string `json:"client_id"`
+	// SecretHash is empty for public clients, which cannot keep a secret
+	SecretHash   []byte    `json:"-"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	GrantTypes   []string  `json:"grant_types"`
+	Scopes       []string  `json:"scopes"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func (c *Client) Public() bool {
+	return len(c.SecretHash) == 0
+}
+
+func (c *Client) AllowsGrant(grant string) bool {
+	return slices.Contains(c.GrantTypes, grant)
+}
+
+// AllowsRedirect reports whether the URI is registered, URIs are compared
+// as strings with no normalization (RFC 6749, section 3.1.2.3).
+func (c *Client) AllowsRedirect(uri string) bool {
+	return slices.Contains(c.RedirectURIs, uri)
+}
+
+// AuthorizationCode is a single-use code, only its hash is stored.
+type AuthorizationCode struct {
+	Hash        []byte
+	ClientID    string
+	UserID      string
+	RedirectURI string
+	// RedirectURIGiven tells whether the authorization request carried
+	// the redirect URI, the token request has to repeat it then
+	RedirectURIGiven bool
+	Scope            string
+	CodeChallenge    string
+	// AMR are the methods the user was authenticated with
+	AMR []string
+	// AuthTime is when the user was authenticated, zero if unknown
+	AuthTime time.Time
+	// Nonce is passed on to the ID token (OpenID Connect)
+	Nonce     string
+	ExpiresAt time.Time
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// RefreshToken is the server-side record of an issued refresh token.
+//
+// Tokens rotated from the same login share a FamilyID, which allows
+// revoking the whole chain once reuse of a rotated token is detected.
+type RefreshToken struct {
+	ID        string    `json:"id"`
+	FamilyID  string    `json:"family_id"`
+	UserID    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	RotatedAt time.Time `json:"rotated_at,omitempty"`
+	RevokedAt time.Time `json:"revoked_at,omitempty"`
+}
+
+// Active reports whether the token can still be exchanged.
+func (t *RefreshToken) Active(now time.Time) bool {
+	return t.RotatedAt.IsZero() && t.RevokedAt.IsZero() && now.Before(t.ExpiresAt)
+}
+
+// ResetToken is a single-use password reset token, only its hash is stored.
+type ResetToken struct {
+	Hash      []byte    `json:"-"`
+	UserID    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -1,14 +1,38 @@
 package models
 
-import "log/slog"
+import (
+	"log/slog"
+	"slices"
+)
+
+// Roles
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
 
 type User struct {
-	ID           string `json:"id"`
-	Email        string `json:"email"`
-	PasswordHash []byte `json:"-"`
-	// Role         string `json:"role"`
+	ID            string   `json:"id"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	PasswordHash  []byte   `json:"-"`
+	Role          string   `json:"role"`
+	Permissions   []string `json:"permissions,omitempty"`
 }
 
 func (u *User) LogValue() slog.Value {
 	return slog.StringValue(u.ID)
 }
+
+func (u *User) HasPermission(p string) bool {
+	return slices.Contains(u.Permissions, p)
+}
+
+// TOTP is the second factor of the user, usable once confirmed.
+type TOTP struct {
+	UserID    string
+	Secret    string
+	Confirmed bool
+	// LastStep is the time step of the last accepted code
+	LastStep int64
+}
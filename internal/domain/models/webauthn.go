@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// WebAuthnCredential is a public key credential (passkey) of the user.
+type WebAuthnCredential struct {
+	ID     []byte `json:"id"`
+	UserID string `json:"user_id"`
+	// PublicKey is the COSE encoded key
+	PublicKey []byte `json:"-"`
+	AAGUID    []byte `json:"aaguid"`
+	// SignCount is the last signature counter reported by the authenticator
+	SignCount  uint32    `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at,omitempty"`
+}
+
+// WebAuthnChallenge is a pending ceremony, only the challenge hash is stored.
+type WebAuthnChallenge struct {
+	Hash []byte
+	// UserID is empty for logins with discoverable credentials
+	UserID    string
+	Type      string
+	ExpiresAt time.Time
+}
@@ -0,0 +1,259 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/lib/api"
+	"github.com/korikhin/auth/internal/lib/apikey"
+	"github.com/korikhin/auth/internal/lib/http/codec"
+	"github.com/korikhin/auth/internal/lib/jwt"
+	"github.com/korikhin/auth/internal/lib/logger"
+	"github.com/korikhin/auth/internal/storage"
+
+	jwtMW "github.com/korikhin/auth/internal/http-server/middleware/jwt"
+	reqMW "github.com/korikhin/auth/internal/http-server/middleware/request"
+
+	"github.com/gorilla/mux"
+)
+
+type Storage interface {
+	storage.UserProvider
+	storage.APIKeyStorage
+}
+
+type CreateRequest struct {
+	Name string `json:"name" validate:"required,max=100"`
+	// Scopes are permissions of the user the key is limited to
+	Scopes []string `json:"scopes" validate:"dive,required,printascii"`
+	// ExpiresAt is optional, keys without it are valid until revoked
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// APIKey is the public view of a stored key.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// CreateResponse carries the key, shown only once on creation.
+type CreateResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+var (
+	errKeyNotFound  = api.Error("api key not found")
+	errUserNotFound = api.Error("user not found")
+	errScopes       = api.Error("bad request", "field scopes must hold permissions of the user")
+	errExpiresAt    = api.Error("bad request", "field expires_at must be in the future")
+)
+
+// Create issues a key for the current user.
+func Create(log *slog.Logger, s Storage) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.apikeys.Create"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		req := &CreateRequest{}
+		if err := codec.DecodeJSON(r.Body, req); err != nil {
+			log.Error("failed to decode request body", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+			return
+		}
+		if err := api.Validate(req); err != nil {
+			log.Error("bad request", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+			log.Info("key expires in the past")
+			codec.ResponseJSON(w, errExpiresAt, http.StatusBadRequest)
+			return
+		}
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().ReadTimeout)
+		defer cancel()
+
+		userID := subject(r)
+		user, err := s.User(ctxStorage, userID)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				log.Warn("user not found", logger.Error(err))
+				codec.ResponseJSON(w, errUserNotFound, http.StatusNotFound)
+				return
+			}
+			log.Error("failed to get user", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		// Keys cannot grant more than the user has
+		scopes := slices.Clone(req.Scopes)
+		slices.Sort(scopes)
+		scopes = slices.Compact(scopes)
+		for _, sc := range scopes {
+			if !user.HasPermission(sc) {
+				log.Info("scope is not granted to the user", slog.String("scope", sc))
+				codec.ResponseJSON(w, errScopes, http.StatusBadRequest)
+				return
+			}
+		}
+
+		id, err := jwt.NewID()
+		if err != nil {
+			log.Error("failed to create key ID", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		key, prefix, hash, err := apikey.Generate()
+		if err != nil {
+			log.Error("failed to create key", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		k := models.APIKey{
+			ID:        id,
+			UserID:    user.ID,
+			Name:      req.Name,
+			Prefix:    prefix,
+			Hash:      hash,
+			Scopes:    scopes,
+			CreatedAt: now,
+		}
+		if req.ExpiresAt != nil {
+			k.ExpiresAt = *req.ExpiresAt
+		}
+
+		ctxSave, cancelSave := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+		defer cancelSave()
+
+		if err := s.SaveAPIKey(ctxSave, &k); err != nil {
+			log.Error("failed to save key", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		log.Info("api key created", slog.String("user_id", user.ID), slog.String("key_id", k.ID))
+		codec.ResponseJSON(w, CreateResponse{APIKey: view(k), Key: key}, http.StatusCreated)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// List returns the keys of the current user, revoked ones included.
+func List(log *slog.Logger, s Storage) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.apikeys.List"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().ReadTimeout)
+		defer cancel()
+
+		keys, err := s.APIKeys(ctxStorage, subject(r))
+		if err != nil {
+			log.Error("failed to get keys", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		resp := make([]APIKey, 0, len(keys))
+		for _, k := range keys {
+			resp = append(resp, view(k))
+		}
+
+		codec.ResponseJSON(w, resp, http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// Revoke revokes the key of the current user given by its ID,
+// the key stops working at once.
+func Revoke(log *slog.Logger, s Storage) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.apikeys.Revoke"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		id := mux.Vars(r)["id"]
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+		defer cancel()
+
+		userID := subject(r)
+		if err := s.RevokeAPIKey(ctxStorage, userID, id); err != nil {
+			if errors.Is(err, storage.ErrAPIKeyNotFound) {
+				log.Info("key not found", logger.Error(err))
+				codec.ResponseJSON(w, errKeyNotFound, http.StatusNotFound)
+				return
+			}
+			log.Error("failed to revoke key", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		log.Info("api key revoked", slog.String("user_id", userID), slog.String("key_id", id))
+		codec.ResponseJSON(w, api.Ok("api key successfully revoked"), http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+func view(k models.APIKey) APIKey {
+	v := APIKey{
+		ID:        k.ID,
+		Name:      k.Name,
+		Prefix:    k.Prefix,
+		Scopes:    k.Scopes,
+		CreatedAt: k.CreatedAt,
+	}
+	if v.Scopes == nil {
+		v.Scopes = []string{}
+	}
+	if !k.ExpiresAt.IsZero() {
+		v.ExpiresAt = &k.ExpiresAt
+	}
+	if !k.LastUsedAt.IsZero() {
+		v.LastUsedAt = &k.LastUsedAt
+	}
+	if !k.RevokedAt.IsZero() {
+		v.RevokedAt = &k.RevokedAt
+	}
+
+	return v
+}
+
+func subject(r *http.Request) string {
+	if c := jwtMW.GetClaims(r.Context()); c != nil {
+		return c.Subject
+	}
+
+	return ""
+}
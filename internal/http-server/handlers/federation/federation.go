@@ -0,0 +1,412 @@
+// Package federation logs users in with external OpenID Connect providers.
+// The browser is redirected to the provider and back to the callback,
+// which starts the session and sends the browser on to the frontend.
+package federation
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/korikhin/auth/internal/config"
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/lib/api"
+	"github.com/korikhin/auth/internal/lib/http/codec"
+	"github.com/korikhin/auth/internal/lib/jwt"
+	"github.com/korikhin/auth/internal/lib/logger"
+	"github.com/korikhin/auth/internal/lib/oidc"
+	"github.com/korikhin/auth/internal/lib/password"
+	"github.com/korikhin/auth/internal/lib/session"
+	"github.com/korikhin/auth/internal/storage"
+
+	reqMW "github.com/korikhin/auth/internal/http-server/middleware/request"
+
+	"github.com/gorilla/mux"
+)
+
+// The state is bound to the browser as well, so that nobody can
+// complete a login started by someone else
+const stateCookie = "_example.com.st"
+
+// Errors passed to the frontend as `?error=`
+const (
+	errAccessDenied     = "access_denied"
+	errInvalidState     = "invalid_state"
+	errProviderError    = "provider_error"
+	errEmailNotVerified = "email_not_verified"
+	errAccountExists    = "account_exists"
+	errServerError      = "server_error"
+)
+
+var (
+	errUnverifiedEmail = errors.New("email is not verified")
+	errUnlinkable      = errors.New("account with the email cannot be linked")
+)
+
+type Storage interface {
+	storage.UserProvider
+	storage.UserSaver
+	storage.SessionStorage
+	storage.MFAStorage
+	storage.IdentityStorage
+}
+
+// Providers are the configured providers by name.
+type Providers map[string]*oidc.Provider
+
+func NewProviders(c config.Federation) Providers {
+	p := make(Providers, len(c.Providers))
+	for _, ip := range c.Providers {
+		p[ip.Name] = oidc.New(ip, c.Timeout)
+	}
+
+	return p
+}
+
+type ProviderList struct {
+	Providers []string `json:"providers"`
+}
+
+// List returns the names of the providers for the frontend to offer.
+func List(c config.Federation) http.Handler {
+	names := make([]string, 0, len(c.Providers))
+	for _, ip := range c.Providers {
+		names = append(names, ip.Name)
+	}
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		codec.ResponseJSON(w, ProviderList{Providers: names}, http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// Start redirects the browser to the provider. The state, the nonce
+// and the PKCE verifier of the login are kept until the callback.
+func Start(log *slog.Logger, s Storage, p Providers, c config.Federation) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.federation.Start"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		provider, ok := p[mux.Vars(r)["provider"]]
+		if !ok {
+			codec.ResponseJSON(w, api.Error("provider not found"), http.StatusNotFound)
+			return
+		}
+
+		log = log.With(slog.String("provider", provider.Name()))
+
+		state, stateHash, err := password.NewToken()
+		if err != nil {
+			log.Error("failed to create state", logger.Error(err))
+			fail(w, r, c, errServerError)
+			return
+		}
+		nonce, _, err := password.NewToken()
+		if err != nil {
+			log.Error("failed to create nonce", logger.Error(err))
+			fail(w, r, c, errServerError)
+			return
+		}
+		verifier, _, err := password.NewToken()
+		if err != nil {
+			log.Error("failed to create code verifier", logger.Error(err))
+			fail(w, r, c, errServerError)
+			return
+		}
+
+		ctxProvider, cancel := context.WithTimeout(context.Background(), c.Timeout)
+		defer cancel()
+
+		authURL, err := provider.AuthURL(ctxProvider, state, nonce, verifier)
+		if err != nil {
+			log.Error("failed to get provider metadata", logger.Error(err))
+			fail(w, r, c, errProviderError)
+			return
+		}
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+		defer cancel()
+
+		l := &models.ExternalLogin{
+			StateHash:    stateHash,
+			Provider:     provider.Name(),
+			Nonce:        nonce,
+			CodeVerifier: verifier,
+			ExpiresAt:    time.Now().Add(c.StateTTL),
+		}
+		if err := s.SaveExternalLogin(ctxStorage, l); err != nil {
+			log.Error("failed to save login", logger.Error(err))
+			fail(w, r, c, errServerError)
+			return
+		}
+
+		setState(w, state, c.StateTTL)
+		http.Redirect(w, r, authURL, http.StatusFound)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// Callback completes the login the provider redirects back with.
+// The external identity is linked to the user with the same email on
+// first login, or to a new user, provided the provider has verified it.
+// The session is started as with the password, users with the second
+// factor are sent to the frontend with an MFA challenge instead.
+func Callback(log *slog.Logger, a *jwt.JWTService, s Storage, p Providers, c config.Federation, cv config.Verification) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.federation.Callback"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		provider, ok := p[mux.Vars(r)["provider"]]
+		if !ok {
+			codec.ResponseJSON(w, api.Error("provider not found"), http.StatusNotFound)
+			return
+		}
+
+		log = log.With(slog.String("provider", provider.Name()))
+
+		q := r.URL.Query()
+		cookie, _ := r.Cookie(stateCookie)
+		clearState(w)
+
+		// The state is checked first, errors of a login started
+		// elsewhere are not to be shown
+		state := q.Get("state")
+		if state == "" || cookie == nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+			log.Warn("state does not match the browser")
+			fail(w, r, c, errInvalidState)
+			return
+		}
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+		defer cancel()
+
+		l, err := s.ConsumeExternalLogin(ctxStorage, password.HashToken(state))
+		if err != nil {
+			if errors.Is(err, storage.ErrExternalLoginInvalid) {
+				log.Warn("login is invalid or expired", logger.Error(err))
+				fail(w, r, c, errInvalidState)
+				return
+			}
+			log.Error("failed to get login", logger.Error(err))
+			fail(w, r, c, errServerError)
+			return
+		}
+		if l.Provider != provider.Name() {
+			log.Warn("login was started with another provider", slog.String("started_with", l.Provider))
+			fail(w, r, c, errInvalidState)
+			return
+		}
+
+		if e := q.Get("error"); e != "" {
+			log.Info("provider refused the login", slog.String("error", e), slog.String("description", q.Get("error_description")))
+			fail(w, r, c, errAccessDenied)
+			return
+		}
+
+		ctxProvider, cancel := context.WithTimeout(context.Background(), c.Timeout)
+		defer cancel()
+
+		claims, err := provider.Exchange(ctxProvider, q.Get("code"), l.CodeVerifier, l.Nonce)
+		if err != nil {
+			log.Error("failed to complete login with provider", logger.Error(err))
+			fail(w, r, c, errProviderError)
+			return
+		}
+
+		log = log.With(slog.String("subject", claims.Subject))
+
+		user, err := resolveUser(s, provider, claims)
+		if err != nil {
+			switch {
+			case errors.Is(err, errUnverifiedEmail):
+				log.Info("email is not verified by provider")
+				fail(w, r, c, errEmailNotVerified)
+			case errors.Is(err, errUnlinkable):
+				log.Warn("account with the email cannot be linked", logger.Error(err))
+				fail(w, r, c, errAccountExists)
+			default:
+				log.Error("failed to resolve user", logger.Error(err))
+				fail(w, r, c, errServerError)
+			}
+			return
+		}
+
+		log = log.With(slog.String("user_id", user.ID))
+
+		if cv.Required && !user.EmailVerified {
+			log.Info("email is not verified")
+			fail(w, r, c, errEmailNotVerified)
+			return
+		}
+
+		enrolled, err := session.MFAEnrolled(s, user.ID)
+		if err != nil {
+			log.Error("failed to get second factor", logger.Error(err))
+			fail(w, r, c, errServerError)
+			return
+		}
+		if enrolled {
+			token, exp, err := a.IssueMFAChallenge(user, []string{jwt.AMRFederated})
+			if err != nil {
+				log.Error("cannot issue mfa challenge", logger.Error(err))
+				fail(w, r, c, errServerError)
+				return
+			}
+
+			log.Info("second factor required")
+
+			// The fragment is not sent to servers along the way
+			frag := url.Values{
+				"mfa_token":  {token},
+				"expires_in": {fmt.Sprint(int64(time.Until(exp).Seconds()))},
+			}
+			http.Redirect(w, r, c.RedirectURL+"#"+frag.Encode(), http.StatusFound)
+			return
+		}
+
+		ctxSession, cancel := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+		defer cancel()
+
+		tokens, err := session.Start(ctxSession, a, s, user, []string{jwt.AMRFederated})
+		if err != nil {
+			log.Error("cannot issue tokens", logger.Error(err))
+			fail(w, r, c, errServerError)
+			return
+		}
+
+		// The frontend gets the access token with the refresh token cookie
+		log.Info("user logged in with provider")
+		jwt.SetRefreshToken(w, tokens.Refresh, tokens.RefreshExp)
+		http.Redirect(w, r, c.RedirectURL, http.StatusFound)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// resolveUser returns the user the identity is linked to. Unknown
+// identities are linked by the email, which the provider must have
+// verified. Existing accounts are linked only if their email is verified
+// too, as an unverified one may have been registered by someone else
+// to take over the account once its owner logs in.
+func resolveUser(s Storage, p *oidc.Provider, claims *oidc.Claims) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+	defer cancel()
+
+	i, err := s.Identity(ctx, p.Issuer(), claims.Subject)
+	if err == nil {
+		return s.User(ctx, i.UserID)
+	}
+	if !errors.Is(err, storage.ErrIdentityNotFound) {
+		return nil, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errUnverifiedEmail
+	}
+
+	user, err := s.UserByEmail(ctx, claims.Email)
+	switch {
+	case err == nil:
+		if !user.EmailVerified {
+			return nil, fmt.Errorf("%w: user %s", errUnlinkable, user.ID)
+		}
+	case errors.Is(err, storage.ErrUserNotFound):
+		if user, err = createUser(ctx, s, claims.Email); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	identity := &models.Identity{
+		Issuer:   p.Issuer(),
+		Subject:  claims.Subject,
+		UserID:   user.ID,
+		Provider: p.Name(),
+		Email:    claims.Email,
+	}
+	if err := s.SaveIdentity(ctx, identity); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// createUser registers the user with no password, one can be set
+// with the password reset later.
+func createUser(ctx context.Context, s Storage, email string) (*models.User, error) {
+	id, err := s.SaveUser(ctx, email, []byte{})
+	if err != nil {
+		if errors.Is(err, storage.ErrUserAlreadyExists) {
+			return nil, fmt.Errorf("%w: %w", errUnlinkable, err)
+		}
+		return nil, err
+	}
+
+	userID := fmt.Sprint(id)
+	if err := s.VerifyUserEmail(ctx, userID, email); err != nil {
+		return nil, err
+	}
+
+	return s.User(ctx, userID)
+}
+
+// fail sends the browser back to the frontend with the error.
+func fail(w http.ResponseWriter, r *http.Request, c config.Federation, code string) {
+	u, err := url.Parse(c.RedirectURL)
+	if err != nil {
+		http.Error(w, "Login failed", http.StatusInternalServerError)
+		return
+	}
+
+	q := u.Query()
+	q.Set("error", code)
+	u.RawQuery = q.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// The cookie has to come along with the redirect from the provider,
+// which is a cross-site navigation
+func setState(w http.ResponseWriter, state string, ttl time.Duration) {
+	c := &http.Cookie{
+		Name:     stateCookie,
+		Value:    state,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(ttl.Seconds()),
+	}
+
+	http.SetCookie(w, c)
+}
+
+func clearState(w http.ResponseWriter) {
+	c := &http.Cookie{
+		Name:     stateCookie,
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	}
+
+	http.SetCookie(w, c)
+}
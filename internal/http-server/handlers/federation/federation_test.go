@@ -0,0 +1,119 @@
+package federation
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/korikhin/auth/internal/config"
+	"github.com/korikhin/auth/internal/lib/oidc"
+	"github.com/korikhin/auth/internal/storage"
+	"github.com/korikhin/auth/internal/storage/memory"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const email = "user@example.com"
+
+func newStorage() *memory.Storage {
+	return memory.New(config.Storage{ReadTimeout: time.Second, WriteTimeout: time.Second})
+}
+
+func newProvider() *oidc.Provider {
+	return oidc.New(config.IdentityProvider{Name: "fake", Issuer: "https://idp.example"}, time.Second)
+}
+
+func idClaims(subject, email string, verified bool) *oidc.Claims {
+	return &oidc.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: subject},
+		Email:            email,
+		EmailVerified:    verified,
+	}
+}
+
+func TestResolveUserCreates(t *testing.T) {
+	s, p := newStorage(), newProvider()
+
+	user, err := resolveUser(s, p, idClaims("subject", email, true))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if user.Email != email || !user.EmailVerified || len(user.PasswordHash) != 0 {
+		t.Errorf("user = %+v", user)
+	}
+
+	// The identity is linked, so the email no longer matters
+	again, err := resolveUser(s, p, idClaims("subject", "other@example.com", false))
+	if err != nil {
+		t.Fatalf("linked identity: %v", err)
+	}
+	if again.ID != user.ID {
+		t.Errorf("user ID = %s, want %s", again.ID, user.ID)
+	}
+}
+
+func TestResolveUserLinks(t *testing.T) {
+	s, p := newStorage(), newProvider()
+	ctx := context.Background()
+
+	id, err := s.SaveUser(ctx, email, []byte("hash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID := strconv.FormatUint(id, 10)
+	if err := s.VerifyUserEmail(ctx, userID, email); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := resolveUser(s, p, idClaims("subject", email, true))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if user.ID != userID {
+		t.Errorf("user ID = %s, want %s", user.ID, userID)
+	}
+}
+
+// An account registered with the email but never verified may belong
+// to an attacker waiting for the owner to log in with the provider.
+func TestResolveUserTakeover(t *testing.T) {
+	s, p := newStorage(), newProvider()
+	ctx := context.Background()
+
+	if _, err := s.SaveUser(ctx, email, []byte("hash")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := resolveUser(s, p, idClaims("subject", email, true)); !errors.Is(err, errUnlinkable) {
+		t.Fatalf("error = %v, want %v", err, errUnlinkable)
+	}
+
+	if _, err := s.Identity(ctx, p.Issuer(), "subject"); !errors.Is(err, storage.ErrIdentityNotFound) {
+		t.Errorf("identity is linked: error = %v", err)
+	}
+}
+
+func TestResolveUserUnverifiedEmail(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims *oidc.Claims
+	}{
+		{name: "not verified", claims: idClaims("subject", email, false)},
+		{name: "missing", claims: idClaims("subject", "", true)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, p := newStorage(), newProvider()
+
+			if _, err := resolveUser(s, p, tt.claims); !errors.Is(err, errUnverifiedEmail) {
+				t.Fatalf("error = %v, want %v", err, errUnverifiedEmail)
+			}
+			if _, err := s.UserByEmail(context.Background(), email); !errors.Is(err, storage.ErrUserNotFound) {
+				t.Errorf("user is created: error = %v", err)
+			}
+		})
+	}
+}
@@ -4,14 +4,34 @@ import (
 	"log/slog"
 	"net/http"
 
+	"github.com/korikhin/auth/internal/config"
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/http-server/handlers/apikeys"
 	"github.com/korikhin/auth/internal/http-server/handlers/authn"
+	"github.com/korikhin/auth/internal/http-server/handlers/federation"
 	"github.com/korikhin/auth/internal/http-server/handlers/health"
+	"github.com/korikhin/auth/internal/http-server/handlers/introspect"
+	"github.com/korikhin/auth/internal/http-server/handlers/jwks"
 	"github.com/korikhin/auth/internal/http-server/handlers/login"
+	"github.com/korikhin/auth/internal/http-server/handlers/logout"
+	"github.com/korikhin/auth/internal/http-server/handlers/mfa"
+	"github.com/korikhin/auth/internal/http-server/handlers/oauth"
+	"github.com/korikhin/auth/internal/http-server/handlers/passkey"
+	"github.com/korikhin/auth/internal/http-server/handlers/password"
+	"github.com/korikhin/auth/internal/http-server/handlers/refresh"
 	"github.com/korikhin/auth/internal/http-server/handlers/register"
+	"github.com/korikhin/auth/internal/http-server/handlers/users"
+	"github.com/korikhin/auth/internal/http-server/handlers/verify"
 	"github.com/korikhin/auth/internal/lib/jwt"
-	storage "github.com/korikhin/auth/internal/storage/postgres"
+	"github.com/korikhin/auth/internal/lib/lockout"
+	"github.com/korikhin/auth/internal/lib/notify"
+	pwd "github.com/korikhin/auth/internal/lib/password"
+	"github.com/korikhin/auth/internal/lib/webauthn"
+	"github.com/korikhin/auth/internal/storage"
 
+	authzMW "github.com/korikhin/auth/internal/http-server/middleware/authz"
 	jwtMW "github.com/korikhin/auth/internal/http-server/middleware/jwt"
+	rateMW "github.com/korikhin/auth/internal/http-server/middleware/ratelimit"
 	reqMW "github.com/korikhin/auth/internal/http-server/middleware/request"
 
 	"github.com/gorilla/mux"
@@ -19,37 +39,310 @@ import (
 
 // TODO: Replace with net/http someday
 func NewRouter() *mux.Router {
-	return mux.NewRouter().PathPrefix("/api").Subrouter()
+	return mux.NewRouter()
 }
 
-func Public(r *mux.Router, log *slog.Logger, a *jwt.JWTService, s *storage.Storage) {
+// API returns the subrouter for the versioned API endpoints.
+func API(r *mux.Router) *mux.Router {
+	return r.PathPrefix("/api").Subrouter()
+}
+
+// WellKnown registers the discovery endpoints, which must be served
+// from the root regardless of the API prefix.
+func WellKnown(r *mux.Router, a *jwt.JWTService) {
+	jwks := jwks.New(a)
+	r.Handle("/.well-known/jwks.json", jwks).Methods(http.MethodGet)
+}
+
+func Public(r *mux.Router, log *slog.Logger, a *jwt.JWTService, s storage.Storage, h pwd.Hasher, g *lockout.Guard, n notify.Notifier, cv config.Verification, cr config.Registration, rl config.Limit) {
 	p := r.PathPrefix("/").Subrouter()
 
 	// MWs
 	empMW := reqMW.NotEmpty(log)
+	rateMW := rateMW.New(log, rl)
+
+	p.Use(rateMW)
 
 	health := health.New()
 	p.Handle("/v1/health", health)
 
-	register := register.New(log, s)
+	register := register.New(log, a, s, h, n, cv, cr)
 	p.Handle("/v1/users", empMW(register)).Methods(http.MethodPost)
 
-	login := login.New(log, a, s)
+	verifyEmail := verify.New(log, a, s)
+	p.Handle("/v1/users/verify", empMW(verifyEmail)).Methods(http.MethodPost)
+
+	resendVerification := verify.Resend(log, a, s, n, cv)
+	p.Handle("/v1/users/verify/resend", empMW(resendVerification)).Methods(http.MethodPost)
+
+	login := login.New(log, a, s, h, g, cv)
 	p.Handle("/v1/auth", empMW(login)).Methods(http.MethodPost)
+
+	verifyMFA := mfa.Verify(log, a, s, g)
+	p.Handle("/v1/auth/mfa", empMW(verifyMFA)).Methods(http.MethodPost)
+
+	// The body is optional, browsers send the cookie only
+	refresh := refresh.New(log, a, s)
+	p.Handle("/v1/auth/refresh", refresh).Methods(http.MethodPost)
+}
+
+// Introspection registers the token introspection endpoint (RFC 7662),
+// authenticated with its own client credentials rather than user tokens.
+func Introspection(r *mux.Router, log *slog.Logger, a *jwt.JWTService, s storage.Storage, c config.Introspection, rl config.Limit) {
+	if c.ClientID == "" || c.ClientSecret == "" {
+		log.Info("introspection is disabled")
+		return
+	}
+
+	p := r.PathPrefix("/").Subrouter()
+
+	// MWs
+	empMW := reqMW.NotEmpty(log)
+	rateMW := rateMW.New(log, rl)
+
+	p.Use(rateMW)
+
+	introspect := introspect.New(log, a, s, c)
+	p.Handle("/v1/introspect", empMW(introspect)).Methods(http.MethodPost)
 }
 
-func Protected(r *mux.Router, log *slog.Logger, a *jwt.JWTService, s *storage.Storage) {
+// PasswordReset registers the password reset flow,
+// reset links are delivered with the notifier.
+func PasswordReset(r *mux.Router, log *slog.Logger, s storage.Storage, h pwd.Hasher, n notify.Notifier, c config.Password, rl config.Limit) {
 	p := r.PathPrefix("/").Subrouter()
 
 	// MWs
-	// empMW := reqMW.NotEmpty(log)
-	jwtMW := jwtMW.New(log, a, s)
+	empMW := reqMW.NotEmpty(log)
+	rateMW := rateMW.New(log, rl)
+
+	p.Use(rateMW)
+
+	requestReset := password.RequestReset(log, s, n, c)
+	p.Handle("/v1/password/reset", empMW(requestReset)).Methods(http.MethodPost)
+
+	confirmReset := password.ConfirmReset(log, s, h)
+	p.Handle("/v1/password/reset/confirm", empMW(confirmReset)).Methods(http.MethodPost)
+}
+
+// WebAuthn registers the passkey ceremonies: registration for logged in
+// users and login as an alternative to the password.
+func WebAuthn(r *mux.Router, log *slog.Logger, a *jwt.JWTService, s storage.Storage, c config.WebAuthn, cv config.Verification, rlPublic, rlProtected config.Limit) {
+	if c.RPID == "" {
+		log.Info("webauthn is disabled")
+		return
+	}
 
-	p.Use(jwtMW)
+	rp := webauthn.New(c)
+
+	// Public
+	p := r.PathPrefix("/").Subrouter()
+
+	empMW := reqMW.NotEmpty(log)
+	p.Use(rateMW.New(log, rlPublic))
+
+	loginOptions := passkey.LoginOptions(log, s, rp)
+	p.Handle("/v1/webauthn/login/options", loginOptions).Methods(http.MethodPost)
+
+	login := passkey.Login(log, a, s, rp, cv)
+	p.Handle("/v1/webauthn/login", empMW(login)).Methods(http.MethodPost)
+
+	// Protected
+	q := r.PathPrefix("/").Subrouter()
+
+	sessMW := authzMW.RequireSession(log)
+	q.Use(limited(log, rlProtected, jwtMW.New(log, a, s))...)
+
+	registerOptions := passkey.RegisterOptions(log, s, rp)
+	q.Handle("/v1/webauthn/register/options", sessMW(registerOptions)).Methods(http.MethodPost)
+
+	register := passkey.Register(log, s, rp)
+	q.Handle("/v1/webauthn/register", sessMW(empMW(register))).Methods(http.MethodPost)
+
+	listCredentials := passkey.List(log, s)
+	q.Handle("/v1/webauthn/credentials", sessMW(listCredentials)).Methods(http.MethodGet)
+
+	deleteCredential := passkey.Delete(log, s)
+	q.Handle("/v1/webauthn/credentials/{id:[A-Za-z0-9_-]+}", sessMW(deleteCredential)).Methods(http.MethodDelete)
+}
+
+// OAuth registers the authorization server endpoints along with those
+// of the OpenID Connect provider. The authorization endpoint is called
+// by the frontend on behalf of the logged in user.
+func OAuth(r *mux.Router, log *slog.Logger, a *jwt.JWTService, s storage.Storage, c config.OAuth, rlPublic, rlProtected config.Limit) {
+	if !c.Enabled {
+		log.Info("oauth is disabled")
+		return
+	}
+
+	empMW := reqMW.NotEmpty(log)
+
+	// Public, the clients authenticate themselves
+	p := r.PathPrefix("/oauth").Subrouter()
+	p.Use(rateMW.New(log, rlPublic))
+
+	token := oauth.Token(log, a, s)
+	p.Handle("/token", empMW(token)).Methods(http.MethodPost)
+
+	// Protected
+	q := r.PathPrefix("/oauth").Subrouter()
+	q.Use(limited(log, rlProtected, jwtMW.New(log, a, s), authzMW.RequireSession(log))...)
+
+	authorize := oauth.Authorize(log, s)
+	q.Handle("/authorize", authorize).Methods(http.MethodGet)
+
+	decide := oauth.Decide(log, s, c)
+	q.Handle("/authorize", empMW(decide)).Methods(http.MethodPost)
+
+	// OpenID Connect, the tokens are those issued to the clients
+	u := r.PathPrefix("/oauth").Subrouter()
+	u.Use(limited(log, rlPublic, jwtMW.Client(log, a))...)
+
+	userInfo := oauth.UserInfo(log, s)
+	u.Handle("/userinfo", userInfo).Methods(http.MethodGet, http.MethodPost)
+
+	discovery := oauth.Discovery(a, c)
+	r.Handle("/.well-known/openid-configuration", discovery).Methods(http.MethodGet)
+}
+
+// Federation registers the logins with external identity providers,
+// driven by browser redirects rather than API calls.
+func Federation(r *mux.Router, log *slog.Logger, a *jwt.JWTService, s storage.Storage, c config.Federation, cv config.Verification, rl config.Limit) {
+	if len(c.Providers) == 0 {
+		log.Info("federation is disabled")
+		return
+	}
+
+	providers := federation.NewProviders(c)
+
+	p := r.PathPrefix("/").Subrouter()
+	p.Use(rateMW.New(log, rl))
+
+	list := federation.List(c)
+	p.Handle("/v1/auth/providers", list).Methods(http.MethodGet)
+
+	start := federation.Start(log, s, providers, c)
+	p.Handle("/v1/auth/providers/{provider:[a-z0-9-]+}", start).Methods(http.MethodGet)
+
+	callback := federation.Callback(log, a, s, providers, c, cv)
+	p.Handle("/v1/auth/providers/{provider:[a-z0-9-]+}/callback", callback).Methods(http.MethodGet)
+}
+
+// OAuthClients registers the client management for the admins.
+func OAuthClients(r *mux.Router, log *slog.Logger, a *jwt.JWTService, s storage.Storage, c config.OAuth, cm config.MFA, rl config.Limit) {
+	if !c.Enabled {
+		return
+	}
+
+	p := r.PathPrefix("/").Subrouter()
+
+	// MWs
+	empMW := reqMW.NotEmpty(log)
+	admMW := requireAdmin(log, cm)
+
+	p.Use(limited(log, rl, jwtMW.New(log, a, s))...)
+
+	createClient := oauth.CreateClient(log, s)
+	p.Handle("/v1/oauth/clients", admMW(empMW(createClient))).Methods(http.MethodPost)
+
+	listClients := oauth.ListClients(log, s)
+	p.Handle("/v1/oauth/clients", admMW(listClients)).Methods(http.MethodGet)
+
+	deleteClient := oauth.DeleteClient(log, s)
+	p.Handle("/v1/oauth/clients/{id:[A-Za-z0-9_-]+}", admMW(deleteClient)).Methods(http.MethodDelete)
+}
+
+func Protected(r *mux.Router, log *slog.Logger, a *jwt.JWTService, s storage.Storage, h pwd.Hasher, n notify.Notifier, cm config.MFA, cv config.Verification, rl config.Limit) {
+	p := r.PathPrefix("/").Subrouter()
+
+	// MWs
+	empMW := reqMW.NotEmpty(log)
+	admMW := requireAdmin(log, cm)
+	mfaMW := authzMW.RequireMFA(log)
+	// API keys only read the account, the routes changing it require
+	// a login session whatever the scopes of the key
+	sessMW := authzMW.RequireSession(log)
+
+	p.Use(limited(log, rl, jwtMW.New(log, a, s))...)
 
 	authn := authn.New()
-	p.Handle("/v1/auth", authn)
+	p.Handle("/v1/auth", authn).Methods(http.MethodGet)
+
+	logoutEverywhere := logout.Everywhere(log, s)
+	p.Handle("/v1/auth/sessions", sessMW(logoutEverywhere)).Methods(http.MethodDelete)
+
+	logout := logout.New(log, a, s)
+	p.Handle("/v1/auth", sessMW(logout)).Methods(http.MethodDelete)
+
+	me := users.Me(log, s)
+	p.Handle("/v1/users/me", me).Methods(http.MethodGet)
+
+	updateMe := users.UpdateMe(log, a, s, n, cv)
+	p.Handle("/v1/users/me", sessMW(empMW(updateMe))).Methods(http.MethodPatch)
+
+	changePassword := password.ChangeMe(log, s, h)
+	p.Handle("/v1/users/me/password", sessMW(empMW(changePassword))).Methods(http.MethodPost)
+
+	deleteMe := users.DeleteMe(log, s)
+	p.Handle("/v1/users/me", sessMW(deleteMe)).Methods(http.MethodDelete)
+
+	enrollMFA := mfa.Enroll(log, s, cm)
+	p.Handle("/v1/users/me/mfa/totp", sessMW(enrollMFA)).Methods(http.MethodPost)
+
+	confirmMFA := mfa.Confirm(log, s)
+	p.Handle("/v1/users/me/mfa/totp/confirm", sessMW(empMW(confirmMFA))).Methods(http.MethodPost)
+
+	disableMFA := mfa.Disable(log, s)
+	p.Handle("/v1/users/me/mfa", sessMW(mfaMW(disableMFA))).Methods(http.MethodDelete)
+
+	// Keys cannot manage keys, not even their own
+	createAPIKey := apikeys.Create(log, s)
+	p.Handle("/v1/api-keys", sessMW(empMW(createAPIKey))).Methods(http.MethodPost)
+
+	listAPIKeys := apikeys.List(log, s)
+	p.Handle("/v1/api-keys", sessMW(listAPIKeys)).Methods(http.MethodGet)
+
+	revokeAPIKey := apikeys.Revoke(log, s)
+	p.Handle("/v1/api-keys/{id:[A-Za-z0-9_-]+}", sessMW(revokeAPIKey)).Methods(http.MethodDelete)
+
+	// Admin only
+	listUsers := users.ListAll(log, s)
+	p.Handle("/v1/users", admMW(listUsers)).Methods(http.MethodGet)
+
+	getUser := users.Get(log, s)
+	p.Handle("/v1/users/{id:[0-9]+}", admMW(getUser)).Methods(http.MethodGet)
+
+	deleteUser := users.Delete(log, s)
+	p.Handle("/v1/users/{id:[0-9]+}", admMW(deleteUser)).Methods(http.MethodDelete)
+}
+
+// limited puts the rate limit in front of the authentication, so that
+// requests with invalid tokens are throttled as well. Subjects are known
+// once the token is validated, so limits by subject run after it and are
+// preceded by the same limit by client address.
+func limited(log *slog.Logger, rl config.Limit, auth ...mux.MiddlewareFunc) []mux.MiddlewareFunc {
+	pre := rl
+	if rl.Key == config.RateLimitBySubject {
+		pre.Key = config.RateLimitByIP
+	}
+
+	mws := append([]mux.MiddlewareFunc{rateMW.New(log, pre)}, auth...)
+	if rl.Key == config.RateLimitBySubject {
+		mws = append(mws, rateMW.New(log, rl))
+	}
+
+	return mws
+}
+
+// requireAdmin restricts the routes to admins, who may be required
+// to have passed the second factor.
+func requireAdmin(log *slog.Logger, cm config.MFA) func(next http.Handler) http.Handler {
+	roleMW := authzMW.RequireRole(log, models.RoleAdmin)
+	if !cm.AdminRequired {
+		return roleMW
+	}
 
-	// deleteUser := delete.New()
-	// p.Handle("/v1/users/{id}", deleteUser).Methods(http.MethodDelete)
+	mfaMW := authzMW.RequireMFA(log)
+	return func(next http.Handler) http.Handler {
+		return roleMW(mfaMW(next))
+	}
 }
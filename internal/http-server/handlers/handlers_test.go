@@ -0,0 +1,453 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/korikhin/auth/internal/config"
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/lib/jwt"
+	"github.com/korikhin/auth/internal/lib/lockout"
+	"github.com/korikhin/auth/internal/lib/notify"
+	pwd "github.com/korikhin/auth/internal/lib/password"
+	"github.com/korikhin/auth/internal/lib/session"
+	"github.com/korikhin/auth/internal/lib/webauthn"
+	"github.com/korikhin/auth/internal/storage/memory"
+
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+)
+
+// testEnv is the service on the memory storage, with the public routes
+// registered. Tests add the routes they need before serving.
+type testEnv struct {
+	log *slog.Logger
+	a   *jwt.JWTService
+	s   *memory.Storage
+	h   pwd.Hasher
+	n   notify.Notifier
+	api *mux.Router
+
+	router *mux.Router
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	pk, err := jwt.GenerateKey("ES256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pem, err := jwt.EncodePrivateKey(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := pwd.NewBcrypt(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := memory.New(config.Storage{ReadTimeout: time.Second, WriteTimeout: time.Second})
+
+	e := &testEnv{
+		log: log,
+		a: jwt.NewService(config.JWT{
+			Issuer:     "https://auth.example.com",
+			AccessTTL:  15 * time.Minute,
+			RefreshTTL: time.Hour,
+			VerifyTTL:  time.Hour,
+			MFATTL:     5 * time.Minute,
+			PrivateKey: string(pem),
+		}),
+		s:      s,
+		h:      h,
+		n:      notify.NewLog(log),
+		router: NewRouter(),
+	}
+	e.api = API(e.router)
+
+	g := lockout.New(config.Lockout{}, s)
+	Public(e.api, log, e.a, s, h, g, e.n, config.Verification{}, config.Registration{}, config.Limit{})
+
+	return e
+}
+
+// serve starts the server over TLS, as the cookies are secure, and returns
+// a client that keeps the cookies and does not follow redirects.
+func (e *testEnv) serve(t *testing.T) (*httptest.Server, *http.Client) {
+	t.Helper()
+
+	srv := httptest.NewTLSServer(e.router)
+	t.Cleanup(srv.Close)
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := srv.Client()
+	client.Jar = jar
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	return srv, client
+}
+
+// send makes a JSON request, the response body is decoded into out if set.
+func send(t *testing.T, client *http.Client, method, url, auth string, body, out any) int {
+	t.Helper()
+
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, url, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: %v", method, url, err)
+		}
+	}
+
+	return resp.StatusCode
+}
+
+// refreshWithCookie calls the refresh endpoint with whatever cookies
+// the client has for it.
+func refreshWithCookie(t *testing.T, srv *httptest.Server, client *http.Client) int {
+	t.Helper()
+
+	resp, err := client.Post(srv.URL+"/api/v1/auth/refresh", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+// fakeIDP is an OpenID Connect provider issuing ID tokens
+// with the nonce of the last authorization request.
+type fakeIDP struct {
+	srv *httptest.Server
+	key *ecdsa.PrivateKey
+
+	mu    sync.Mutex
+	nonce string
+}
+
+func newFakeIDP(t *testing.T) *fakeIDP {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeIDP{key: key}
+
+	m := http.NewServeMux()
+	m.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 f.srv.URL,
+			"authorization_endpoint": f.srv.URL + "/authorize",
+			"token_endpoint":         f.srv.URL + "/token",
+			"jwks_uri":               f.srv.URL + "/jwks",
+		})
+	})
+	m.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		k := &jwt.Key{ID: "idp", Method: gojwt.SigningMethodES256, Public: &key.PublicKey}
+		json.NewEncoder(w).Encode(jwt.JWKSet{Keys: []jwt.JWK{k.JWK()}})
+	})
+	m.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		nonce := f.nonce
+		f.mu.Unlock()
+
+		now := time.Now()
+		token := gojwt.NewWithClaims(gojwt.SigningMethodES256, gojwt.MapClaims{
+			"iss":            f.srv.URL,
+			"sub":            "subject",
+			"aud":            "client",
+			"iat":            now.Unix(),
+			"exp":            now.Add(time.Hour).Unix(),
+			"nonce":          nonce,
+			"email":          "user@example.com",
+			"email_verified": true,
+		})
+		token.Header["kid"] = "idp"
+
+		s, err := token.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": s})
+	})
+
+	f.srv = httptest.NewServer(m)
+	t.Cleanup(f.srv.Close)
+
+	return f
+}
+
+// authorize plays the provider's login page: it remembers the nonce
+// and returns the state to send the browser back with.
+func (f *fakeIDP) authorize(t *testing.T, location string) string {
+	t.Helper()
+
+	u, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.mu.Lock()
+	f.nonce = u.Query().Get("nonce")
+	f.mu.Unlock()
+
+	return u.Query().Get("state")
+}
+
+// The refresh token cookie set by the callback has to reach the refresh
+// endpoint, although the browser gets it from a provider route.
+func TestFederationCallbackRefresh(t *testing.T) {
+	e := newTestEnv(t)
+	idp := newFakeIDP(t)
+
+	c := config.Federation{
+		Providers: []config.IdentityProvider{{
+			Name:     "fake",
+			Issuer:   idp.srv.URL,
+			ClientID: "client",
+		}},
+		RedirectURL: "https://example.com/login/callback",
+		StateTTL:    time.Minute,
+		Timeout:     5 * time.Second,
+	}
+	Federation(e.api, e.log, e.a, e.s, c, config.Verification{}, config.Limit{})
+
+	srv, client := e.serve(t)
+
+	resp, err := client.Get(srv.URL + "/api/v1/auth/providers/fake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("start status = %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+	state := idp.authorize(t, resp.Header.Get("Location"))
+
+	q := url.Values{"code": {"code"}, "state": {state}}
+	resp, err = client.Get(srv.URL + "/api/v1/auth/providers/fake/callback?" + q.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if loc := resp.Header.Get("Location"); loc != c.RedirectURL {
+		t.Fatalf("callback redirects to %q, want %q", loc, c.RedirectURL)
+	}
+
+	if status := refreshWithCookie(t, srv, client); status != http.StatusOK {
+		t.Errorf("refresh status = %d, want %d", status, http.StatusOK)
+	}
+}
+
+// The refresh token cookie set by the passkey login has to reach
+// the refresh endpoint as well.
+func TestPasskeyLoginRefresh(t *testing.T) {
+	e := newTestEnv(t)
+
+	c := config.WebAuthn{
+		RPID:    "example.com",
+		Origins: []string{"https://example.com"},
+		Timeout: time.Minute,
+	}
+	WebAuthn(e.api, e.log, e.a, e.s, c, config.Verification{}, config.Limit{}, config.Limit{})
+
+	ctx := context.Background()
+	id, err := e.s.SaveUser(ctx, "user@example.com", []byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// COSE EC2 key: {1: 2, 3: -7, -1: 1, -2: x, -3: y}
+	cose := []byte{0xa5, 0x01, 0x02, 0x03, 0x26, 0x20, 0x01, 0x21, 0x58, 0x20}
+	cose = append(cose, key.X.FillBytes(make([]byte, 32))...)
+	cose = append(cose, 0x22, 0x58, 0x20)
+	cose = append(cose, key.Y.FillBytes(make([]byte, 32))...)
+
+	cred := &models.WebAuthnCredential{
+		ID:        []byte("credential-id"),
+		UserID:    fmt.Sprint(id),
+		PublicKey: cose,
+	}
+	if err := e.s.SaveWebAuthnCredential(ctx, cred); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, client := e.serve(t)
+
+	opts := &webauthn.RequestOptions{}
+	status := send(t, client, http.MethodPost, srv.URL+"/api/v1/webauthn/login/options", "",
+		map[string]string{"email": "user@example.com"}, opts)
+	if status != http.StatusOK {
+		t.Fatalf("options status = %d, want %d", status, http.StatusOK)
+	}
+
+	clientData, err := json.Marshal(map[string]string{
+		"type":      webauthn.TypeGet,
+		"challenge": opts.Challenge,
+		"origin":    "https://example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// User present and verified, the counter is at 1
+	rpIDHash := sha256.Sum256([]byte(c.RPID))
+	authData := binary.BigEndian.AppendUint32(append(rpIDHash[:], 0x05), 1)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertion := map[string]any{
+		"rawId": webauthn.Bytes(cred.ID),
+		"type":  "public-key",
+		"response": map[string]webauthn.Bytes{
+			"clientDataJSON":    clientData,
+			"authenticatorData": authData,
+			"signature":         sig,
+		},
+	}
+	status = send(t, client, http.MethodPost, srv.URL+"/api/v1/webauthn/login", "", assertion, nil)
+	if status != http.StatusOK {
+		t.Fatalf("login status = %d, want %d", status, http.StatusOK)
+	}
+
+	if status := refreshWithCookie(t, srv, client); status != http.StatusOK {
+		t.Errorf("refresh status = %d, want %d", status, http.StatusOK)
+	}
+}
+
+// API keys only read the account. Whatever changes it, or lists and
+// revokes the other keys, requires a login session.
+func TestAPIKeyWithoutScopes(t *testing.T) {
+	e := newTestEnv(t)
+	Protected(e.api, e.log, e.a, e.s, e.h, e.n, config.MFA{}, config.Verification{}, config.Limit{})
+	WebAuthn(e.api, e.log, e.a, e.s, config.WebAuthn{RPID: "example.com"}, config.Verification{}, config.Limit{}, config.Limit{})
+
+	ctx := context.Background()
+	id, err := e.s.SaveUser(ctx, "admin@example.com", []byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID := fmt.Sprint(id)
+	if err := e.s.SetUserRole(ctx, userID, models.RoleAdmin, []string{"read"}); err != nil {
+		t.Fatal(err)
+	}
+	user, err := e.s.User(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := session.Start(ctx, e.a, e.s, user, []string{jwt.AMRPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv, client := e.serve(t)
+	bearer := "Bearer " + tokens.Access
+
+	created := &struct {
+		ID  string `json:"id"`
+		Key string `json:"key"`
+	}{}
+	status := send(t, client, http.MethodPost, srv.URL+"/api/v1/api-keys", bearer,
+		map[string]any{"name": "ci", "scopes": []string{}}, created)
+	if status != http.StatusCreated {
+		t.Fatalf("create status = %d, want %d", status, http.StatusCreated)
+	}
+	key := "ApiKey " + created.Key
+
+	if status := send(t, client, http.MethodGet, srv.URL+"/api/v1/users/me", key, nil, nil); status != http.StatusOK {
+		t.Fatalf("GET /users/me status = %d, want %d", status, http.StatusOK)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		body   any
+	}{
+		{http.MethodDelete, "/api/v1/auth/sessions", nil},
+		{http.MethodDelete, "/api/v1/auth", nil},
+		{http.MethodPatch, "/api/v1/users/me", map[string]string{"email": "other@example.com"}},
+		{http.MethodPost, "/api/v1/users/me/password", map[string]string{"current_password": "x", "new_password": "y"}},
+		{http.MethodDelete, "/api/v1/users/me", nil},
+		{http.MethodPost, "/api/v1/users/me/mfa/totp", nil},
+		{http.MethodPost, "/api/v1/api-keys", map[string]string{"name": "more"}},
+		{http.MethodGet, "/api/v1/api-keys", nil},
+		{http.MethodDelete, "/api/v1/api-keys/" + created.ID, nil},
+		{http.MethodGet, "/api/v1/webauthn/credentials", nil},
+		{http.MethodPost, "/api/v1/webauthn/register/options", nil},
+		// Keys of admins are not admins
+		{http.MethodGet, "/api/v1/users", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			if status := send(t, client, tt.method, srv.URL+tt.path, key, tt.body, nil); status != http.StatusForbidden {
+				t.Errorf("status = %d, want %d", status, http.StatusForbidden)
+			}
+		})
+	}
+
+	// The key is still there for the session to see
+	if status := send(t, client, http.MethodGet, srv.URL+"/api/v1/api-keys", bearer, nil, nil); status != http.StatusOK {
+		t.Errorf("session GET /api-keys status = %d, want %d", status, http.StatusOK)
+	}
+}
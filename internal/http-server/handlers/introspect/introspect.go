@@ -0,0 +1,186 @@
+package introspect
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/korikhin/auth/internal/config"
+	"github.com/korikhin/auth/internal/lib/api"
+	httplib "github.com/korikhin/auth/internal/lib/http"
+	"github.com/korikhin/auth/internal/lib/http/codec"
+	"github.com/korikhin/auth/internal/lib/jwt"
+	"github.com/korikhin/auth/internal/lib/logger"
+	"github.com/korikhin/auth/internal/storage"
+
+	reqMW "github.com/korikhin/auth/internal/http-server/middleware/request"
+)
+
+// Token type hints and types (RFC 7009, RFC 7662)
+const (
+	typeAccess  = "access_token"
+	typeRefresh = "refresh_token"
+)
+
+// Response follows RFC 7662, section 2.2.
+type Response struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	Iat       int64    `json:"iat,omitempty"`
+	Sub       string   `json:"sub,omitempty"`
+	Aud       []string `json:"aud,omitempty"`
+	Iss       string   `json:"iss,omitempty"`
+	Jti       string   `json:"jti,omitempty"`
+}
+
+var inactive = Response{Active: false}
+
+func New(log *slog.Logger, a *jwt.JWTService, s storage.SessionStorage, c config.Introspection) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.introspect.New"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		if !authorized(r, c) {
+			log.Warn("client authentication failed")
+			w.Header().Set(httplib.HeaderWWWAuthenticate, `Basic realm="introspection"`)
+			codec.ResponseJSON(w, api.Error("invalid client"), http.StatusUnauthorized)
+			return
+		}
+
+		token := r.PostFormValue("token")
+		if token == "" {
+			log.Error("bad request: token is missing")
+			codec.ResponseJSON(w, api.Error("bad request", "field token is required"), http.StatusBadRequest)
+			return
+		}
+
+		opts := jwt.ValidationOptions{
+			Issuer: a.Options.Issuer,
+			Leeway: a.Options.Leeway,
+		}
+
+		// The hint only changes the order of the attempts
+		validators := []func() (*jwt.Claims, string, error){
+			func() (*jwt.Claims, string, error) {
+				c, err := a.ValidateAccess(token, opts)
+				return c, typeAccess, err
+			},
+			func() (*jwt.Claims, string, error) {
+				c, err := a.ValidateRefresh(token, opts)
+				return c, typeRefresh, err
+			},
+		}
+		if r.PostFormValue("token_type_hint") == typeRefresh {
+			validators[0], validators[1] = validators[1], validators[0]
+		}
+
+		var claims *jwt.Claims
+		var tokenType string
+		var err error
+		for _, v := range validators {
+			if claims, tokenType, err = v(); err == nil || errors.Is(err, jwt.ErrTokenExpiredOnly) {
+				break
+			}
+		}
+		if err != nil {
+			log.Info("token is not active", logger.Error(err))
+			codec.ResponseJSON(w, inactive, http.StatusOK)
+			return
+		}
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().ReadTimeout)
+		defer cancel()
+
+		active, err := sessionActive(ctxStorage, s, claims, tokenType)
+		if err != nil {
+			log.Error("failed to check session", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+		if !active {
+			log.Info("token is revoked")
+			codec.ResponseJSON(w, inactive, http.StatusOK)
+			return
+		}
+
+		resp := Response{
+			Active:    true,
+			Scope:     scope(claims),
+			ClientID:  claims.ClientID,
+			TokenType: tokenType,
+			Exp:       claims.ExpiresAt.Unix(),
+			Iat:       claims.IssuedAt.Unix(),
+			Sub:       claims.Subject,
+			Aud:       claims.Audience,
+			Iss:       claims.Issuer,
+			Jti:       claims.ID,
+		}
+		codec.ResponseJSON(w, resp, http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// scope returns the scope the token is issued for: the one granted to the
+// OAuth client or the permissions of first-party access tokens. Tokens with
+// neither have no scope, their type is told by `token_type` only.
+func scope(c *jwt.Claims) string {
+	if c.Scope != "" {
+		return c.Scope
+	}
+
+	return strings.Join(c.Permissions, " ")
+}
+
+func sessionActive(ctx context.Context, s storage.SessionStorage, c *jwt.Claims, tokenType string) (bool, error) {
+	if tokenType == typeRefresh {
+		t, err := s.RefreshToken(ctx, c.ID)
+		if err != nil {
+			if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+				return false, nil
+			}
+			return false, err
+		}
+		return t.Active(time.Now()), nil
+	}
+
+	// Access tokens not bound to a session cannot be revoked
+	if c.Family == "" {
+		return true, nil
+	}
+
+	revoked, err := s.FamilyRevoked(ctx, c.Family)
+	return !revoked, err
+}
+
+func authorized(r *http.Request, c config.Introspection) bool {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	// Hashing equalizes lengths, so the comparison time reveals nothing
+	idOk := digestEqual(id, c.ClientID)
+	secretOk := digestEqual(secret, c.ClientSecret)
+
+	return idOk && secretOk
+}
+
+func digestEqual(a, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
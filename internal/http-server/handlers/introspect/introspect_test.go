@@ -0,0 +1,28 @@
+package introspect
+
+import (
+	"testing"
+
+	"github.com/korikhin/auth/internal/lib/jwt"
+)
+
+func TestScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims jwt.Claims
+		want   string
+	}{
+		{name: "granted to client", claims: jwt.Claims{Scope: "openid email", Permissions: []string{"read"}}, want: "openid email"},
+		{name: "permissions", claims: jwt.Claims{Permissions: []string{"read", "write"}}, want: "read write"},
+		{name: "access without permissions", claims: jwt.Claims{TokenScope: ">"}, want: ""},
+		{name: "refresh", claims: jwt.Claims{TokenScope: "*"}, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scope(&tt.claims); got != tt.want {
+				t.Errorf("scope = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
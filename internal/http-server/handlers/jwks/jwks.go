@@ -0,0 +1,22 @@
+package jwks
+
+import (
+	"fmt"
+	"net/http"
+
+	httplib "github.com/korikhin/auth/internal/lib/http"
+	"github.com/korikhin/auth/internal/lib/http/codec"
+	"github.com/korikhin/auth/internal/lib/jwt"
+)
+
+// Keep short enough for clients to pick up a rotated key soon
+const maxAge = 300
+
+func New(a *jwt.JWTService) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(httplib.HeaderCacheControl, fmt.Sprintf("public, max-age=%d", maxAge))
+		codec.ResponseJSON(w, a.JWKS(), http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
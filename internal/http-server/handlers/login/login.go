@@ -5,21 +5,40 @@ import (
 	"errors"
 	"log/slog"
 	"net/http"
+	"time"
 
+	"github.com/korikhin/auth/internal/config"
 	"github.com/korikhin/auth/internal/lib/api"
+	httplib "github.com/korikhin/auth/internal/lib/http"
 	"github.com/korikhin/auth/internal/lib/http/codec"
 	"github.com/korikhin/auth/internal/lib/jwt"
+	"github.com/korikhin/auth/internal/lib/lockout"
 	"github.com/korikhin/auth/internal/lib/logger"
-	st "github.com/korikhin/auth/internal/storage"
-	storage "github.com/korikhin/auth/internal/storage/postgres"
+	"github.com/korikhin/auth/internal/lib/password"
+	"github.com/korikhin/auth/internal/lib/session"
+	"github.com/korikhin/auth/internal/storage"
 
 	reqMW "github.com/korikhin/auth/internal/http-server/middleware/request"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
-// TODO?: Refactor token (re)issuing
-func New(log *slog.Logger, a *jwt.JWTService, s *storage.Storage) http.Handler {
+// Missing users and wrong passwords are indistinguishable
+var errInvalidCredentials = api.Error("invalid credentials")
+
+type Storage interface {
+	storage.UserProvider
+	storage.UserSaver
+	storage.SessionStorage
+	storage.MFAStorage
+}
+
+// New logs the user in. Accounts with unverified email are refused
+// if the verification is required. Outdated password hashes are upgraded.
+// Failed attempts are counted by the guard, locked accounts get `423`
+// and locked client addresses get `429`. Users with the second factor
+// get an MFA challenge instead of the tokens.
+func New(log *slog.Logger, a *jwt.JWTService, s Storage, h password.Hasher, g *lockout.Guard, cv config.Verification) http.Handler {
+	dummy := dummyHash(log, h)
+
 	handler := func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.login.New"
 
@@ -36,14 +55,31 @@ func New(log *slog.Logger, a *jwt.JWTService, s *storage.Storage) http.Handler {
 			return
 		}
 
-		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options.ReadTimeout)
+		ip := httplib.ClientIP(r)
+
+		lock, err := g.Check(c.Email, ip)
+		if err != nil {
+			log.Error("failed to check lockout", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+		if lock != nil {
+			log.Warn("login is locked", slog.String("scope", string(lock.Scope)), slog.Time("until", lock.Until))
+			lock.Write(w)
+			return
+		}
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().ReadTimeout)
 		defer cancel()
 
 		user, err := s.UserByEmail(ctxStorage, c.Email)
 		if err != nil {
-			if errors.Is(err, st.ErrUserNotFound) {
+			if errors.Is(err, storage.ErrUserNotFound) {
 				log.Warn("user not found", logger.Error(err))
-				codec.ResponseJSON(w, api.Error("user not found"), http.StatusNotFound)
+				// Take as long as for a wrong password
+				_ = password.Compare(dummy, c.Password)
+				fail(log, g, c.Email, ip)
+				codec.ResponseJSON(w, errInvalidCredentials, http.StatusUnauthorized)
 				return
 			}
 
@@ -52,30 +88,123 @@ func New(log *slog.Logger, a *jwt.JWTService, s *storage.Storage) http.Handler {
 			return
 		}
 
-		if err = bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(c.Password)); err != nil {
+		// Accounts created by a provider login have no password, they
+		// take as long as a wrong password not to reveal their kind
+		hash := user.PasswordHash
+		if len(hash) == 0 {
+			hash = dummy
+		}
+
+		if err = password.Compare(hash, c.Password); err != nil {
 			log.Info("invalid credentials", logger.Error(err))
-			codec.ResponseJSON(w, api.Error("invalid credentials"), http.StatusUnauthorized)
+			fail(log, g, c.Email, ip)
+			codec.ResponseJSON(w, errInvalidCredentials, http.StatusUnauthorized)
+			return
+		}
+
+		if err := g.Succeed(c.Email); err != nil {
+			log.Error("failed to reset login failures", logger.Error(err))
+		}
+
+		if h.NeedsRehash(user.PasswordHash) {
+			rehash(log, s, h, user.ID, c.Password)
+		}
+
+		if cv.Required && !user.EmailVerified {
+			log.Info("email is not verified", slog.String("user_id", user.ID))
+			codec.ResponseJSON(w, api.Error("email is not verified"), http.StatusForbidden)
 			return
 		}
 
-		refreshToken, exp, err := a.IssueRefresh(user)
+		enrolled, err := session.MFAEnrolled(s, user.ID)
 		if err != nil {
-			log.Error("cannot issue refresh token", logger.Error(err))
+			log.Error("failed to get second factor", logger.Error(err))
 			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
 			return
 		}
-		jwt.SetRefreshToken(w, refreshToken, exp)
+		if enrolled {
+			token, exp, err := a.IssueMFAChallenge(user, []string{jwt.AMRPassword})
+			if err != nil {
+				log.Error("cannot issue mfa challenge", logger.Error(err))
+				codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+				return
+			}
+
+			log.Info("second factor required", slog.String("user_id", user.ID))
+			challenge := api.MFAChallenge{
+				Status:    "mfa_required",
+				MFAToken:  token,
+				ExpiresIn: int64(time.Until(exp).Seconds()),
+			}
+			codec.ResponseJSON(w, challenge, http.StatusOK)
+			return
+		}
+
+		ctxSession, cancel := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+		defer cancel()
 
-		accessToken, _, err := a.IssueAccess(user)
+		tokens, err := session.Start(ctxSession, a, s, user, []string{jwt.AMRPassword})
 		if err != nil {
-			log.Error("cannot issue token", logger.Error(err))
+			log.Error("cannot issue tokens", logger.Error(err))
 			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
 			return
 		}
-		jwt.SetAccessToken(w, accessToken)
+		tokens.Set(w)
 
 		codec.ResponseJSON(w, api.Ok("user logged successfully"), http.StatusOK)
 	}
 
 	return http.HandlerFunc(handler)
 }
+
+// rehash replaces the password hash with a current one,
+// failures are not fatal as the old hash is still valid.
+func rehash(log *slog.Logger, s Storage, h password.Hasher, userID, pw string) {
+	hash, err := h.Hash(pw)
+	if err != nil {
+		log.Error("failed to rehash password", logger.Error(err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+	defer cancel()
+
+	if err := s.UpdateUserPassword(ctx, userID, hash); err != nil {
+		log.Error("failed to save rehashed password", logger.Error(err))
+		return
+	}
+
+	log.Info("password hash upgraded", slog.String("user_id", userID))
+}
+
+// fail counts the failure, errors are logged only
+// so that the response does not depend on them.
+func fail(log *slog.Logger, g *lockout.Guard, email, ip string) {
+	locks, err := g.Fail(email, ip)
+	if err != nil {
+		log.Error("failed to count login failure", logger.Error(err))
+		return
+	}
+
+	for _, l := range locks {
+		log.Warn("login locked", slog.String("scope", string(l.Scope)), slog.Time("until", l.Until))
+	}
+}
+
+// dummyHash returns a hash of a random password made with the current
+// parameters, so that comparing against it takes the usual time.
+func dummyHash(log *slog.Logger, h password.Hasher) []byte {
+	pw, _, err := password.NewToken()
+	if err != nil {
+		log.Error("failed to create dummy password", logger.Error(err))
+		return nil
+	}
+
+	hash, err := h.Hash(pw)
+	if err != nil {
+		log.Error("failed to create dummy password hash", logger.Error(err))
+		return nil
+	}
+
+	return hash
+}
@@ -0,0 +1,107 @@
+package logout
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/korikhin/auth/internal/lib/api"
+	"github.com/korikhin/auth/internal/lib/http/codec"
+	"github.com/korikhin/auth/internal/lib/jwt"
+	"github.com/korikhin/auth/internal/lib/logger"
+	"github.com/korikhin/auth/internal/storage"
+
+	jwtMW "github.com/korikhin/auth/internal/http-server/middleware/jwt"
+	reqMW "github.com/korikhin/auth/internal/http-server/middleware/request"
+)
+
+// New ends the current session: the refresh token family presented
+// in the cookie is revoked and the cookie is cleared.
+//
+// Logging out is idempotent, a missing or unusable cookie is not an error.
+func New(log *slog.Logger, a *jwt.JWTService, s storage.SessionStorage) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.logout.New"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		claims := jwtMW.GetClaims(r.Context())
+		if claims == nil {
+			log.Error("claims are missing in the context")
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		jwt.ClearRefreshToken(w)
+
+		refreshToken, err := jwt.GetRefreshToken(r)
+		if err != nil {
+			log.Info("refresh token is missing, nothing to revoke")
+			codec.ResponseJSON(w, api.Ok("user logged out successfully"), http.StatusOK)
+			return
+		}
+
+		opts := jwt.ValidationOptions{
+			Issuer:  a.Options.Issuer,
+			Leeway:  a.Options.Leeway,
+			Subject: claims.Subject,
+		}
+
+		refreshClaims, err := a.ValidateRefresh(refreshToken, opts)
+		if err != nil && !errors.Is(err, jwt.ErrTokenExpiredOnly) {
+			log.Info("refresh token is invalid, nothing to revoke", logger.Error(err))
+			codec.ResponseJSON(w, api.Ok("user logged out successfully"), http.StatusOK)
+			return
+		}
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+		defer cancel()
+
+		if err := s.RevokeTokenFamily(ctxStorage, refreshClaims.Family); err != nil {
+			log.Error("failed to revoke session", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		codec.ResponseJSON(w, api.Ok("user logged out successfully"), http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// Everywhere ends every session of the user, including the current one.
+func Everywhere(log *slog.Logger, s storage.SessionStorage) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.logout.Everywhere"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		claims := jwtMW.GetClaims(r.Context())
+		if claims == nil {
+			log.Error("claims are missing in the context")
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+		defer cancel()
+
+		if err := s.RevokeUserTokens(ctxStorage, claims.Subject, ""); err != nil {
+			log.Error("failed to revoke sessions", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		jwt.ClearRefreshToken(w)
+		codec.ResponseJSON(w, api.Ok("all sessions are terminated"), http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
@@ -0,0 +1,421 @@
+package mfa
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/korikhin/auth/internal/config"
+	"github.com/korikhin/auth/internal/lib/api"
+	"github.com/korikhin/auth/internal/lib/http/codec"
+	"github.com/korikhin/auth/internal/lib/jwt"
+	"github.com/korikhin/auth/internal/lib/lockout"
+	"github.com/korikhin/auth/internal/lib/logger"
+	"github.com/korikhin/auth/internal/lib/password"
+	"github.com/korikhin/auth/internal/lib/session"
+	"github.com/korikhin/auth/internal/lib/totp"
+	"github.com/korikhin/auth/internal/storage"
+
+	jwtMW "github.com/korikhin/auth/internal/http-server/middleware/jwt"
+	reqMW "github.com/korikhin/auth/internal/http-server/middleware/request"
+	httplib "github.com/korikhin/auth/internal/lib/http"
+)
+
+const recoveryCodes = 10
+
+type Storage interface {
+	storage.UserProvider
+	storage.SessionStorage
+	storage.MFAStorage
+}
+
+type Enrolment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type Confirmation struct {
+	Code string `json:"code" validate:"required,numeric,len=6"`
+}
+
+type Recovery struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// Challenge answers the MFA challenge with either a TOTP or a recovery code.
+type Challenge struct {
+	MFAToken     string `json:"mfa_token" validate:"required"`
+	Code         string `json:"code" validate:"required_without=RecoveryCode,omitempty,numeric,len=6"`
+	RecoveryCode string `json:"recovery_code" validate:"required_without=Code"`
+}
+
+var (
+	errNotEnrolled     = api.Error("mfa is not enrolled")
+	errAlreadyEnabled  = api.Error("mfa is already enabled")
+	errInvalidCode     = api.Error("invalid code")
+	errInvalidMFAToken = api.Error("mfa token is invalid or expired")
+)
+
+// Enroll generates a new TOTP secret for the current user. The secret is
+// not used for login until confirmed with a code.
+func Enroll(log *slog.Logger, s Storage, c config.MFA) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.mfa.Enroll"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().ReadTimeout)
+		defer cancel()
+
+		user, err := s.User(ctxStorage, subject(r))
+		if err != nil {
+			log.Error("failed to get user", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		secret, err := totp.NewSecret()
+		if err != nil {
+			log.Error("failed to create secret", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		ctxSave, cancelSave := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+		defer cancelSave()
+
+		if err := s.SaveTOTP(ctxSave, user.ID, secret); err != nil {
+			if errors.Is(err, storage.ErrMFAAlreadyEnabled) {
+				log.Info("mfa is already enabled", slog.String("user_id", user.ID))
+				codec.ResponseJSON(w, errAlreadyEnabled, http.StatusConflict)
+				return
+			}
+			log.Error("failed to save secret", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		resp := Enrolment{
+			Secret: secret,
+			URI:    totp.URI(c.Issuer, user.Email, secret),
+		}
+		codec.ResponseJSON(w, resp, http.StatusCreated)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// Confirm enables the second factor with the first code from the app
+// and returns the recovery codes, shown only once. The other sessions
+// of the user are ended as they have been started without it.
+func Confirm(log *slog.Logger, s Storage) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.mfa.Confirm"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		claims := jwtMW.GetClaims(r.Context())
+		if claims == nil {
+			log.Error("claims are missing from the context")
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		req := &Confirmation{}
+		if err := codec.DecodeJSON(r.Body, req); err != nil {
+			log.Error("failed to decode request body", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+			return
+		}
+		if err := api.Validate(req); err != nil {
+			log.Error("bad request", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+			return
+		}
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().ReadTimeout)
+		defer cancel()
+
+		t, err := s.TOTP(ctxStorage, claims.Subject)
+		if err != nil {
+			if errors.Is(err, storage.ErrMFANotFound) {
+				log.Info("mfa is not enrolled", logger.Error(err))
+				codec.ResponseJSON(w, errNotEnrolled, http.StatusNotFound)
+				return
+			}
+			log.Error("failed to get secret", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+		if t.Confirmed {
+			log.Info("mfa is already enabled", slog.String("user_id", t.UserID))
+			codec.ResponseJSON(w, errAlreadyEnabled, http.StatusConflict)
+			return
+		}
+
+		step, ok := totp.Validate(t.Secret, req.Code, time.Now())
+		if !ok {
+			log.Info("invalid code")
+			codec.ResponseJSON(w, errInvalidCode, http.StatusBadRequest)
+			return
+		}
+
+		codes, hashes, err := newRecoveryCodes(recoveryCodes)
+		if err != nil {
+			log.Error("failed to create recovery codes", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		ctxSave, cancelSave := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+		defer cancelSave()
+
+		if err := s.ConfirmTOTP(ctxSave, t.UserID, step, hashes); err != nil {
+			if errors.Is(err, storage.ErrMFAAlreadyEnabled) {
+				log.Info("mfa is already enabled", slog.String("user_id", t.UserID))
+				codec.ResponseJSON(w, errAlreadyEnabled, http.StatusConflict)
+				return
+			}
+			log.Error("failed to confirm secret", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		if err := s.RevokeUserTokens(ctxSave, t.UserID, claims.Family); err != nil {
+			log.Error("failed to end other sessions", logger.Error(err))
+		}
+
+		log.Info("mfa enabled", slog.String("user_id", t.UserID))
+		codec.ResponseJSON(w, Recovery{RecoveryCodes: codes}, http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// Disable removes the second factor. The session is expected to have
+// passed it, see the authz middleware.
+func Disable(log *slog.Logger, s Storage) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.mfa.Disable"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		userID := subject(r)
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+		defer cancel()
+
+		if err := s.DeleteMFA(ctxStorage, userID); err != nil {
+			log.Error("failed to disable mfa", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		log.Info("mfa disabled", slog.String("user_id", userID))
+		codec.ResponseJSON(w, api.Ok("mfa successfully disabled"), http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// Verify completes the login started with the password: the MFA token
+// from the login response is exchanged for the tokens with a TOTP or
+// a recovery code. Failures are counted by the guard as failed logins.
+func Verify(log *slog.Logger, a *jwt.JWTService, s Storage, g *lockout.Guard) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.mfa.Verify"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		req := &Challenge{}
+		if err := codec.DecodeJSON(r.Body, req); err != nil {
+			log.Error("failed to decode request body", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+			return
+		}
+		if err := api.Validate(req); err != nil {
+			log.Error("bad request", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+			return
+		}
+
+		opts := jwt.ValidationOptions{
+			Issuer: a.Options.Issuer,
+			Leeway: a.Options.Leeway,
+		}
+
+		claims, err := a.ValidateMFAChallenge(req.MFAToken, opts)
+		if err != nil {
+			log.Info("invalid mfa token", logger.Error(err))
+			codec.ResponseJSON(w, errInvalidMFAToken, http.StatusUnauthorized)
+			return
+		}
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().ReadTimeout)
+		defer cancel()
+
+		user, err := s.User(ctxStorage, claims.Subject)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				log.Info("user not found", logger.Error(err))
+				codec.ResponseJSON(w, errInvalidMFAToken, http.StatusUnauthorized)
+				return
+			}
+			log.Error("failed to get user", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		ip := httplib.ClientIP(r)
+
+		lock, err := g.Check(user.Email, ip)
+		if err != nil {
+			log.Error("failed to check lockout", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+		if lock != nil {
+			log.Warn("login is locked", slog.String("scope", string(lock.Scope)), slog.Time("until", lock.Until))
+			lock.Write(w)
+			return
+		}
+
+		methods, err := verifyCode(s, user.ID, req)
+		if err != nil {
+			if errors.Is(err, errCodeRejected) || errors.Is(err, storage.ErrMFACodeUsed) || errors.Is(err, storage.ErrMFANotFound) {
+				log.Info("invalid code", logger.Error(err))
+				fail(log, g, user.Email, ip)
+				codec.ResponseJSON(w, errInvalidCode, http.StatusUnauthorized)
+				return
+			}
+			log.Error("failed to verify code", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		if err := g.Succeed(user.Email); err != nil {
+			log.Error("failed to reset login failures", logger.Error(err))
+		}
+
+		ctxSession, cancelSession := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+		defer cancelSession()
+
+		// The challenge carries the methods of the first factor
+		amr := append(slices.Clone(claims.AMR), methods...)
+
+		tokens, err := session.Start(ctxSession, a, s, user, amr)
+		if err != nil {
+			log.Error("cannot issue tokens", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+		tokens.Set(w)
+
+		codec.ResponseJSON(w, api.Ok("user logged successfully"), http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+var errCodeRejected = errors.New("code is rejected")
+
+// verifyCode spends the code and returns the authentication methods
+// of the second factor.
+func verifyCode(s Storage, userID string, req *Challenge) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+	defer cancel()
+
+	if req.RecoveryCode != "" {
+		if err := s.UseRecoveryCode(ctx, userID, password.HashToken(normalize(req.RecoveryCode))); err != nil {
+			return nil, err
+		}
+		return []string{jwt.AMRMFA}, nil
+	}
+
+	t, err := s.TOTP(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !t.Confirmed {
+		return nil, errCodeRejected
+	}
+
+	step, ok := totp.Validate(t.Secret, req.Code, time.Now())
+	if !ok {
+		return nil, errCodeRejected
+	}
+
+	// A code is accepted once, even within its time step
+	if err := s.UseTOTPStep(ctx, userID, step); err != nil {
+		return nil, err
+	}
+
+	return []string{jwt.AMROTP, jwt.AMRMFA}, nil
+}
+
+func fail(log *slog.Logger, g *lockout.Guard, email, ip string) {
+	locks, err := g.Fail(email, ip)
+	if err != nil {
+		log.Error("failed to count login failure", logger.Error(err))
+		return
+	}
+
+	for _, l := range locks {
+		log.Warn("login locked", slog.String("scope", string(l.Scope)), slog.Time("until", l.Until))
+	}
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCodes returns the codes formatted as `xxxxx-xxxxx`
+// along with their hashes.
+func newRecoveryCodes(n int) ([]string, [][]byte, error) {
+	codes := make([]string, 0, n)
+	hashes := make([][]byte, 0, n)
+
+	var buf [7]byte
+	for i := 0; i < n; i++ {
+		if _, err := rand.Read(buf[:]); err != nil {
+			return nil, nil, err
+		}
+
+		c := strings.ToLower(recoveryEncoding.EncodeToString(buf[:]))[:10]
+		codes = append(codes, fmt.Sprintf("%s-%s", c[:5], c[5:]))
+		hashes = append(hashes, password.HashToken(c))
+	}
+
+	return codes, hashes, nil
+}
+
+// normalize drops the formatting users may or may not type.
+func normalize(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+func subject(r *http.Request) string {
+	if c := jwtMW.GetClaims(r.Context()); c != nil {
+		return c.Subject
+	}
+
+	return ""
+}
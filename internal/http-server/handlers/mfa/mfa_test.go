@@ -0,0 +1,197 @@
+package mfa
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/korikhin/auth/internal/config"
+	"github.com/korikhin/auth/internal/lib/totp"
+	"github.com/korikhin/auth/internal/storage"
+	"github.com/korikhin/auth/internal/storage/memory"
+)
+
+const userID = "1"
+
+func newStorage() *memory.Storage {
+	return memory.New(config.Storage{ReadTimeout: time.Second, WriteTimeout: time.Second})
+}
+
+// enroll confirms a new secret as of the steps before the moment
+// and returns the secret with the recovery codes.
+func enroll(t *testing.T, s *memory.Storage, at time.Time) (string, []string) {
+	t.Helper()
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, hashes, err := newRecoveryCodes(recoveryCodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := s.SaveTOTP(ctx, userID, secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ConfirmTOTP(ctx, userID, totp.Step(at)-5, hashes); err != nil {
+		t.Fatal(err)
+	}
+
+	return secret, codes
+}
+
+// code computes the TOTP code of the step as authenticator apps do,
+// independently of the totp package.
+func code(t *testing.T, secret string, step int64) string {
+	t.Helper()
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mac := hmac.New(sha1.New, key)
+	binary.Write(mac, binary.BigEndian, step)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{code: "abcde-fghij", want: "abcdefghij"},
+		{code: "ABCDE-FGHIJ", want: "abcdefghij"},
+		{code: "abcdefghij", want: "abcdefghij"},
+		{code: "abcde fghij", want: "abcdefghij"},
+		{code: " abcde - fghij ", want: "abcdefghij"},
+	}
+
+	for _, tt := range tests {
+		if got := normalize(tt.code); got != tt.want {
+			t.Errorf("normalize(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes(recoveryCodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodes || len(hashes) != recoveryCodes {
+		t.Fatalf("got %d codes and %d hashes, want %d", len(codes), len(hashes), recoveryCodes)
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range codes {
+		if len(c) != 11 || c[5] != '-' || c != strings.ToLower(c) {
+			t.Errorf("code %q is not formatted as xxxxx-xxxxx", c)
+		}
+		if seen[c] {
+			t.Errorf("code %q is repeated", c)
+		}
+		seen[c] = true
+	}
+}
+
+// Recovery codes are accepted once, however they are typed.
+func TestVerifyRecoveryCode(t *testing.T) {
+	typings := []struct {
+		name   string
+		format func(string) string
+	}{
+		{name: "as shown", format: func(c string) string { return c }},
+		{name: "upper case", format: strings.ToUpper},
+		{name: "without dash", format: func(c string) string { return strings.ReplaceAll(c, "-", "") }},
+		{name: "with spaces", format: func(c string) string { return strings.ReplaceAll(c, "-", " ") }},
+	}
+
+	s := newStorage()
+	_, codes := enroll(t, s, time.Now())
+
+	for i, tt := range typings {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &Challenge{RecoveryCode: tt.format(codes[i])}
+
+			if _, err := verifyCode(s, userID, req); err != nil {
+				t.Fatalf("first use: %v", err)
+			}
+			if _, err := verifyCode(s, userID, req); !errors.Is(err, storage.ErrMFACodeUsed) {
+				t.Errorf("second use: error = %v, want %v", err, storage.ErrMFACodeUsed)
+			}
+		})
+	}
+
+	if _, err := verifyCode(s, "2", &Challenge{RecoveryCode: codes[len(typings)]}); !errors.Is(err, storage.ErrMFACodeUsed) {
+		t.Errorf("code of another user: error = %v, want %v", err, storage.ErrMFACodeUsed)
+	}
+}
+
+// A TOTP code is accepted once, the codes of the earlier steps are
+// refused even within the skew.
+func TestVerifyTOTPReplay(t *testing.T) {
+	s := newStorage()
+	now := time.Now()
+	secret, _ := enroll(t, s, now)
+	step := totp.Step(now)
+
+	if _, err := verifyCode(s, userID, &Challenge{Code: code(t, secret, step)}); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if _, err := verifyCode(s, userID, &Challenge{Code: code(t, secret, step)}); !errors.Is(err, storage.ErrMFACodeUsed) {
+		t.Errorf("replay: error = %v, want %v", err, storage.ErrMFACodeUsed)
+	}
+	if _, err := verifyCode(s, userID, &Challenge{Code: code(t, secret, step-1)}); err == nil {
+		t.Error("earlier step: code is accepted")
+	}
+	if _, err := verifyCode(s, userID, &Challenge{Code: code(t, secret, step+1)}); err != nil {
+		t.Errorf("later step: %v", err)
+	}
+}
+
+func TestUseTOTPStep(t *testing.T) {
+	s := newStorage()
+	ctx := context.Background()
+	now := time.Now()
+	confirmed := totp.Step(now) - 5
+	enroll(t, s, now)
+
+	tests := []struct {
+		name string
+		step int64
+		want error
+	}{
+		{name: "confirmation step", step: confirmed, want: storage.ErrMFACodeUsed},
+		{name: "before confirmation", step: confirmed - 1, want: storage.ErrMFACodeUsed},
+		{name: "next step", step: confirmed + 1},
+		{name: "next step again", step: confirmed + 1, want: storage.ErrMFACodeUsed},
+		{name: "step skipped", step: confirmed + 3},
+		{name: "step left behind", step: confirmed + 2, want: storage.ErrMFACodeUsed},
+	}
+
+	// The cases run in order, each one sees the steps used before it
+	for _, tt := range tests {
+		if err := s.UseTOTPStep(ctx, userID, tt.step); !errors.Is(err, tt.want) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	if err := s.UseTOTPStep(ctx, "2", confirmed+10); !errors.Is(err, storage.ErrMFANotFound) {
+		t.Errorf("not enrolled: error = %v, want %v", err, storage.ErrMFANotFound)
+	}
+}
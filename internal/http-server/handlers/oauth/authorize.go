@@ -0,0 +1,292 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/korikhin/auth/internal/config"
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/lib/api"
+	"github.com/korikhin/auth/internal/lib/http/codec"
+	"github.com/korikhin/auth/internal/lib/logger"
+	"github.com/korikhin/auth/internal/lib/password"
+	"github.com/korikhin/auth/internal/storage"
+
+	jwtMW "github.com/korikhin/auth/internal/http-server/middleware/jwt"
+	reqMW "github.com/korikhin/auth/internal/http-server/middleware/request"
+)
+
+const (
+	responseTypeCode = "code"
+	challengeS256    = "S256"
+	maxNonceLength   = 255
+)
+
+// ClientInfo is what the user is shown on the consent screen.
+type ClientInfo struct {
+	ID   string `json:"client_id"`
+	Name string `json:"name"`
+}
+
+// Consent describes the authorization request for the user to approve.
+type Consent struct {
+	Client      ClientInfo `json:"client"`
+	Scope       string     `json:"scope"`
+	RedirectURI string     `json:"redirect_uri"`
+}
+
+type Decision struct {
+	Approve bool `json:"approve"`
+}
+
+// Redirect tells the frontend where to send the browser.
+type Redirect struct {
+	RedirectTo string `json:"redirect_to"`
+}
+
+// authorization is a validated authorization request.
+type authorization struct {
+	client      *models.Client
+	redirectURI string
+	// redirectURIGiven is false if the only registered URI is implied
+	redirectURIGiven bool
+	scope            string
+	state            string
+	codeChallenge    string
+	nonce            string
+}
+
+// requestError is an invalid authorization request, it is passed
+// to the client if the redirect URI is trusted.
+type requestError struct {
+	code        string
+	description string
+	redirect    string
+}
+
+func (e *requestError) Error() string {
+	return e.code + ": " + e.description
+}
+
+// Authorize validates the authorization request and returns the consent
+// details. The request parameters are those of RFC 6749, section 4.1.1,
+// passed on by the frontend which shows the consent screen to the user.
+func Authorize(log *slog.Logger, s Storage) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.oauth.Authorize"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		az, err := parseAuthorization(s, r.URL.Query())
+		if err != nil {
+			writeRequestError(log, w, err)
+			return
+		}
+
+		resp := Consent{
+			Client:      ClientInfo{ID: az.client.ID, Name: az.client.Name},
+			Scope:       az.scope,
+			RedirectURI: az.redirectURI,
+		}
+		codec.ResponseJSON(w, resp, http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// Decide records the decision of the user on the authorization request
+// and returns the redirect to the client with the code or the refusal.
+func Decide(log *slog.Logger, s Storage, c config.OAuth) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.oauth.Decide"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		claims := jwtMW.GetClaims(r.Context())
+		if claims == nil {
+			log.Error("claims are missing from the context")
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		req := &Decision{}
+		if err := codec.DecodeJSON(r.Body, req); err != nil {
+			log.Error("failed to decode request body", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+			return
+		}
+
+		az, err := parseAuthorization(s, r.URL.Query())
+		if err != nil {
+			writeRequestError(log, w, err)
+			return
+		}
+
+		if !req.Approve {
+			log.Info("authorization denied", slog.String("client_id", az.client.ID))
+			resp := Redirect{RedirectTo: redirectURL(az.redirectURI, url.Values{
+				"error": {errAccessDenied},
+				"state": {az.state},
+			})}
+			codec.ResponseJSON(w, resp, http.StatusOK)
+			return
+		}
+
+		code, hash, err := password.NewToken()
+		if err != nil {
+			log.Error("failed to create code", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+		defer cancel()
+
+		ac := &models.AuthorizationCode{
+			Hash:             hash,
+			ClientID:         az.client.ID,
+			UserID:           claims.Subject,
+			RedirectURI:      az.redirectURI,
+			RedirectURIGiven: az.redirectURIGiven,
+			Scope:            az.scope,
+			CodeChallenge:    az.codeChallenge,
+			AMR:              claims.AMR,
+			AuthTime:         claims.AuthenticatedAt(),
+			Nonce:            az.nonce,
+			ExpiresAt:        time.Now().Add(c.CodeTTL),
+		}
+		if err := s.SaveAuthorizationCode(ctxStorage, ac); err != nil {
+			log.Error("failed to save code", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		log.Info("authorization granted", slog.String("client_id", az.client.ID), slog.String("user_id", claims.Subject))
+
+		noStore(w)
+		resp := Redirect{RedirectTo: redirectURL(az.redirectURI, url.Values{
+			"code":  {code},
+			"state": {az.state},
+		})}
+		codec.ResponseJSON(w, resp, http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+func parseAuthorization(s Storage, q url.Values) (*authorization, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Options().ReadTimeout)
+	defer cancel()
+
+	clientID := q.Get("client_id")
+	if clientID == "" {
+		return nil, &requestError{code: errInvalidRequest, description: "client_id is required"}
+	}
+
+	client, err := s.Client(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, storage.ErrClientNotFound) {
+			return nil, &requestError{code: errInvalidRequest, description: "unknown client"}
+		}
+		return nil, err
+	}
+
+	// The redirect URI may be omitted if there is no choice
+	redirectURI := q.Get("redirect_uri")
+	redirectURIGiven := redirectURI != ""
+	if !redirectURIGiven && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	if !client.AllowsRedirect(redirectURI) {
+		return nil, &requestError{code: errInvalidRequest, description: "redirect_uri is not registered"}
+	}
+
+	// From here on the errors are passed to the client
+	state := q.Get("state")
+	fail := func(code, description string) error {
+		return &requestError{
+			code:        code,
+			description: description,
+			redirect: redirectURL(redirectURI, url.Values{
+				"error":             {code},
+				"error_description": {description},
+				"state":             {state},
+			}),
+		}
+	}
+
+	if q.Get("response_type") != responseTypeCode {
+		return nil, fail(errUnsupportedResponseType, "only code is supported")
+	}
+	if !client.AllowsGrant(models.GrantAuthorizationCode) {
+		return nil, fail(errUnauthorizedClient, "grant is not allowed for the client")
+	}
+
+	challenge := q.Get("code_challenge")
+	if q.Get("code_challenge_method") != challengeS256 || !validPKCE(challenge) {
+		return nil, fail(errInvalidRequest, "code_challenge with method S256 is required")
+	}
+
+	scope, ok := grantedScope(client, q.Get("scope"))
+	if !ok {
+		return nil, fail(errInvalidScope, "scope is not allowed for the client")
+	}
+
+	if len(q.Get("nonce")) > maxNonceLength {
+		return nil, fail(errInvalidRequest, "nonce is too long")
+	}
+
+	az := &authorization{
+		client:           client,
+		redirectURI:      redirectURI,
+		redirectURIGiven: redirectURIGiven,
+		scope:            scope,
+		state:            state,
+		codeChallenge:    challenge,
+		nonce:            q.Get("nonce"),
+	}
+
+	return az, nil
+}
+
+func writeRequestError(log *slog.Logger, w http.ResponseWriter, err error) {
+	var re *requestError
+	if !errors.As(err, &re) {
+		log.Error("failed to validate authorization request", logger.Error(err))
+		codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+		return
+	}
+
+	log.Info("invalid authorization request", logger.Error(err))
+	resp := Error{Code: re.code, Description: re.description, RedirectTo: re.redirect}
+	codec.ResponseJSON(w, resp, http.StatusBadRequest)
+}
+
+// redirectURL adds the parameters to the query of the redirect URI,
+// empty ones are left out.
+func redirectURL(uri string, params url.Values) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+
+	q := u.Query()
+	for k, v := range params {
+		if len(v) != 0 && v[0] != "" {
+			q.Set(k, v[0])
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
@@ -0,0 +1,194 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/lib/api"
+	"github.com/korikhin/auth/internal/lib/http/codec"
+	"github.com/korikhin/auth/internal/lib/jwt"
+	"github.com/korikhin/auth/internal/lib/logger"
+	"github.com/korikhin/auth/internal/lib/password"
+	"github.com/korikhin/auth/internal/storage"
+
+	reqMW "github.com/korikhin/auth/internal/http-server/middleware/request"
+
+	"github.com/gorilla/mux"
+)
+
+type ClientRequest struct {
+	Name         string   `json:"name" validate:"required,max=100"`
+	RedirectURIs []string `json:"redirect_uris" validate:"dive,url"`
+	GrantTypes   []string `json:"grant_types" validate:"required,dive,oneof=authorization_code refresh_token client_credentials"`
+	Scopes       []string `json:"scopes" validate:"dive,required,printascii,excludes= "`
+	// Public clients, e.g. single-page and mobile apps, get no secret
+	Public bool `json:"public"`
+}
+
+// ClientResponse carries the secret, shown only once on registration.
+type ClientResponse struct {
+	models.Client
+	Secret string `json:"client_secret,omitempty"`
+}
+
+var errClientNotFound = api.Error("client not found")
+
+// CreateClient registers a client.
+func CreateClient(log *slog.Logger, s Storage) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.oauth.CreateClient"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		req := &ClientRequest{}
+		if err := codec.DecodeJSON(r.Body, req); err != nil {
+			log.Error("failed to decode request body", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+			return
+		}
+		if err := api.Validate(req); err != nil {
+			log.Error("bad request", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+			return
+		}
+		if msg := checkClient(req); msg != "" {
+			log.Error("bad request", slog.String("reason", msg))
+			codec.ResponseJSON(w, api.Error("bad request", msg), http.StatusBadRequest)
+			return
+		}
+
+		id, err := jwt.NewID()
+		if err != nil {
+			log.Error("failed to create client ID", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		grants := slices.Clone(req.GrantTypes)
+		slices.Sort(grants)
+
+		c := models.Client{
+			ID:           id,
+			Name:         req.Name,
+			RedirectURIs: req.RedirectURIs,
+			GrantTypes:   slices.Compact(grants),
+			Scopes:       req.Scopes,
+			CreatedAt:    time.Now(),
+		}
+
+		var secret string
+		if !req.Public {
+			if secret, c.SecretHash, err = password.NewToken(); err != nil {
+				log.Error("failed to create client secret", logger.Error(err))
+				codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+				return
+			}
+		}
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+		defer cancel()
+
+		if err := s.SaveClient(ctxStorage, &c); err != nil {
+			log.Error("failed to save client", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		log.Info("client registered", slog.String("client_id", c.ID))
+		codec.ResponseJSON(w, ClientResponse{Client: c, Secret: secret}, http.StatusCreated)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// checkClient validates what the tags cannot express.
+func checkClient(req *ClientRequest) string {
+	if slices.Contains(req.GrantTypes, models.GrantAuthorizationCode) && len(req.RedirectURIs) == 0 {
+		return "field redirect_uris is required for authorization_code"
+	}
+	if slices.Contains(req.GrantTypes, models.GrantClientCredentials) && req.Public {
+		return "public clients cannot use client_credentials"
+	}
+	if slices.Contains(req.GrantTypes, models.GrantRefreshToken) && !slices.Contains(req.GrantTypes, models.GrantAuthorizationCode) {
+		return "refresh_token requires authorization_code"
+	}
+
+	for _, uri := range req.RedirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Fragment != "" || strings.Contains(uri, "#") {
+			return "field redirect_uris must hold absolute URIs without fragments"
+		}
+	}
+
+	return ""
+}
+
+// ListClients returns the registered clients.
+func ListClients(log *slog.Logger, s Storage) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.oauth.ListClients"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().ReadTimeout)
+		defer cancel()
+
+		clients, err := s.Clients(ctxStorage)
+		if err != nil {
+			log.Error("failed to get clients", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		codec.ResponseJSON(w, clients, http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// DeleteClient removes the client, the tokens already issued to it
+// stay valid until they expire but cannot be refreshed.
+func DeleteClient(log *slog.Logger, s Storage) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.oauth.DeleteClient"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		id := mux.Vars(r)["id"]
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+		defer cancel()
+
+		if err := s.DeleteClient(ctxStorage, id); err != nil {
+			if errors.Is(err, storage.ErrClientNotFound) {
+				log.Info("client not found", logger.Error(err))
+				codec.ResponseJSON(w, errClientNotFound, http.StatusNotFound)
+				return
+			}
+			log.Error("failed to delete client", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		log.Info("client deleted", slog.String("client_id", id))
+		codec.ResponseJSON(w, api.Ok("client successfully deleted"), http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
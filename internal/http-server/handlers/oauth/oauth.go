@@ -0,0 +1,127 @@
+// Package oauth implements the OAuth 2.0 authorization server (RFC 6749):
+// the authorization code grant with PKCE (RFC 7636), refresh tokens and
+// client credentials, for the clients registered by the admins. On top of
+// it, the OpenID Connect provider issues ID tokens for the openid scope.
+package oauth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/korikhin/auth/internal/domain/models"
+	httplib "github.com/korikhin/auth/internal/lib/http"
+	"github.com/korikhin/auth/internal/lib/http/codec"
+	"github.com/korikhin/auth/internal/lib/password"
+	"github.com/korikhin/auth/internal/storage"
+)
+
+type Storage interface {
+	storage.UserProvider
+	storage.SessionStorage
+	storage.ClientStorage
+}
+
+// Error codes (RFC 6749, sections 4.1.2.1 and 5.2)
+const (
+	errInvalidRequest          = "invalid_request"
+	errInvalidClient           = "invalid_client"
+	errInvalidGrant            = "invalid_grant"
+	errInvalidScope            = "invalid_scope"
+	errUnauthorizedClient      = "unauthorized_client"
+	errUnsupportedGrantType    = "unsupported_grant_type"
+	errUnsupportedResponseType = "unsupported_response_type"
+	errAccessDenied            = "access_denied"
+	errServerError             = "server_error"
+
+	// Bearer token errors (RFC 6750, section 3.1)
+	errInvalidToken      = "invalid_token"
+	errInsufficientScope = "insufficient_scope"
+)
+
+// Error is the error response of the OAuth endpoints.
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	// RedirectTo passes the error to the client, once its redirect URI is known
+	RedirectTo string `json:"redirect_to,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, code, description string, status int) {
+	noStore(w)
+	codec.ResponseJSON(w, Error{Code: code, Description: description}, status)
+}
+
+// noStore keeps responses with tokens and codes out of caches.
+func noStore(w http.ResponseWriter) {
+	w.Header().Set(httplib.HeaderCacheControl, "no-store")
+	w.Header().Set(httplib.HeaderPragma, "no-cache")
+}
+
+var errClientAuthFailed = errors.New("client authentication failed")
+
+// authenticateClient identifies the client with HTTP Basic or the form
+// (RFC 6749, section 2.3.1). Public clients present their ID only.
+func authenticateClient(ctx context.Context, r *http.Request, s Storage) (*models.Client, error) {
+	id, secret, basic := r.BasicAuth()
+	if basic {
+		// The credentials are form-encoded before being put in the header
+		var err error
+		if id, err = url.QueryUnescape(id); err != nil {
+			return nil, errClientAuthFailed
+		}
+		if secret, err = url.QueryUnescape(secret); err != nil {
+			return nil, errClientAuthFailed
+		}
+	} else {
+		id, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	if id == "" {
+		return nil, errClientAuthFailed
+	}
+
+	c, err := s.Client(ctx, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrClientNotFound) {
+			return nil, errClientAuthFailed
+		}
+		return nil, err
+	}
+
+	if c.Public() {
+		if secret != "" {
+			return nil, errClientAuthFailed
+		}
+		return c, nil
+	}
+
+	if subtle.ConstantTimeCompare(password.HashToken(secret), c.SecretHash) != 1 {
+		return nil, errClientAuthFailed
+	}
+
+	return c, nil
+}
+
+// grantedScope checks the requested scope against the scopes of the client,
+// all of them are granted if none are requested.
+func grantedScope(c *models.Client, requested string) (string, bool) {
+	if requested == "" {
+		return strings.Join(c.Scopes, " "), true
+	}
+
+	var scopes []string
+	for _, s := range strings.Fields(requested) {
+		if !slices.Contains(c.Scopes, s) {
+			return "", false
+		}
+		if !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+
+	return strings.Join(scopes, " "), true
+}
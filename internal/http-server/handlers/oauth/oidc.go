@@ -0,0 +1,155 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/korikhin/auth/internal/config"
+	"github.com/korikhin/auth/internal/domain/models"
+	httplib "github.com/korikhin/auth/internal/lib/http"
+	"github.com/korikhin/auth/internal/lib/http/codec"
+	"github.com/korikhin/auth/internal/lib/jwt"
+	"github.com/korikhin/auth/internal/lib/logger"
+	"github.com/korikhin/auth/internal/storage"
+
+	jwtMW "github.com/korikhin/auth/internal/http-server/middleware/jwt"
+	reqMW "github.com/korikhin/auth/internal/http-server/middleware/request"
+)
+
+// Same as for the key set, the document refers to it
+const discoveryMaxAge = 300
+
+// Provider is the OpenID Provider metadata (OpenID Connect Discovery, section 3).
+type Provider struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserInfoEndpoint      string   `json:"userinfo_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	ScopesSupported       []string `json:"scopes_supported"`
+	ClaimsSupported       []string `json:"claims_supported"`
+	ResponseTypes         []string `json:"response_types_supported"`
+	GrantTypes            []string `json:"grant_types_supported"`
+	SubjectTypes          []string `json:"subject_types_supported"`
+	SigningAlgorithms     []string `json:"id_token_signing_alg_values_supported"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+}
+
+// UserInfoResponse is the response of the userinfo endpoint.
+type UserInfoResponse struct {
+	Subject string `json:"sub"`
+	jwt.UserClaims
+}
+
+// Discovery returns the provider metadata. The endpoints are resolved
+// against the issuer, which is expected to be the public URL of the service.
+func Discovery(a *jwt.JWTService, c config.OAuth) http.Handler {
+	issuer := strings.TrimSuffix(a.Options.Issuer, "/")
+
+	authorize := c.AuthorizeURL
+	if authorize == "" {
+		authorize = issuer + "/oauth/authorize"
+	}
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		resp := Provider{
+			Issuer:                a.Options.Issuer,
+			AuthorizationEndpoint: authorize,
+			TokenEndpoint:         issuer + "/oauth/token",
+			UserInfoEndpoint:      issuer + "/oauth/userinfo",
+			JWKSURI:               issuer + "/.well-known/jwks.json",
+			ScopesSupported:       jwt.Scopes,
+			ClaimsSupported:       []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "amr", "azp", "email", "email_verified"},
+			ResponseTypes:         []string{responseTypeCode},
+			GrantTypes:            []string{models.GrantAuthorizationCode, models.GrantRefreshToken, models.GrantClientCredentials},
+			SubjectTypes:          []string{"public"},
+			SigningAlgorithms:     a.Algorithms(),
+			TokenAuthMethods:      []string{"client_secret_basic", "client_secret_post", "none"},
+			CodeChallengeMethods:  []string{challengeS256},
+		}
+
+		w.Header().Set(httplib.HeaderCacheControl, fmt.Sprintf("public, max-age=%d", discoveryMaxAge))
+		codec.ResponseJSON(w, resp, http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// UserInfo returns the claims about the user the client is authorized
+// for (OpenID Connect Core, section 5.3). The token must be issued
+// for the openid scope within a session that is still active.
+func UserInfo(log *slog.Logger, s Storage) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.oauth.UserInfo"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		claims := jwtMW.GetClaims(r.Context())
+		if claims == nil {
+			log.Error("claims are missing from the context")
+			writeError(w, errServerError, "", http.StatusInternalServerError)
+			return
+		}
+
+		log = log.With(slog.String("client_id", claims.ClientID))
+
+		if !jwt.HasScope(claims.Scope, jwt.ScopeOpenID) {
+			log.Info("token is not issued for openid")
+			w.Header().Set(httplib.HeaderWWWAuthenticate, `Bearer error="insufficient_scope", scope="openid"`)
+			writeError(w, errInsufficientScope, "", http.StatusForbidden)
+			return
+		}
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().ReadTimeout)
+		defer cancel()
+
+		if claims.Family != "" {
+			revoked, err := s.FamilyRevoked(ctxStorage, claims.Family)
+			if err != nil {
+				log.Error("failed to check session", logger.Error(err))
+				writeError(w, errServerError, "", http.StatusInternalServerError)
+				return
+			}
+			if revoked {
+				log.Info("session is revoked")
+				writeInvalidToken(w)
+				return
+			}
+		}
+
+		user, err := s.User(ctxStorage, claims.Subject)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				log.Warn("user not found", logger.Error(err))
+				writeInvalidToken(w)
+				return
+			}
+			log.Error("failed to get user", logger.Error(err))
+			writeError(w, errServerError, "", http.StatusInternalServerError)
+			return
+		}
+
+		resp := UserInfoResponse{
+			Subject:    user.ID,
+			UserClaims: jwt.NewUserClaims(user, claims.Scope),
+		}
+
+		noStore(w)
+		codec.ResponseJSON(w, resp, http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+func writeInvalidToken(w http.ResponseWriter) {
+	w.Header().Set(httplib.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+	writeError(w, errInvalidToken, "", http.StatusUnauthorized)
+}
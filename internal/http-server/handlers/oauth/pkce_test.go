@@ -0,0 +1,59 @@
+package oauth
+
+import (
+	"strings"
+	"testing"
+)
+
+// The example of RFC 7636, Appendix B
+const (
+	rfcVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	rfcChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+func TestVerifyPKCE(t *testing.T) {
+	tests := []struct {
+		name      string
+		verifier  string
+		challenge string
+		want      bool
+	}{
+		{name: "rfc 7636 appendix b", verifier: rfcVerifier, challenge: rfcChallenge, want: true},
+		{name: "wrong verifier", verifier: strings.Repeat("a", 43), challenge: rfcChallenge},
+		{name: "plain challenge", verifier: rfcVerifier, challenge: rfcVerifier},
+		{name: "missing verifier", challenge: rfcChallenge},
+		{name: "missing challenge", verifier: rfcVerifier},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyPKCE(tt.verifier, tt.challenge); got != tt.want {
+				t.Errorf("verifyPKCE() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidPKCE(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want bool
+	}{
+		{name: "too short", s: strings.Repeat("a", 42)},
+		{name: "shortest", s: strings.Repeat("a", 43), want: true},
+		{name: "longest", s: strings.Repeat("a", 128), want: true},
+		{name: "too long", s: strings.Repeat("a", 129)},
+		{name: "unreserved", s: "AZaz09-._~" + strings.Repeat("a", 33), want: true},
+		{name: "reserved", s: "+/=" + strings.Repeat("a", 40)},
+		{name: "not ascii", s: "é" + strings.Repeat("a", 42)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validPKCE(tt.s); got != tt.want {
+				t.Errorf("validPKCE(%q) = %v, want %v", tt.s, got, tt.want)
+			}
+		})
+	}
+}
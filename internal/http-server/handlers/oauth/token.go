@@ -0,0 +1,287 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/korikhin/auth/internal/domain/models"
+	httplib "github.com/korikhin/auth/internal/lib/http"
+	"github.com/korikhin/auth/internal/lib/http/codec"
+	"github.com/korikhin/auth/internal/lib/jwt"
+	"github.com/korikhin/auth/internal/lib/logger"
+	"github.com/korikhin/auth/internal/lib/password"
+	"github.com/korikhin/auth/internal/lib/session"
+	"github.com/korikhin/auth/internal/storage"
+
+	reqMW "github.com/korikhin/auth/internal/http-server/middleware/request"
+)
+
+const tokenTypeBearer = "Bearer"
+
+// TokenResponse follows RFC 6749, section 5.1.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	// IDToken is issued for the openid scope (OpenID Connect Core, section 3.1.3.3)
+	IDToken string `json:"id_token,omitempty"`
+}
+
+// grantError is an error of the grant to be returned to the client.
+type grantError struct {
+	code        string
+	description string
+}
+
+func (e *grantError) Error() string {
+	return e.code + ": " + e.description
+}
+
+// Token issues tokens to the authenticated client for the grant
+// (RFC 6749, section 3.2). The parameters are form-encoded.
+func Token(log *slog.Logger, a *jwt.JWTService, s Storage) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.oauth.Token"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		if err := r.ParseForm(); err != nil {
+			log.Error("failed to parse form", logger.Error(err))
+			writeError(w, errInvalidRequest, "malformed form", http.StatusBadRequest)
+			return
+		}
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().ReadTimeout)
+		defer cancel()
+
+		client, err := authenticateClient(ctxStorage, r, s)
+		if err != nil {
+			if errors.Is(err, errClientAuthFailed) {
+				log.Warn("client authentication failed")
+				if _, _, basic := r.BasicAuth(); basic {
+					w.Header().Set(httplib.HeaderWWWAuthenticate, `Basic realm="oauth"`)
+				}
+				writeError(w, errInvalidClient, "client authentication failed", http.StatusUnauthorized)
+				return
+			}
+			log.Error("failed to get client", logger.Error(err))
+			writeError(w, errServerError, "", http.StatusInternalServerError)
+			return
+		}
+
+		log = log.With(slog.String("client_id", client.ID))
+
+		grant := r.PostFormValue("grant_type")
+		if grant == "" {
+			writeError(w, errInvalidRequest, "grant_type is required", http.StatusBadRequest)
+			return
+		}
+
+		var resp *TokenResponse
+		switch grant {
+		case models.GrantAuthorizationCode:
+			resp, err = exchangeCode(a, s, client, r)
+		case models.GrantRefreshToken:
+			resp, err = refresh(a, s, client, r)
+		case models.GrantClientCredentials:
+			resp, err = clientCredentials(a, client, r)
+		default:
+			log.Info("unsupported grant type", slog.String("grant_type", grant))
+			writeError(w, errUnsupportedGrantType, "", http.StatusBadRequest)
+			return
+		}
+
+		if err != nil {
+			var ge *grantError
+			if errors.As(err, &ge) {
+				log.Info("grant refused", slog.String("grant_type", grant), logger.Error(err))
+				writeError(w, ge.code, ge.description, http.StatusBadRequest)
+				return
+			}
+			log.Error("cannot issue tokens", slog.String("grant_type", grant), logger.Error(err))
+			writeError(w, errServerError, "", http.StatusInternalServerError)
+			return
+		}
+
+		log.Info("tokens issued", slog.String("grant_type", grant))
+
+		noStore(w)
+		codec.ResponseJSON(w, resp, http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// exchangeCode implements RFC 6749, section 4.1.3 with the PKCE verifier.
+func exchangeCode(a *jwt.JWTService, s Storage, client *models.Client, r *http.Request) (*TokenResponse, error) {
+	if !client.AllowsGrant(models.GrantAuthorizationCode) {
+		return nil, &grantError{errUnauthorizedClient, "grant is not allowed for the client"}
+	}
+
+	code := r.PostFormValue("code")
+	if code == "" {
+		return nil, &grantError{errInvalidRequest, "code is required"}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+	defer cancel()
+
+	// The code is spent even if the exchange then fails
+	ac, err := s.ConsumeAuthorizationCode(ctx, password.HashToken(code))
+	if err != nil {
+		if errors.Is(err, storage.ErrAuthorizationCodeInvalid) {
+			return nil, &grantError{errInvalidGrant, "code is invalid or expired"}
+		}
+		return nil, err
+	}
+
+	if ac.ClientID != client.ID {
+		return nil, &grantError{errInvalidGrant, "code was issued to another client"}
+	}
+	// The redirect URI is required only if the authorization request had it
+	redirectURI := r.PostFormValue("redirect_uri")
+	if (ac.RedirectURIGiven || redirectURI != "") && redirectURI != ac.RedirectURI {
+		return nil, &grantError{errInvalidGrant, "code was issued to another redirect_uri"}
+	}
+	if !verifyPKCE(r.PostFormValue("code_verifier"), ac.CodeChallenge) {
+		return nil, &grantError{errInvalidGrant, "code_verifier does not match"}
+	}
+
+	user, err := s.User(ctx, ac.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return nil, &grantError{errInvalidGrant, "user not found"}
+		}
+		return nil, err
+	}
+
+	g := jwt.Grant{ClientID: client.ID, Scope: ac.Scope}
+
+	var resp *TokenResponse
+
+	// Refresh tokens are issued to the clients allowed to use them
+	if client.AllowsGrant(models.GrantRefreshToken) {
+		tokens, err := session.StartGrant(ctx, a, s, user, ac.AMR, ac.AuthTime, g)
+		if err != nil {
+			return nil, err
+		}
+		resp = tokenResponse(tokens.Access, tokens.AccessExp, tokens.Refresh, g.Scope)
+	} else {
+		access, exp, err := a.IssueAccess(user, "", ac.AMR, ac.AuthTime, g)
+		if err != nil {
+			return nil, err
+		}
+		resp = tokenResponse(access, exp, "", g.Scope)
+	}
+
+	t := jwt.IDToken{Nonce: ac.Nonce, AuthTime: ac.AuthTime, AMR: ac.AMR}
+	if err := withIDToken(a, resp, user, g, t); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// refresh implements RFC 6749, section 6. The scope cannot be narrowed,
+// the refresh token is rotated as in the first-party sessions.
+func refresh(a *jwt.JWTService, s Storage, client *models.Client, r *http.Request) (*TokenResponse, error) {
+	if !client.AllowsGrant(models.GrantRefreshToken) {
+		return nil, &grantError{errUnauthorizedClient, "grant is not allowed for the client"}
+	}
+
+	opts := jwt.ValidationOptions{
+		Issuer: a.Options.Issuer,
+		Leeway: a.Options.Leeway,
+	}
+
+	claims, err := a.ValidateRefresh(r.PostFormValue("refresh_token"), opts)
+	if err != nil || claims.ClientID != client.ID {
+		return nil, &grantError{errInvalidGrant, "refresh token is invalid"}
+	}
+
+	if scope := r.PostFormValue("scope"); scope != "" && scope != claims.Scope {
+		return nil, &grantError{errInvalidScope, "scope cannot be changed"}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+	defer cancel()
+
+	user, err := s.User(ctx, claims.Subject)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return nil, &grantError{errInvalidGrant, "user not found"}
+		}
+		return nil, err
+	}
+
+	tokens, err := session.Rotate(ctx, a, s, user, claims)
+	if err != nil {
+		if session.IsInvalid(err) {
+			return nil, &grantError{errInvalidGrant, "refresh token is invalid"}
+		}
+		return nil, err
+	}
+
+	resp := tokenResponse(tokens.Access, tokens.AccessExp, tokens.Refresh, claims.Scope)
+
+	// The nonce is not repeated on refresh (OpenID Connect Core, section 12.2)
+	t := jwt.IDToken{AuthTime: claims.AuthenticatedAt(), AMR: claims.AMR}
+	if err := withIDToken(a, resp, user, claims.Grant(), t); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// clientCredentials implements RFC 6749, section 4.4,
+// available to confidential clients only.
+func clientCredentials(a *jwt.JWTService, client *models.Client, r *http.Request) (*TokenResponse, error) {
+	if client.Public() || !client.AllowsGrant(models.GrantClientCredentials) {
+		return nil, &grantError{errUnauthorizedClient, "grant is not allowed for the client"}
+	}
+
+	scope, ok := grantedScope(client, r.PostFormValue("scope"))
+	if !ok {
+		return nil, &grantError{errInvalidScope, "scope is not allowed for the client"}
+	}
+
+	access, exp, err := a.IssueClientAccess(client.ID, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokenResponse(access, exp, "", scope), nil
+}
+
+// withIDToken adds an ID token to the response if the openid scope is granted.
+func withIDToken(a *jwt.JWTService, resp *TokenResponse, user *models.User, g jwt.Grant, t jwt.IDToken) error {
+	if !jwt.HasScope(g.Scope, jwt.ScopeOpenID) {
+		return nil
+	}
+
+	id, err := a.IssueID(user, g, t)
+	if err != nil {
+		return err
+	}
+	resp.IDToken = id
+
+	return nil
+}
+
+func tokenResponse(access string, exp time.Time, refresh, scope string) *TokenResponse {
+	return &TokenResponse{
+		AccessToken:  access,
+		TokenType:    tokenTypeBearer,
+		ExpiresIn:    int64(time.Until(exp).Seconds()),
+		RefreshToken: refresh,
+		Scope:        scope,
+	}
+}
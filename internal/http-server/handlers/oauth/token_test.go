@@ -0,0 +1,452 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/korikhin/auth/internal/config"
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/lib/jwt"
+	"github.com/korikhin/auth/internal/lib/password"
+	"github.com/korikhin/auth/internal/lib/session"
+	"github.com/korikhin/auth/internal/storage/memory"
+)
+
+const (
+	redirectURI = "https://app.example/callback"
+	authCode    = "code"
+)
+
+func newJWT(t *testing.T) *jwt.JWTService {
+	t.Helper()
+
+	pk, err := jwt.GenerateKey("ES256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pem, err := jwt.EncodePrivateKey(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return jwt.NewService(config.JWT{
+		Issuer:     "https://auth.example.com",
+		AccessTTL:  15 * time.Minute,
+		RefreshTTL: time.Hour,
+		VerifyTTL:  time.Hour,
+		MFATTL:     5 * time.Minute,
+		PrivateKey: string(pem),
+	})
+}
+
+func newStorage() *memory.Storage {
+	return memory.New(config.Storage{ReadTimeout: time.Second, WriteTimeout: time.Second})
+}
+
+func newUser(t *testing.T, s *memory.Storage) *models.User {
+	t.Helper()
+
+	id, err := s.SaveUser(context.Background(), "user@example.com", []byte("hash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := s.User(context.Background(), strconv.FormatUint(id, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func newClient(id string, grants ...string) *models.Client {
+	return &models.Client{
+		ID:           id,
+		RedirectURIs: []string{redirectURI},
+		GrantTypes:   grants,
+		Scopes:       []string{jwt.ScopeOpenID, "profile"},
+	}
+}
+
+// saveCode stores the code as the authorization endpoint does.
+func saveCode(t *testing.T, s *memory.Storage, c *models.AuthorizationCode) {
+	t.Helper()
+
+	c.Hash = password.HashToken(authCode)
+	if err := s.SaveAuthorizationCode(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func tokenRequest(form url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+// grantErrorCode returns the code of the grant error, or "" if there is none.
+func grantErrorCode(t *testing.T, err error) string {
+	t.Helper()
+
+	if err == nil {
+		return ""
+	}
+	var ge *grantError
+	if !errors.As(err, &ge) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return ge.code
+}
+
+func TestExchangeCode(t *testing.T) {
+	allGrants := []string{models.GrantAuthorizationCode, models.GrantRefreshToken}
+
+	tests := []struct {
+		name   string
+		client *models.Client
+		// spoil changes the stored code
+		spoil func(c *models.AuthorizationCode)
+		// form changes the token request
+		form        func(f url.Values)
+		want        string
+		wantRefresh bool
+	}{
+		{name: "valid", client: newClient("app", allGrants...), wantRefresh: true},
+		{name: "no refresh grant", client: newClient("app", models.GrantAuthorizationCode)},
+		{
+			name:   "grant not allowed",
+			client: newClient("app", models.GrantRefreshToken),
+			want:   errUnauthorizedClient,
+		},
+		{
+			name:   "code missing",
+			client: newClient("app", allGrants...),
+			form:   func(f url.Values) { f.Del("code") },
+			want:   errInvalidRequest,
+		},
+		{
+			name:   "unknown code",
+			client: newClient("app", allGrants...),
+			form:   func(f url.Values) { f.Set("code", "other") },
+			want:   errInvalidGrant,
+		},
+		{
+			name:   "expired code",
+			client: newClient("app", allGrants...),
+			spoil:  func(c *models.AuthorizationCode) { c.ExpiresAt = time.Now().Add(-time.Second) },
+			want:   errInvalidGrant,
+		},
+		{
+			name:   "another client",
+			client: newClient("other", allGrants...),
+			want:   errInvalidGrant,
+		},
+		{
+			name:   "wrong verifier",
+			client: newClient("app", allGrants...),
+			form:   func(f url.Values) { f.Set("code_verifier", strings.Repeat("a", 43)) },
+			want:   errInvalidGrant,
+		},
+		{
+			name:   "verifier missing",
+			client: newClient("app", allGrants...),
+			form:   func(f url.Values) { f.Del("code_verifier") },
+			want:   errInvalidGrant,
+		},
+		{
+			name:   "redirect_uri given, missing",
+			client: newClient("app", allGrants...),
+			form:   func(f url.Values) { f.Del("redirect_uri") },
+			want:   errInvalidGrant,
+		},
+		{
+			name:   "redirect_uri given, another",
+			client: newClient("app", allGrants...),
+			form:   func(f url.Values) { f.Set("redirect_uri", "https://app.example/other") },
+			want:   errInvalidGrant,
+		},
+		{
+			name:        "redirect_uri omitted, missing",
+			client:      newClient("app", allGrants...),
+			spoil:       func(c *models.AuthorizationCode) { c.RedirectURIGiven = false },
+			form:        func(f url.Values) { f.Del("redirect_uri") },
+			wantRefresh: true,
+		},
+		{
+			name:        "redirect_uri omitted, same",
+			client:      newClient("app", allGrants...),
+			spoil:       func(c *models.AuthorizationCode) { c.RedirectURIGiven = false },
+			wantRefresh: true,
+		},
+		{
+			name:   "redirect_uri omitted, another",
+			client: newClient("app", allGrants...),
+			spoil:  func(c *models.AuthorizationCode) { c.RedirectURIGiven = false },
+			form:   func(f url.Values) { f.Set("redirect_uri", "https://app.example/other") },
+			want:   errInvalidGrant,
+		},
+		{
+			name:   "user deleted",
+			client: newClient("app", allGrants...),
+			spoil:  func(c *models.AuthorizationCode) { c.UserID = "0" },
+			want:   errInvalidGrant,
+		},
+	}
+
+	a := newJWT(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStorage()
+			user := newUser(t, s)
+
+			c := &models.AuthorizationCode{
+				ClientID:         "app",
+				UserID:           user.ID,
+				RedirectURI:      redirectURI,
+				RedirectURIGiven: true,
+				Scope:            "openid profile",
+				CodeChallenge:    rfcChallenge,
+				AMR:              []string{jwt.AMRPassword},
+				AuthTime:         time.Now(),
+				Nonce:            "nonce",
+				ExpiresAt:        time.Now().Add(time.Minute),
+			}
+			if tt.spoil != nil {
+				tt.spoil(c)
+			}
+			saveCode(t, s, c)
+
+			form := url.Values{
+				"grant_type":    {models.GrantAuthorizationCode},
+				"code":          {authCode},
+				"redirect_uri":  {redirectURI},
+				"code_verifier": {rfcVerifier},
+			}
+			if tt.form != nil {
+				tt.form(form)
+			}
+
+			resp, err := exchangeCode(a, s, tt.client, tokenRequest(form))
+			if got := grantErrorCode(t, err); got != tt.want {
+				t.Fatalf("error = %v, want %q", err, tt.want)
+			}
+			if err != nil {
+				return
+			}
+
+			if resp.AccessToken == "" || resp.IDToken == "" || resp.Scope != "openid profile" {
+				t.Errorf("response = %+v", resp)
+			}
+			if got := resp.RefreshToken != ""; got != tt.wantRefresh {
+				t.Errorf("refresh token issued = %v, want %v", got, tt.wantRefresh)
+			}
+		})
+	}
+}
+
+// The code is spent by the first exchange, even a failed one.
+func TestExchangeCodeSingleUse(t *testing.T) {
+	tests := []struct {
+		name  string
+		first string
+	}{
+		{name: "after success", first: rfcVerifier},
+		{name: "after failure", first: strings.Repeat("a", 43)},
+	}
+
+	a := newJWT(t)
+	client := newClient("app", models.GrantAuthorizationCode)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStorage()
+			user := newUser(t, s)
+			saveCode(t, s, &models.AuthorizationCode{
+				ClientID:      client.ID,
+				UserID:        user.ID,
+				CodeChallenge: rfcChallenge,
+				ExpiresAt:     time.Now().Add(time.Minute),
+			})
+
+			exchange := func(verifier string) error {
+				form := url.Values{"code": {authCode}, "code_verifier": {verifier}}
+				_, err := exchangeCode(a, s, client, tokenRequest(form))
+				return err
+			}
+
+			_ = exchange(tt.first)
+			if err := exchange(rfcVerifier); grantErrorCode(t, err) != errInvalidGrant {
+				t.Fatalf("second exchange: error = %v, want %q", err, errInvalidGrant)
+			}
+		})
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	allGrants := []string{models.GrantAuthorizationCode, models.GrantRefreshToken}
+
+	a := newJWT(t)
+	s := newStorage()
+	user := newUser(t, s)
+	client := newClient("app", allGrants...)
+
+	start := func(t *testing.T, clientID string) string {
+		t.Helper()
+
+		g := jwt.Grant{ClientID: clientID, Scope: "openid profile"}
+		tokens, err := session.StartGrant(context.Background(), a, s, user, []string{jwt.AMRPassword}, time.Now(), g)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return tokens.Refresh
+	}
+	firstParty, err := session.Start(context.Background(), a, s, user, []string{jwt.AMRPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		client *models.Client
+		token  string
+		scope  string
+		want   string
+	}{
+		{name: "valid", client: client, token: start(t, client.ID)},
+		{name: "same scope", client: client, token: start(t, client.ID), scope: "openid profile"},
+		{name: "another scope", client: client, token: start(t, client.ID), scope: "openid", want: errInvalidScope},
+		{name: "another client", client: client, token: start(t, "other"), want: errInvalidGrant},
+		{name: "first-party session", client: client, token: firstParty.Refresh, want: errInvalidGrant},
+		{name: "access token", client: client, token: firstParty.Access, want: errInvalidGrant},
+		{name: "missing", client: client, want: errInvalidGrant},
+		{
+			name:   "grant not allowed",
+			client: newClient("app", models.GrantAuthorizationCode),
+			token:  start(t, client.ID),
+			want:   errUnauthorizedClient,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"refresh_token": {tt.token}}
+			if tt.scope != "" {
+				form.Set("scope", tt.scope)
+			}
+
+			resp, err := refresh(a, s, tt.client, tokenRequest(form))
+			if got := grantErrorCode(t, err); got != tt.want {
+				t.Fatalf("error = %v, want %q", err, tt.want)
+			}
+			if err == nil && (resp.RefreshToken == "" || resp.RefreshToken == tt.token || resp.Scope != "openid profile") {
+				t.Errorf("response = %+v", resp)
+			}
+		})
+	}
+}
+
+// A rotated refresh token presented again revokes the whole family.
+func TestRefreshRotation(t *testing.T) {
+	a := newJWT(t)
+	s := newStorage()
+	user := newUser(t, s)
+	client := newClient("app", models.GrantAuthorizationCode, models.GrantRefreshToken)
+
+	g := jwt.Grant{ClientID: client.ID, Scope: "profile"}
+	tokens, err := session.StartGrant(context.Background(), a, s, user, []string{jwt.AMRPassword}, time.Now(), g)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotate := func(token string) (*TokenResponse, error) {
+		return refresh(a, s, client, tokenRequest(url.Values{"refresh_token": {token}}))
+	}
+
+	next, err := rotate(tokens.Refresh)
+	if err != nil {
+		t.Fatalf("first rotation: %v", err)
+	}
+	if next.IDToken != "" {
+		t.Errorf("ID token issued without the openid scope")
+	}
+
+	if _, err := rotate(tokens.Refresh); grantErrorCode(t, err) != errInvalidGrant {
+		t.Fatalf("reused token: error = %v, want %q", err, errInvalidGrant)
+	}
+	if _, err := rotate(next.RefreshToken); grantErrorCode(t, err) != errInvalidGrant {
+		t.Errorf("token of the revoked family: error = %v, want %q", err, errInvalidGrant)
+	}
+}
+
+func TestClientCredentials(t *testing.T) {
+	confidential := func(grants ...string) *models.Client {
+		c := newClient("service", grants...)
+		c.SecretHash = []byte("hash")
+		return c
+	}
+
+	tests := []struct {
+		name      string
+		client    *models.Client
+		scope     string
+		want      string
+		wantScope string
+	}{
+		{
+			name:      "valid",
+			client:    confidential(models.GrantClientCredentials),
+			wantScope: "openid profile",
+		},
+		{
+			name:      "narrowed scope",
+			client:    confidential(models.GrantClientCredentials),
+			scope:     "profile profile",
+			wantScope: "profile",
+		},
+		{
+			name:   "scope not allowed",
+			client: confidential(models.GrantClientCredentials),
+			scope:  "profile admin",
+			want:   errInvalidScope,
+		},
+		{
+			name:   "public client",
+			client: newClient("service", models.GrantClientCredentials),
+			want:   errUnauthorizedClient,
+		},
+		{
+			name:   "grant not allowed",
+			client: confidential(models.GrantAuthorizationCode),
+			want:   errUnauthorizedClient,
+		},
+	}
+
+	a := newJWT(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			if tt.scope != "" {
+				form.Set("scope", tt.scope)
+			}
+
+			resp, err := clientCredentials(a, tt.client, tokenRequest(form))
+			if got := grantErrorCode(t, err); got != tt.want {
+				t.Fatalf("error = %v, want %q", err, tt.want)
+			}
+			if err != nil {
+				return
+			}
+
+			if resp.AccessToken == "" || resp.RefreshToken != "" || resp.IDToken != "" || resp.Scope != tt.wantScope {
+				t.Errorf("response = %+v", resp)
+			}
+		})
+	}
+}
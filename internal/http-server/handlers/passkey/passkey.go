@@ -0,0 +1,531 @@
+package passkey
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/korikhin/auth/internal/config"
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/lib/api"
+	"github.com/korikhin/auth/internal/lib/http/codec"
+	"github.com/korikhin/auth/internal/lib/jwt"
+	"github.com/korikhin/auth/internal/lib/logger"
+	"github.com/korikhin/auth/internal/lib/password"
+	"github.com/korikhin/auth/internal/lib/session"
+	"github.com/korikhin/auth/internal/lib/webauthn"
+	"github.com/korikhin/auth/internal/storage"
+
+	jwtMW "github.com/korikhin/auth/internal/http-server/middleware/jwt"
+	reqMW "github.com/korikhin/auth/internal/http-server/middleware/request"
+
+	"github.com/gorilla/mux"
+)
+
+type Storage interface {
+	storage.UserProvider
+	storage.SessionStorage
+	storage.WebAuthnStorage
+	storage.MFAStorage
+}
+
+// Attestation is the serialized `PublicKeyCredential` of a registration.
+type Attestation struct {
+	ID       webauthn.Bytes `json:"rawId" validate:"required"`
+	Type     string         `json:"type" validate:"eq=public-key"`
+	Response struct {
+		ClientDataJSON    webauthn.Bytes `json:"clientDataJSON" validate:"required"`
+		AttestationObject webauthn.Bytes `json:"attestationObject" validate:"required"`
+	} `json:"response"`
+}
+
+// Assertion is the serialized `PublicKeyCredential` of a login.
+type Assertion struct {
+	ID       webauthn.Bytes `json:"rawId" validate:"required"`
+	Type     string         `json:"type" validate:"eq=public-key"`
+	Response struct {
+		ClientDataJSON    webauthn.Bytes `json:"clientDataJSON" validate:"required"`
+		AuthenticatorData webauthn.Bytes `json:"authenticatorData" validate:"required"`
+		Signature         webauthn.Bytes `json:"signature" validate:"required"`
+		UserHandle        webauthn.Bytes `json:"userHandle"`
+	} `json:"response"`
+}
+
+type LoginOptionsRequest struct {
+	Email string `json:"email" validate:"omitempty,email"`
+}
+
+// Credential is the public view of a stored credential.
+type Credential struct {
+	ID         webauthn.Bytes `json:"id"`
+	AAGUID     webauthn.Bytes `json:"aaguid"`
+	CreatedAt  time.Time      `json:"created_at"`
+	LastUsedAt *time.Time     `json:"last_used_at,omitempty"`
+}
+
+var (
+	errInvalidChallenge  = api.Error("challenge is invalid or expired")
+	errInvalidCredential = api.Error("invalid credential")
+	errCredentialExists  = api.Error("credential is already registered")
+	errCredentialMissing = api.Error("credential not found")
+)
+
+// RegisterOptions starts the registration of a passkey for the current user.
+func RegisterOptions(log *slog.Logger, s Storage, rp *webauthn.RelyingParty) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.passkey.RegisterOptions"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().ReadTimeout)
+		defer cancel()
+
+		user, err := s.User(ctxStorage, subject(r))
+		if err != nil {
+			log.Error("failed to get user", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		creds, err := s.WebAuthnCredentials(ctxStorage, user.ID)
+		if err != nil {
+			log.Error("failed to get credentials", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		challenge, err := newChallenge(s, user.ID, webauthn.TypeCreate, rp.Timeout)
+		if err != nil {
+			log.Error("failed to save challenge", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		opts := rp.CreationOptions(challenge, []byte(user.ID), user.Email, credentialIDs(creds))
+		codec.ResponseJSON(w, opts, http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// Register verifies the attestation and stores the new credential.
+func Register(log *slog.Logger, s Storage, rp *webauthn.RelyingParty) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.passkey.Register"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		req := &Attestation{}
+		if err := codec.DecodeJSON(r.Body, req); err != nil {
+			log.Error("failed to decode request body", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+			return
+		}
+		if err := api.Validate(req); err != nil {
+			log.Error("bad request", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+			return
+		}
+
+		userID := subject(r)
+
+		clientData, challenge, err := consumeChallenge(s, req.Response.ClientDataJSON, webauthn.TypeCreate)
+		if err != nil || challenge.UserID != userID {
+			log.Info("invalid challenge", logger.Error(err))
+			codec.ResponseJSON(w, errInvalidChallenge, http.StatusBadRequest)
+			return
+		}
+
+		cred, err := rp.VerifyRegistration(clientData, clientData.Challenge, req.Response.AttestationObject)
+		if err != nil {
+			log.Info("invalid attestation", logger.Error(err))
+			codec.ResponseJSON(w, errInvalidCredential, http.StatusBadRequest)
+			return
+		}
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+		defer cancel()
+
+		record := &models.WebAuthnCredential{
+			ID:        cred.ID,
+			UserID:    userID,
+			PublicKey: cred.PublicKey,
+			AAGUID:    cred.AAGUID,
+			SignCount: cred.SignCount,
+		}
+		if err := s.SaveWebAuthnCredential(ctxStorage, record); err != nil {
+			if errors.Is(err, storage.ErrCredentialAlreadyExists) {
+				log.Info("credential already exists", logger.Error(err))
+				codec.ResponseJSON(w, errCredentialExists, http.StatusConflict)
+				return
+			}
+			log.Error("failed to save credential", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		log.Info("passkey registered", slog.String("user_id", userID))
+		resp := Credential{
+			ID:        cred.ID,
+			AAGUID:    cred.AAGUID,
+			CreatedAt: time.Now(),
+		}
+		codec.ResponseJSON(w, resp, http.StatusCreated)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// LoginOptions starts a passkey login. With the email given, the passkeys
+// of the account are listed for authenticators that cannot discover them.
+func LoginOptions(log *slog.Logger, s Storage, rp *webauthn.RelyingParty) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.passkey.LoginOptions"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		// The body is optional, discoverable passkeys need no email
+		req := &LoginOptionsRequest{}
+		if r.ContentLength != 0 {
+			if err := codec.DecodeJSON(r.Body, req); err != nil {
+				log.Error("failed to decode request body", logger.Error(err))
+				codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+				return
+			}
+			if err := api.Validate(req); err != nil {
+				log.Error("bad request", logger.Error(err))
+				codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		var userID string
+		var allow [][]byte
+
+		if req.Email != "" {
+			ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().ReadTimeout)
+			defer cancel()
+
+			// Unknown accounts get the same options as accounts without passkeys
+			user, err := s.UserByEmail(ctxStorage, req.Email)
+			if err != nil && !errors.Is(err, storage.ErrUserNotFound) {
+				log.Error("failed to get user", logger.Error(err))
+				codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+				return
+			}
+
+			if user != nil {
+				creds, err := s.WebAuthnCredentials(ctxStorage, user.ID)
+				if err != nil {
+					log.Error("failed to get credentials", logger.Error(err))
+					codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+					return
+				}
+				if len(creds) != 0 {
+					userID, allow = user.ID, credentialIDs(creds)
+				}
+			}
+		}
+
+		challenge, err := newChallenge(s, userID, webauthn.TypeGet, rp.Timeout)
+		if err != nil {
+			log.Error("failed to save challenge", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		codec.ResponseJSON(w, rp.RequestOptions(challenge, allow), http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// Login verifies the assertion and issues the same tokens as the password
+// login. Sessions with the user verified count as passed the second factor,
+// otherwise users with one enrolled are asked for it.
+func Login(log *slog.Logger, a *jwt.JWTService, s Storage, rp *webauthn.RelyingParty, cv config.Verification) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.passkey.Login"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		req := &Assertion{}
+		if err := codec.DecodeJSON(r.Body, req); err != nil {
+			log.Error("failed to decode request body", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+			return
+		}
+		if err := api.Validate(req); err != nil {
+			log.Error("bad request", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+			return
+		}
+
+		clientData, challenge, err := consumeChallenge(s, req.Response.ClientDataJSON, webauthn.TypeGet)
+		if err != nil {
+			log.Info("invalid challenge", logger.Error(err))
+			codec.ResponseJSON(w, errInvalidChallenge, http.StatusUnauthorized)
+			return
+		}
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().ReadTimeout)
+		defer cancel()
+
+		cred, err := s.WebAuthnCredential(ctxStorage, req.ID)
+		if err != nil {
+			if errors.Is(err, storage.ErrCredentialNotFound) {
+				log.Info("unknown credential", logger.Error(err))
+				codec.ResponseJSON(w, errInvalidCredential, http.StatusUnauthorized)
+				return
+			}
+			log.Error("failed to get credential", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		// The challenge may be issued for a specific account, the user
+		// handle is set by authenticators for discoverable credentials
+		if challenge.UserID != "" && challenge.UserID != cred.UserID ||
+			len(req.Response.UserHandle) != 0 && string(req.Response.UserHandle) != cred.UserID {
+			log.Warn("credential of another user", slog.String("user_id", cred.UserID))
+			codec.ResponseJSON(w, errInvalidCredential, http.StatusUnauthorized)
+			return
+		}
+
+		stored := &webauthn.Credential{
+			ID:        cred.ID,
+			PublicKey: cred.PublicKey,
+			SignCount: cred.SignCount,
+		}
+		assertion, err := rp.VerifyAssertion(clientData, clientData.Challenge, stored, req.Response.AuthenticatorData, req.Response.Signature)
+		if err != nil {
+			log.Warn("invalid assertion", slog.String("user_id", cred.UserID), logger.Error(err))
+			codec.ResponseJSON(w, errInvalidCredential, http.StatusUnauthorized)
+			return
+		}
+
+		ctxSave, cancelSave := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+		defer cancelSave()
+
+		if err := s.UseWebAuthnCredential(ctxSave, cred.ID, assertion.SignCount); err != nil {
+			log.Error("failed to update credential", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		user, err := s.User(ctxStorage, cred.UserID)
+		if err != nil {
+			log.Error("failed to get user", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		if cv.Required && !user.EmailVerified {
+			log.Info("email is not verified", slog.String("user_id", user.ID))
+			codec.ResponseJSON(w, api.Error("email is not verified"), http.StatusForbidden)
+			return
+		}
+
+		amr := []string{jwt.AMRHardwareKey, jwt.AMRUserPresence}
+
+		// Without user verification the passkey is a single factor,
+		// so the second one is required as for the password login
+		if !assertion.UserVerified {
+			enrolled, err := session.MFAEnrolled(s, user.ID)
+			if err != nil {
+				log.Error("failed to get second factor", logger.Error(err))
+				codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+				return
+			}
+			if enrolled {
+				token, exp, err := a.IssueMFAChallenge(user, amr)
+				if err != nil {
+					log.Error("cannot issue mfa challenge", logger.Error(err))
+					codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+					return
+				}
+
+				log.Info("second factor required", slog.String("user_id", user.ID))
+				challenge := api.MFAChallenge{
+					Status:    "mfa_required",
+					MFAToken:  token,
+					ExpiresIn: int64(time.Until(exp).Seconds()),
+				}
+				codec.ResponseJSON(w, challenge, http.StatusOK)
+				return
+			}
+		}
+
+		if assertion.UserVerified {
+			amr = append(amr, jwt.AMRMFA)
+		}
+
+		tokens, err := session.Start(ctxSave, a, s, user, amr)
+		if err != nil {
+			log.Error("cannot issue tokens", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+		tokens.Set(w)
+
+		codec.ResponseJSON(w, api.Ok("user logged successfully"), http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// List returns the passkeys of the current user.
+func List(log *slog.Logger, s Storage) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.passkey.List"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().ReadTimeout)
+		defer cancel()
+
+		creds, err := s.WebAuthnCredentials(ctxStorage, subject(r))
+		if err != nil {
+			log.Error("failed to get credentials", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		resp := make([]Credential, 0, len(creds))
+		for _, c := range creds {
+			item := Credential{
+				ID:        c.ID,
+				AAGUID:    c.AAGUID,
+				CreatedAt: c.CreatedAt,
+			}
+			if !c.LastUsedAt.IsZero() {
+				item.LastUsedAt = &c.LastUsedAt
+			}
+			resp = append(resp, item)
+		}
+
+		codec.ResponseJSON(w, resp, http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// Delete removes the passkey of the current user given by its ID.
+func Delete(log *slog.Logger, s Storage) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.passkey.Delete"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		id, err := base64.RawURLEncoding.DecodeString(mux.Vars(r)["id"])
+		if err != nil {
+			log.Info("invalid credential ID", logger.Error(err))
+			codec.ResponseJSON(w, errCredentialMissing, http.StatusNotFound)
+			return
+		}
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+		defer cancel()
+
+		userID := subject(r)
+		if err := s.DeleteWebAuthnCredential(ctxStorage, userID, id); err != nil {
+			if errors.Is(err, storage.ErrCredentialNotFound) {
+				log.Info("credential not found", logger.Error(err))
+				codec.ResponseJSON(w, errCredentialMissing, http.StatusNotFound)
+				return
+			}
+			log.Error("failed to delete credential", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		log.Info("passkey deleted", slog.String("user_id", userID))
+		codec.ResponseJSON(w, api.Ok("passkey successfully deleted"), http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// newChallenge saves a challenge for the ceremony and returns it,
+// only its hash is stored.
+func newChallenge(s Storage, userID, typ string, ttl time.Duration) (string, error) {
+	challenge, hash, err := password.NewToken()
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+	defer cancel()
+
+	c := &models.WebAuthnChallenge{
+		Hash:      hash,
+		UserID:    userID,
+		Type:      typ,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := s.SaveWebAuthnChallenge(ctx, c); err != nil {
+		return "", err
+	}
+
+	return challenge, nil
+}
+
+// consumeChallenge looks up the ceremony by the challenge signed in the
+// client data. The challenge is spent even if the ceremony then fails.
+func consumeChallenge(s Storage, raw []byte, typ string) (*webauthn.ClientData, *models.WebAuthnChallenge, error) {
+	clientData, err := webauthn.ParseClientData(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+	defer cancel()
+
+	c, err := s.ConsumeWebAuthnChallenge(ctx, password.HashToken(clientData.Challenge))
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.Type != typ {
+		return nil, nil, storage.ErrChallengeInvalid
+	}
+
+	return clientData, c, nil
+}
+
+func credentialIDs(creds []models.WebAuthnCredential) [][]byte {
+	ids := make([][]byte, 0, len(creds))
+	for _, c := range creds {
+		ids = append(ids, c.ID)
+	}
+
+	return ids
+}
+
+func subject(r *http.Request) string {
+	if c := jwtMW.GetClaims(r.Context()); c != nil {
+		return c.Subject
+	}
+
+	return ""
+}
@@ -0,0 +1,261 @@
+package password
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/korikhin/auth/internal/config"
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/lib/api"
+	"github.com/korikhin/auth/internal/lib/http/codec"
+	"github.com/korikhin/auth/internal/lib/logger"
+	"github.com/korikhin/auth/internal/lib/notify"
+	"github.com/korikhin/auth/internal/lib/password"
+	"github.com/korikhin/auth/internal/storage"
+
+	jwtMW "github.com/korikhin/auth/internal/http-server/middleware/jwt"
+	reqMW "github.com/korikhin/auth/internal/http-server/middleware/request"
+)
+
+type Storage interface {
+	storage.UserProvider
+	storage.UserSaver
+	storage.SessionStorage
+	storage.ResetTokenStorage
+}
+
+type Change struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,password"`
+}
+
+type Reset struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetConfirmation struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,password"`
+}
+
+var (
+	errUserNotFound      = api.Error("user not found")
+	errInvalidResetToken = api.Error("reset token is invalid or expired")
+	errCannotSetPassword = api.Error("cannot set password")
+	resetAccepted        = api.Ok("if the account exists, a reset link has been sent")
+)
+
+// ChangeMe changes the password of the current user. The current password
+// is required, all the other sessions of the user are ended.
+func ChangeMe(log *slog.Logger, s Storage, h password.Hasher) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.password.ChangeMe"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		claims := jwtMW.GetClaims(r.Context())
+		if claims == nil {
+			log.Error("claims are missing from the context")
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		req := &Change{}
+		if err := codec.DecodeJSON(r.Body, req); err != nil {
+			log.Error("failed to decode request body", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+			return
+		}
+		if err := api.Validate(req); err != nil {
+			log.Error("bad request", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+			return
+		}
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().ReadTimeout)
+		defer cancel()
+
+		user, err := s.User(ctxStorage, claims.Subject)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				log.Warn("user not found", logger.Error(err))
+				codec.ResponseJSON(w, errUserNotFound, http.StatusNotFound)
+				return
+			}
+			log.Error("failed to get user", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		if err := password.Compare(user.PasswordHash, req.CurrentPassword); err != nil {
+			log.Info("invalid credentials", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("invalid credentials"), http.StatusUnauthorized)
+			return
+		}
+
+		if err := setPassword(s, h, user.ID, req.NewPassword, claims.Family); err != nil {
+			log.Error("failed to set password", logger.Error(err))
+			codec.ResponseJSON(w, errCannotSetPassword, http.StatusInternalServerError)
+			return
+		}
+
+		log.Info("password changed", slog.String("user_id", user.ID))
+		codec.ResponseJSON(w, api.Ok("password successfully changed"), http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// RequestReset sends a single-use reset link to the user. The response
+// is the same whether the user exists or not.
+func RequestReset(log *slog.Logger, s Storage, n notify.Notifier, c config.Password) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.password.RequestReset"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		req := &Reset{}
+		if err := codec.DecodeJSON(r.Body, req); err != nil {
+			log.Error("failed to decode request body", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+			return
+		}
+		if err := api.Validate(req); err != nil {
+			log.Error("bad request", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+			return
+		}
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().ReadTimeout)
+		defer cancel()
+
+		user, err := s.UserByEmail(ctxStorage, req.Email)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				log.Info("reset requested for unknown user")
+				codec.ResponseJSON(w, resetAccepted, http.StatusAccepted)
+				return
+			}
+			log.Error("failed to get user", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		// From here on failures are only logged, the response
+		// must not tell existing accounts from unknown ones
+		token, hash, err := password.NewToken()
+		if err != nil {
+			log.Error("failed to create reset token", logger.Error(err))
+			codec.ResponseJSON(w, resetAccepted, http.StatusAccepted)
+			return
+		}
+
+		ctxSave, cancelSave := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+		defer cancelSave()
+
+		t := &models.ResetToken{
+			Hash:      hash,
+			UserID:    user.ID,
+			ExpiresAt: time.Now().Add(c.ResetTTL),
+		}
+		if err := s.SaveResetToken(ctxSave, t); err != nil {
+			log.Error("failed to save reset token", logger.Error(err))
+			codec.ResponseJSON(w, resetAccepted, http.StatusAccepted)
+			return
+		}
+
+		m := notify.Message{
+			To:      user.Email,
+			Subject: "Password reset",
+			Body: fmt.Sprintf(
+				"Follow the link to set a new password, it expires in %s:\n%s",
+				c.ResetTTL, notify.Link(c.ResetURL, token),
+			),
+		}
+		notify.Async(log, n, m)
+
+		log.Info("reset link sent", slog.String("user_id", user.ID))
+		codec.ResponseJSON(w, resetAccepted, http.StatusAccepted)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// ConfirmReset sets the new password with a reset token
+// and ends all the sessions of the user.
+func ConfirmReset(log *slog.Logger, s Storage, h password.Hasher) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.password.ConfirmReset"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		req := &ResetConfirmation{}
+		if err := codec.DecodeJSON(r.Body, req); err != nil {
+			log.Error("failed to decode request body", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+			return
+		}
+		if err := api.Validate(req); err != nil {
+			log.Error("bad request", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+			return
+		}
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+		defer cancel()
+
+		userID, err := s.ConsumeResetToken(ctxStorage, password.HashToken(req.Token))
+		if err != nil {
+			if errors.Is(err, storage.ErrResetTokenInvalid) {
+				log.Info("invalid reset token", logger.Error(err))
+				codec.ResponseJSON(w, errInvalidResetToken, http.StatusBadRequest)
+				return
+			}
+			log.Error("failed to consume reset token", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		if err := setPassword(s, h, userID, req.Password, ""); err != nil {
+			log.Error("failed to set password", logger.Error(err))
+			codec.ResponseJSON(w, errCannotSetPassword, http.StatusInternalServerError)
+			return
+		}
+
+		log.Info("password reset", slog.String("user_id", userID))
+		codec.ResponseJSON(w, api.Ok("password successfully reset"), http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// setPassword stores the new password and revokes the sessions of the user
+// except for the given family.
+func setPassword(s Storage, h password.Hasher, userID, pw, keep string) error {
+	hash, err := h.Hash(pw)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+	defer cancel()
+
+	if err := s.UpdateUserPassword(ctx, userID, hash); err != nil {
+		return err
+	}
+
+	return s.RevokeUserTokens(ctx, userID, keep)
+}
@@ -0,0 +1,138 @@
+package refresh
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/korikhin/auth/internal/lib/api"
+	"github.com/korikhin/auth/internal/lib/http/codec"
+	"github.com/korikhin/auth/internal/lib/jwt"
+	"github.com/korikhin/auth/internal/lib/logger"
+	"github.com/korikhin/auth/internal/lib/session"
+	"github.com/korikhin/auth/internal/storage"
+
+	reqMW "github.com/korikhin/auth/internal/http-server/middleware/request"
+)
+
+type Storage interface {
+	storage.UserProvider
+	storage.SessionStorage
+}
+
+type Request struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+var errInvalidToken = api.Error("invalid token")
+
+// New exchanges a refresh token for a new token pair.
+//
+// Browsers send the token in the cookie and get the new one back the same way.
+// Other clients post it in the body and receive both tokens in the response.
+func New(log *slog.Logger, a *jwt.JWTService, s Storage) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.refresh.New"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		refreshToken, err := jwt.GetRefreshToken(r)
+		fromCookie := err == nil
+		if !fromCookie && r.ContentLength != 0 {
+			req := &Request{}
+			if err := codec.DecodeJSON(r.Body, req); err != nil {
+				log.Error("failed to decode request body", logger.Error(err))
+				codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+				return
+			}
+			refreshToken = req.RefreshToken
+		}
+		if refreshToken == "" {
+			log.Error("refresh token is missing")
+			codec.ResponseJSON(w, api.Error("token is missing"), http.StatusUnauthorized)
+			return
+		}
+
+		unauthorized := func() {
+			if fromCookie {
+				jwt.ClearRefreshToken(w)
+			}
+			codec.ResponseJSON(w, errInvalidToken, http.StatusUnauthorized)
+		}
+
+		opts := jwt.ValidationOptions{
+			Issuer: a.Options.Issuer,
+			Leeway: a.Options.Leeway,
+		}
+
+		claims, err := a.ValidateRefresh(refreshToken, opts)
+		if err != nil {
+			log.Info("cannot validate refresh token", logger.Error(err))
+			unauthorized()
+			return
+		}
+
+		// Clients refresh their tokens with `POST /oauth/token`
+		if claims.ClientID != "" {
+			log.Warn("refresh token is issued to a client", slog.String("client_id", claims.ClientID))
+			unauthorized()
+			return
+		}
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().ReadTimeout)
+		defer cancel()
+
+		user, err := s.User(ctxStorage, claims.Subject)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				log.Warn("user not found", logger.Error(err))
+				unauthorized()
+				return
+			}
+			log.Error("failed to get user", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		ctxSession, cancelSession := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+		defer cancelSession()
+
+		tokens, err := session.Rotate(ctxSession, a, s, user, claims)
+		if err != nil {
+			if errors.Is(err, storage.ErrRefreshTokenReused) {
+				log.Warn("refresh token reuse detected, family revoked", logger.Error(err))
+				unauthorized()
+				return
+			}
+			if session.IsInvalid(err) {
+				log.Info("cannot rotate refresh token", logger.Error(err))
+				unauthorized()
+				return
+			}
+			log.Error("cannot issue tokens", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		resp := api.Tokens{
+			AccessToken: tokens.Access,
+			TokenType:   "Bearer",
+			ExpiresIn:   int64(time.Until(tokens.AccessExp).Seconds()),
+		}
+		if fromCookie {
+			tokens.Set(w)
+		} else {
+			jwt.SetAccessToken(w, tokens.Access)
+			resp.RefreshToken = tokens.Refresh
+		}
+
+		codec.ResponseJSON(w, resp, http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
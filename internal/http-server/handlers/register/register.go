@@ -2,27 +2,35 @@ package register
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
 
+	"github.com/korikhin/auth/internal/config"
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/http-server/handlers/verify"
 	"github.com/korikhin/auth/internal/lib/api"
 	"github.com/korikhin/auth/internal/lib/http/codec"
+	"github.com/korikhin/auth/internal/lib/jwt"
 	"github.com/korikhin/auth/internal/lib/logger"
-	storage "github.com/korikhin/auth/internal/storage/postgres"
+	"github.com/korikhin/auth/internal/lib/notify"
+	"github.com/korikhin/auth/internal/lib/password"
+	"github.com/korikhin/auth/internal/storage"
 
 	reqMW "github.com/korikhin/auth/internal/http-server/middleware/request"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
-const hashCost = 7
-
 var (
 	errCannotCreateUser = api.Error("cannot create user")
+	accepted            = api.Ok("registration accepted, please check your email")
 )
 
-func New(log *slog.Logger, s *storage.Storage) http.Handler {
+// New registers the user and sends the email verification link.
+// In the uniform mode taken emails are not revealed, the response is
+// the same and the owner of the account gets a notice instead.
+func New(log *slog.Logger, a *jwt.JWTService, s storage.UserSaver, h password.Hasher, n notify.Notifier, cv config.Verification, cr config.Registration) http.Handler {
 	handler := func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.register.New"
 
@@ -47,26 +55,53 @@ func New(log *slog.Logger, s *storage.Storage) http.Handler {
 			return
 		}
 
-		hash, err := bcrypt.GenerateFromPassword([]byte(c.Password), hashCost)
+		hash, err := h.Hash(c.Password)
 		if err != nil {
 			log.Error("failed to create password hash", logger.Error(err))
 			codec.ResponseJSON(w, errCannotCreateUser, http.StatusInternalServerError)
 			return
 		}
 
-		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options.WriteTimeout)
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
 		defer cancel()
 
 		userID, err := s.SaveUser(ctxStorage, c.Email, hash)
+		if err != nil && cr.Uniform && errors.Is(err, storage.ErrUserAlreadyExists) {
+			log.Info("email is already taken", logger.Error(err))
+			// Delivered in the background as the verification link is
+			notify.Async(log, n, takenNotice(c.Email))
+			codec.ResponseJSON(w, accepted, http.StatusAccepted)
+			return
+		}
 		if err != nil {
 			log.Error("failed to register the user", logger.Error(err))
 			codec.ResponseJSON(w, errCannotCreateUser, http.StatusInternalServerError)
 			return
 		}
 
+		// The account is created anyway, the link can be requested again
+		user := &models.User{ID: strconv.FormatUint(userID, 10), Email: c.Email}
+		if err := verify.SendAsync(log, a, n, cv, user); err != nil {
+			log.Error("failed to send verification link", logger.Error(err))
+		}
+
+		if cr.Uniform {
+			codec.ResponseJSON(w, accepted, http.StatusAccepted)
+			return
+		}
+
 		response := api.Ok(fmt.Sprintf("user successfully registered: %v", userID))
 		codec.ResponseJSON(w, response, http.StatusCreated)
 	}
 
 	return http.HandlerFunc(handler)
 }
+
+func takenNotice(email string) notify.Message {
+	return notify.Message{
+		To:      email,
+		Subject: "Registration attempt",
+		Body: "Someone tried to register with this email, but you already have an account.\n" +
+			"If it was you, log in or reset your password. Otherwise, ignore this message.",
+	}
+}
@@ -0,0 +1,273 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/korikhin/auth/internal/config"
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/http-server/handlers/verify"
+	"github.com/korikhin/auth/internal/lib/api"
+	"github.com/korikhin/auth/internal/lib/http/codec"
+	"github.com/korikhin/auth/internal/lib/jwt"
+	"github.com/korikhin/auth/internal/lib/logger"
+	"github.com/korikhin/auth/internal/lib/notify"
+	"github.com/korikhin/auth/internal/lib/password"
+	"github.com/korikhin/auth/internal/storage"
+
+	jwtMW "github.com/korikhin/auth/internal/http-server/middleware/jwt"
+	reqMW "github.com/korikhin/auth/internal/http-server/middleware/request"
+
+	"github.com/gorilla/mux"
+)
+
+// Pagination
+const (
+	limitDefault = 50
+	limitMax     = 500
+)
+
+type EmailUpdate struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+}
+
+type List struct {
+	Users []models.User `json:"users"`
+	// Next is the cursor for the next page, empty on the last one
+	Next string `json:"next,omitempty"`
+}
+
+var (
+	errUserNotFound = api.Error("user not found")
+	errEmailTaken   = api.Error("email is already taken")
+)
+
+// Me returns the current user.
+func Me(log *slog.Logger, s storage.UserProvider) http.Handler {
+	return get(log, s, "handlers.users.Me", subject)
+}
+
+// Get returns the user by ID.
+func Get(log *slog.Logger, s storage.UserProvider) http.Handler {
+	return get(log, s, "handlers.users.Get", pathID)
+}
+
+func get(log *slog.Logger, s storage.UserProvider, op string, id func(r *http.Request) string) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().ReadTimeout)
+		defer cancel()
+
+		user, err := s.User(ctxStorage, id(r))
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				log.Warn("user not found", logger.Error(err))
+				codec.ResponseJSON(w, errUserNotFound, http.StatusNotFound)
+				return
+			}
+			log.Error("failed to get user", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		codec.ResponseJSON(w, user, http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// UpdateMe changes the email of the current user,
+// the current password is required to confirm the change.
+// A new email is unverified until the link sent to it is followed.
+func UpdateMe(log *slog.Logger, a *jwt.JWTService, s storage.Storage, n notify.Notifier, cv config.Verification) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.users.UpdateMe"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		req := &EmailUpdate{}
+		if err := codec.DecodeJSON(r.Body, req); err != nil {
+			log.Error("failed to decode request body", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+			return
+		}
+		if err := api.Validate(req); err != nil {
+			log.Error("bad request", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+			return
+		}
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().ReadTimeout)
+		defer cancel()
+
+		user, err := s.User(ctxStorage, subject(r))
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				log.Warn("user not found", logger.Error(err))
+				codec.ResponseJSON(w, errUserNotFound, http.StatusNotFound)
+				return
+			}
+			log.Error("failed to get user", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		if err := password.Compare(user.PasswordHash, req.Password); err != nil {
+			log.Info("invalid credentials", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("invalid credentials"), http.StatusUnauthorized)
+			return
+		}
+
+		ctxUpdate, cancelUpdate := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+		defer cancelUpdate()
+
+		if err := s.UpdateUserEmail(ctxUpdate, user.ID, req.Email); err != nil {
+			if errors.Is(err, storage.ErrUserAlreadyExists) {
+				log.Info("email is already taken", logger.Error(err))
+				codec.ResponseJSON(w, errEmailTaken, http.StatusConflict)
+				return
+			}
+			log.Error("failed to update user", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		if user.Email != req.Email {
+			user.Email = req.Email
+			user.EmailVerified = false
+
+			if err := verify.SendAsync(log, a, n, cv, user); err != nil {
+				log.Error("failed to send verification link", logger.Error(err))
+			}
+		}
+
+		codec.ResponseJSON(w, user, http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// DeleteMe deletes the current user and ends all the sessions.
+func DeleteMe(log *slog.Logger, s storage.UserSaver) http.Handler {
+	return remove(log, s, "handlers.users.DeleteMe", subject)
+}
+
+// Delete deletes the user by ID.
+func Delete(log *slog.Logger, s storage.UserSaver) http.Handler {
+	return remove(log, s, "handlers.users.Delete", pathID)
+}
+
+func remove(log *slog.Logger, s storage.UserSaver, op string, id func(r *http.Request) string) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		userID := id(r)
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+		defer cancel()
+
+		if err := s.DeleteUser(ctxStorage, userID); err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				log.Warn("user not found", logger.Error(err))
+				codec.ResponseJSON(w, errUserNotFound, http.StatusNotFound)
+				return
+			}
+			log.Error("failed to delete user", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		if userID == subject(r) {
+			jwt.ClearRefreshToken(w)
+		}
+
+		log.Info("user deleted", slog.String("user_id", userID))
+		codec.ResponseJSON(w, api.Ok("user successfully deleted"), http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// ListAll returns a page of users. The page is selected with the `cursor`
+// query parameter taken from the previous response, its size with `limit`.
+func ListAll(log *slog.Logger, s storage.UserLister) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.users.ListAll"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		q := r.URL.Query()
+
+		limit := limitDefault
+		if v := q.Get("limit"); v != "" {
+			l, err := strconv.Atoi(v)
+			if err != nil || l < 1 || l > limitMax {
+				log.Error("bad request: invalid limit", slog.String("limit", v))
+				codec.ResponseJSON(w, api.Error("bad request", "invalid limit"), http.StatusBadRequest)
+				return
+			}
+			limit = l
+		}
+
+		var after uint64
+		if v := q.Get("cursor"); v != "" {
+			c, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				log.Error("bad request: invalid cursor", slog.String("cursor", v))
+				codec.ResponseJSON(w, api.Error("bad request", "invalid cursor"), http.StatusBadRequest)
+				return
+			}
+			after = c
+		}
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().ReadTimeout)
+		defer cancel()
+
+		// One extra user tells whether there is a next page
+		users, err := s.Users(ctxStorage, after, limit+1)
+		if err != nil {
+			log.Error("failed to list users", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		resp := List{Users: users}
+		if len(users) > limit {
+			resp.Users = users[:limit]
+			resp.Next = users[limit-1].ID
+		}
+
+		codec.ResponseJSON(w, resp, http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+func subject(r *http.Request) string {
+	if c := jwtMW.GetClaims(r.Context()); c != nil {
+		return c.Subject
+	}
+
+	return ""
+}
+
+func pathID(r *http.Request) string {
+	return mux.Vars(r)["id"]
+}
@@ -0,0 +1,183 @@
+package verify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/korikhin/auth/internal/config"
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/lib/api"
+	"github.com/korikhin/auth/internal/lib/http/codec"
+	"github.com/korikhin/auth/internal/lib/jwt"
+	"github.com/korikhin/auth/internal/lib/logger"
+	"github.com/korikhin/auth/internal/lib/notify"
+	"github.com/korikhin/auth/internal/storage"
+
+	reqMW "github.com/korikhin/auth/internal/http-server/middleware/request"
+)
+
+type Storage interface {
+	storage.UserProvider
+	storage.UserSaver
+}
+
+type Verification struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type Resending struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+var (
+	errInvalidToken = api.Error("verification token is invalid or expired")
+	resendAccepted  = api.Ok("if the account exists and is not verified, a verification link has been sent")
+)
+
+// SendAsync sends the verification link for the current email of the user
+// in the background, only issuing the token can fail here.
+func SendAsync(log *slog.Logger, a *jwt.JWTService, n notify.Notifier, c config.Verification, user *models.User) error {
+	m, err := message(a, c, user)
+	if err != nil {
+		return err
+	}
+
+	notify.Async(log, n, m)
+	return nil
+}
+
+func message(a *jwt.JWTService, c config.Verification, user *models.User) (notify.Message, error) {
+	token, err := a.IssueVerification(user)
+	if err != nil {
+		return notify.Message{}, err
+	}
+
+	m := notify.Message{
+		To:      user.Email,
+		Subject: "Email verification",
+		Body: fmt.Sprintf(
+			"Follow the link to verify your email, it expires in %s:\n%s",
+			a.Options.VerifyTTL, notify.Link(c.URL, token),
+		),
+	}
+
+	return m, nil
+}
+
+// New marks the email as verified with the token from the verification link.
+func New(log *slog.Logger, a *jwt.JWTService, s Storage) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.verify.New"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		req := &Verification{}
+		if err := codec.DecodeJSON(r.Body, req); err != nil {
+			log.Error("failed to decode request body", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+			return
+		}
+		if err := api.Validate(req); err != nil {
+			log.Error("bad request", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+			return
+		}
+
+		opts := jwt.ValidationOptions{
+			Issuer: a.Options.Issuer,
+			Leeway: a.Options.Leeway,
+		}
+
+		claims, err := a.ValidateVerification(req.Token, opts)
+		if err != nil {
+			log.Info("invalid verification token", logger.Error(err))
+			codec.ResponseJSON(w, errInvalidToken, http.StatusBadRequest)
+			return
+		}
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+		defer cancel()
+
+		// The email may have changed since the token was issued
+		if err := s.VerifyUserEmail(ctxStorage, claims.Subject, claims.Email); err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				log.Info("verification token is outdated", logger.Error(err))
+				codec.ResponseJSON(w, errInvalidToken, http.StatusBadRequest)
+				return
+			}
+			log.Error("failed to verify email", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		log.Info("email verified", slog.String("user_id", claims.Subject))
+		codec.ResponseJSON(w, api.Ok("email successfully verified"), http.StatusOK)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// Resend sends the verification link again. The response is the same
+// whether the user exists or not.
+func Resend(log *slog.Logger, a *jwt.JWTService, s Storage, n notify.Notifier, c config.Verification) http.Handler {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.verify.Resend"
+
+		log := log.With(
+			logger.Operation(op),
+			logger.RequestID(reqMW.GetID(r.Context())),
+		)
+
+		req := &Resending{}
+		if err := codec.DecodeJSON(r.Body, req); err != nil {
+			log.Error("failed to decode request body", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+			return
+		}
+		if err := api.Validate(req); err != nil {
+			log.Error("bad request", logger.Error(err))
+			codec.ResponseJSON(w, api.Error("bad request", err), http.StatusBadRequest)
+			return
+		}
+
+		ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().ReadTimeout)
+		defer cancel()
+
+		user, err := s.UserByEmail(ctxStorage, req.Email)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				log.Info("verification requested for unknown user")
+				codec.ResponseJSON(w, resendAccepted, http.StatusAccepted)
+				return
+			}
+			log.Error("failed to get user", logger.Error(err))
+			codec.ResponseJSON(w, api.InternalError, http.StatusInternalServerError)
+			return
+		}
+
+		if user.EmailVerified {
+			log.Info("email is already verified", slog.String("user_id", user.ID))
+			codec.ResponseJSON(w, resendAccepted, http.StatusAccepted)
+			return
+		}
+
+		// Failures are only logged, so that the response does not
+		// tell unverified accounts from the others
+		if err := SendAsync(log, a, n, c, user); err != nil {
+			log.Error("failed to send verification link", logger.Error(err))
+			codec.ResponseJSON(w, resendAccepted, http.StatusAccepted)
+			return
+		}
+
+		log.Info("verification link sent", slog.String("user_id", user.ID))
+		codec.ResponseJSON(w, resendAccepted, http.StatusAccepted)
+	}
+
+	return http.HandlerFunc(handler)
+}
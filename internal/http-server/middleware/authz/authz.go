@@ -0,0 +1,125 @@
+package authz
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/korikhin/auth/internal/lib/api"
+	"github.com/korikhin/auth/internal/lib/http/codec"
+	"github.com/korikhin/auth/internal/lib/logger"
+
+	jwtMW "github.com/korikhin/auth/internal/http-server/middleware/jwt"
+	reqMW "github.com/korikhin/auth/internal/http-server/middleware/request"
+)
+
+var (
+	errForbidden   = api.Error("access denied")
+	errMFARequired = api.Error("access denied: second factor required")
+	errAPIKey      = api.Error("access denied: not allowed with api keys")
+)
+
+// RequireRole lets the request through if the user has any of the roles.
+// Must be used after the jwt middleware.
+func RequireRole(log *slog.Logger, roles ...string) func(next http.Handler) http.Handler {
+	log = log.With(logger.Component("middleware/authz"))
+
+	return func(next http.Handler) http.Handler {
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			claims := jwtMW.GetClaims(r.Context())
+			if claims == nil || !slices.Contains(roles, claims.UserRole) {
+				log.Warn(
+					"access denied: role is missing",
+					logger.RequestID(reqMW.GetID(r.Context())),
+					slog.Any("required", roles),
+				)
+				codec.ResponseJSON(w, errForbidden, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(handler)
+	}
+}
+
+// RequirePermission lets the request through if the user has all the permissions.
+// Must be used after the jwt middleware.
+func RequirePermission(log *slog.Logger, permissions ...string) func(next http.Handler) http.Handler {
+	log = log.With(logger.Component("middleware/authz"))
+
+	return func(next http.Handler) http.Handler {
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			claims := jwtMW.GetClaims(r.Context())
+
+			granted := claims != nil
+			for _, p := range permissions {
+				granted = granted && slices.Contains(claims.Permissions, p)
+			}
+
+			if !granted {
+				log.Warn(
+					"access denied: permission is missing",
+					logger.RequestID(reqMW.GetID(r.Context())),
+					slog.Any("required", permissions),
+				)
+				codec.ResponseJSON(w, errForbidden, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(handler)
+	}
+}
+
+// RequireMFA lets the request through if the session has passed
+// the second factor. Must be used after the jwt middleware.
+func RequireMFA(log *slog.Logger) func(next http.Handler) http.Handler {
+	log = log.With(logger.Component("middleware/authz"))
+
+	return func(next http.Handler) http.Handler {
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			claims := jwtMW.GetClaims(r.Context())
+			if claims == nil || !claims.HasMFA() {
+				log.Warn(
+					"access denied: second factor is missing",
+					logger.RequestID(reqMW.GetID(r.Context())),
+				)
+				codec.ResponseJSON(w, errMFARequired, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(handler)
+	}
+}
+
+// RequireSession lets the request through if it is authorized by a login
+// session rather than an API key, so that a leaked key cannot take over
+// the account. Must be used after the jwt middleware.
+func RequireSession(log *slog.Logger) func(next http.Handler) http.Handler {
+	log = log.With(logger.Component("middleware/authz"))
+
+	return func(next http.Handler) http.Handler {
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			claims := jwtMW.GetClaims(r.Context())
+			if claims == nil || claims.APIKeyID != "" {
+				log.Warn(
+					"access denied: api key is used",
+					logger.RequestID(reqMW.GetID(r.Context())),
+				)
+				codec.ResponseJSON(w, errAPIKey, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(handler)
+	}
+}
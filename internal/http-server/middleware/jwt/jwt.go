@@ -6,17 +6,31 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"time"
 
+	"github.com/korikhin/auth/internal/lib/apikey"
 	ctxlib "github.com/korikhin/auth/internal/lib/context"
+	httplib "github.com/korikhin/auth/internal/lib/http"
 	"github.com/korikhin/auth/internal/lib/jwt"
 	"github.com/korikhin/auth/internal/lib/logger"
-	storage "github.com/korikhin/auth/internal/storage/postgres"
+	"github.com/korikhin/auth/internal/lib/session"
+	"github.com/korikhin/auth/internal/storage"
 
 	reqMW "github.com/korikhin/auth/internal/http-server/middleware/request"
 )
 
+// Last use of API keys is recorded with this precision, so that
+// busy keys do not cost a write per request
+const apiKeyUsePrecision = 1 * time.Minute
+
+type Storage interface {
+	storage.UserProvider
+	storage.SessionStorage
+	storage.APIKeyStorage
+}
+
 // TODO?: Refactor token (re)issuing
-func New(log *slog.Logger, a *jwt.JWTService, s *storage.Storage) func(next http.Handler) http.Handler {
+func New(log *slog.Logger, a *jwt.JWTService, s Storage) func(next http.Handler) http.Handler {
 	log.Info("jwt middleware enabled")
 	log = log.With(logger.Component("middleware/jwt"))
 
@@ -26,6 +40,26 @@ func New(log *slog.Logger, a *jwt.JWTService, s *storage.Storage) func(next http
 				logger.RequestID(reqMW.GetID(r.Context())),
 			)
 
+			// API keys stand in for access tokens
+			if key, err := apikey.FromRequest(r); !errors.Is(err, apikey.ErrKeyMissing) {
+				if err == nil {
+					var claims *jwt.Claims
+					if claims, err = apiKeyClaims(log, a, s, key); err == nil {
+						ctx := context.WithValue(r.Context(), ctxlib.UserKey, claims)
+						next.ServeHTTP(w, r.WithContext(ctx))
+						return
+					}
+				}
+				if errors.Is(err, jwt.ErrTokenInvalid) {
+					log.Warn("cannot validate api key", logger.Error(err))
+					http.Error(w, "Invalid token", http.StatusUnauthorized)
+					return
+				}
+				log.Error("cannot validate api key", logger.Error(err))
+				http.Error(w, "Cannot validate token", http.StatusInternalServerError)
+				return
+			}
+
 			accessToken, err := jwt.GetAccessToken(r)
 			if err != nil {
 				log.Error("cannot get access token", logger.Error(err))
@@ -45,8 +79,22 @@ func New(log *slog.Logger, a *jwt.JWTService, s *storage.Storage) func(next http
 				return
 			}
 
+			// Tokens of OAuth clients are not valid for the first-party API
+			if claims.ClientID != "" {
+				log.Warn("token is issued to a client", slog.String("client_id", claims.ClientID))
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+
+			if errors.Is(err, jwt.ErrTokenExpiredOnly) && !a.Options.ImplicitRefresh {
+				log.Info("token is expired", logger.Error(err))
+				http.Error(w, "Token is expired", http.StatusUnauthorized)
+				return
+			}
+
+			// Implicit refresh, see `POST /v1/auth/refresh` for the explicit one
 			if errors.Is(err, jwt.ErrTokenExpiredOnly) {
-				ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options.ReadTimeout)
+				ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().ReadTimeout)
 				defer cancel()
 
 				userID := claims.Subject
@@ -70,27 +118,33 @@ func New(log *slog.Logger, a *jwt.JWTService, s *storage.Storage) func(next http
 					Subject: claims.Subject,
 				}
 
-				if _, err := a.ValidateRefresh(refreshToken, opts); err != nil {
+				refreshClaims, err := a.ValidateRefresh(refreshToken, opts)
+				if err != nil {
 					log.Error("cannot validate refresh token", logger.Error(err))
 					http.Error(w, "Invalid token", http.StatusUnauthorized)
 					return
 				}
 
-				refreshToken, exp, err := a.IssueRefresh(user)
-				if err != nil {
-					log.Error("cannot issue refresh token", logger.Error(err))
-					http.Error(w, "Cannot issue token", http.StatusInternalServerError)
-					return
-				}
-				jwt.SetRefreshToken(w, refreshToken, exp)
+				ctxSession, cancelSession := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+				defer cancelSession()
 
-				accessToken, _, err = a.IssueAccess(user)
+				tokens, err := session.Rotate(ctxSession, a, s, user, refreshClaims)
 				if err != nil {
-					log.Error("cannot issue token", logger.Error(err))
+					if errors.Is(err, storage.ErrRefreshTokenReused) {
+						log.Warn("refresh token reuse detected, family revoked", logger.Error(err))
+						http.Error(w, "Invalid token", http.StatusUnauthorized)
+						return
+					}
+					if session.IsInvalid(err) {
+						log.Error("cannot rotate refresh token", logger.Error(err))
+						http.Error(w, "Invalid token", http.StatusUnauthorized)
+						return
+					}
+					log.Error("cannot issue tokens", logger.Error(err))
 					http.Error(w, "Cannot issue token", http.StatusInternalServerError)
 					return
 				}
-				jwt.SetAccessToken(w, accessToken)
+				tokens.Set(w)
 			}
 
 			ctx := context.WithValue(r.Context(), ctxlib.UserKey, claims)
@@ -100,3 +154,101 @@ func New(log *slog.Logger, a *jwt.JWTService, s *storage.Storage) func(next http
 		return http.HandlerFunc(handler)
 	}
 }
+
+// apiKeyClaims returns the claims of the active key and its owner,
+// jwt.ErrTokenInvalid means the key cannot be used.
+func apiKeyClaims(log *slog.Logger, a *jwt.JWTService, s Storage, key string) (*jwt.Claims, error) {
+	const op = "middleware.jwt.apiKeyClaims"
+
+	ctxStorage, cancel := context.WithTimeout(context.Background(), s.Options().ReadTimeout)
+	defer cancel()
+
+	k, err := s.APIKeyByHash(ctxStorage, apikey.Hash(key))
+	if err != nil {
+		if errors.Is(err, storage.ErrAPIKeyNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, jwt.ErrTokenInvalid)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	now := time.Now()
+	if !k.Active(now) {
+		return nil, fmt.Errorf("%s: key %s is revoked or expired: %w", op, k.ID, jwt.ErrTokenInvalid)
+	}
+
+	user, err := s.User(ctxStorage, k.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, jwt.ErrTokenInvalid)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if now.Sub(k.LastUsedAt) >= apiKeyUsePrecision {
+		ctxUse, cancelUse := context.WithTimeout(context.Background(), s.Options().WriteTimeout)
+		defer cancelUse()
+
+		// The request is authorized regardless
+		if err := s.UseAPIKey(ctxUse, k.ID, now); err != nil {
+			log.Error("cannot record api key use", slog.String("key_id", k.ID), logger.Error(err))
+		}
+	}
+
+	return jwt.APIKeyClaims(a.Options.Issuer, user, k), nil
+}
+
+// Client accepts only the access tokens issued to OAuth clients on behalf
+// of users, the errors are reported as in RFC 6750, section 3. Expired
+// tokens are refused, the clients refresh them with the token endpoint.
+func Client(log *slog.Logger, a *jwt.JWTService) func(next http.Handler) http.Handler {
+	log = log.With(logger.Component("middleware/jwt"))
+
+	return func(next http.Handler) http.Handler {
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			log := log.With(
+				logger.RequestID(reqMW.GetID(r.Context())),
+			)
+
+			accessToken, err := jwt.GetAccessToken(r)
+			if err != nil {
+				log.Error("cannot get access token", logger.Error(err))
+				w.Header().Set(httplib.HeaderWWWAuthenticate, `Bearer`)
+				http.Error(w, "Token is missing", http.StatusUnauthorized)
+				return
+			}
+
+			opts := jwt.ValidationOptions{
+				Issuer: a.Options.Issuer,
+				Leeway: a.Options.Leeway,
+			}
+
+			claims, err := a.ValidateAccess(accessToken, opts)
+			if err == nil && (claims.ClientID == "" || claims.Machine()) {
+				err = jwt.ErrTokenInvalid
+			}
+			if err != nil {
+				log.Error("cannot validate token", logger.Error(err))
+				w.Header().Set(httplib.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), ctxlib.UserKey, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+
+		return http.HandlerFunc(handler)
+	}
+}
+
+// GetClaims returns the access token claims placed into the context by the middleware.
+func GetClaims(ctx context.Context) *jwt.Claims {
+	if ctx == nil {
+		return nil
+	}
+	if c, ok := ctx.Value(ctxlib.UserKey).(*jwt.Claims); ok {
+		return c
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+package ratelimit
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/korikhin/auth/internal/config"
+	"github.com/korikhin/auth/internal/lib/api"
+	"github.com/korikhin/auth/internal/lib/http/codec"
+	"github.com/korikhin/auth/internal/lib/logger"
+
+	jwtMW "github.com/korikhin/auth/internal/http-server/middleware/jwt"
+	reqMW "github.com/korikhin/auth/internal/http-server/middleware/request"
+	httplib "github.com/korikhin/auth/internal/lib/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Full buckets are dropped this often, they are the same as absent ones
+const sweepInterval = time.Minute
+
+var errTooManyRequests = api.Error("too many requests")
+
+// New limits requests with token buckets, one per key as configured.
+// Subject keys require the JWT middleware to run first.
+func New(log *slog.Logger, c config.Limit) func(next http.Handler) http.Handler {
+	log = log.With(logger.Component("middleware/ratelimit"))
+
+	// The router wraps handlers on every request,
+	// so the buckets must outlive the returned closure
+	var l *limiter
+	if c.Requests > 0 && c.Period > 0 {
+		l = newLimiter(c)
+	}
+
+	return func(next http.Handler) http.Handler {
+		if l == nil {
+			return next
+		}
+
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			key := l.key(r)
+			res := l.take(key, time.Now())
+
+			h := w.Header()
+			h.Set(httplib.HeaderRateLimitLimit, strconv.Itoa(res.limit))
+			h.Set(httplib.HeaderRateLimitRemaining, strconv.Itoa(res.remaining))
+			h.Set(httplib.HeaderRateLimitReset, strconv.Itoa(seconds(res.reset)))
+
+			if !res.allowed {
+				log.Warn(
+					"rate limit exceeded",
+					slog.String("key", key),
+					logger.RequestID(reqMW.GetID(r.Context())),
+				)
+				h.Set(httplib.HeaderRetryAfter, strconv.Itoa(seconds(res.retryAfter)))
+				codec.ResponseJSON(w, errTooManyRequests, http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(handler)
+	}
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type result struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+type limiter struct {
+	by    config.RateLimitKey
+	rate  float64 // Tokens per second
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func newLimiter(c config.Limit) *limiter {
+	burst := c.Burst
+	if burst <= 0 {
+		burst = c.Requests
+	}
+
+	return &limiter{
+		by:        c.Key,
+		rate:      float64(c.Requests) / c.Period.Seconds(),
+		burst:     float64(burst),
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+func (l *limiter) key(r *http.Request) string {
+	switch l.by {
+	case config.RateLimitBySubject:
+		if c := jwtMW.GetClaims(r.Context()); c != nil {
+			return fmt.Sprintf("sub:%s", c.Subject)
+		}
+	case config.RateLimitByRoute:
+		if route := mux.CurrentRoute(r); route != nil {
+			if tpl, err := route.GetPathTemplate(); err == nil {
+				return fmt.Sprintf("route:%s %s", r.Method, tpl)
+			}
+		}
+	}
+
+	return fmt.Sprintf("ip:%s", httplib.ClientIP(r))
+}
+
+func (l *limiter) take(key string, now time.Time) result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	res := result{limit: int(l.burst)}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.allowed = true
+	} else {
+		res.retryAfter = l.duration(1 - b.tokens)
+	}
+	res.remaining = int(math.Floor(b.tokens))
+	res.reset = l.duration(l.burst - b.tokens)
+
+	return res
+}
+
+// sweep drops the buckets that have been refilled.
+func (l *limiter) sweep(now time.Time) {
+	for k, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, k)
+		}
+	}
+	l.lastSweep = now
+}
+
+// duration returns the time to refill the tokens.
+func (l *limiter) duration(tokens float64) time.Duration {
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+// seconds rounds up to whole seconds.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -16,7 +16,8 @@ func NotEmpty(log *slog.Logger) func(next http.Handler) http.Handler {
 				logger.RequestID(GetID(r.Context())),
 			)
 
-			if r.Method == http.MethodPost && (r.Body == nil || r.ContentLength == 0) {
+			hasBody := r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodPatch
+			if hasBody && (r.Body == nil || r.ContentLength == 0) {
 				log.Error("request body is empty")
 				http.Error(w, "Request body is empty", http.StatusBadRequest)
 				return
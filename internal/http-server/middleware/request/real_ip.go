@@ -0,0 +1,28 @@
+package request
+
+import (
+	"net"
+	"net/http"
+
+	httplib "github.com/korikhin/auth/internal/lib/http"
+)
+
+// RealIP replaces the remote address of requests coming through
+// the trusted proxies with the client address they forward.
+func RealIP(p httplib.Proxies) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(p) == 0 {
+			return next
+		}
+
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			if ip := p.ClientIP(r); ip != httplib.ClientIP(r) {
+				r.RemoteAddr = net.JoinHostPort(ip, "0")
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(handler)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/korikhin/auth/internal/lib/password"
+
 	"github.com/go-playground/validator/v10"
 )
 
@@ -60,13 +62,76 @@ func Error(msg string, details ...any) Response {
 	return r
 }
 
+// Tokens is the token pair as described in RFC 6749, section 5.1.
+type Tokens struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// MFAChallenge is returned by login in place of the tokens when the user
+// has the second factor enabled, MFAToken is to be posted along with the code.
+type MFAChallenge struct {
+	Status    string `json:"status"`
+	MFAToken  string `json:"mfa_token"`
+	ExpiresIn int64  `json:"expires_in"`
+}
+
 type Credentials struct {
 	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required"`
+	Password string `json:"password" validate:"required,password,notemail=Email"`
+}
+
+// Password policy tags, `password` expands to all the checks of the policy
+// and `notemail=<Field>` compares the value to the email in the sibling field.
+const (
+	tagPassword = "password"
+	tagLength   = "pwlength"
+	tagBytes    = "pwbytes"
+	tagClasses  = "pwclasses"
+	tagBanned   = "pwbanned"
+	tagNotEmail = "notemail"
+)
+
+var (
+	validate = newValidator()
+	policy   *password.Policy
+)
+
+// SetPasswordPolicy sets the policy checked by the `password` tag,
+// no policy means any password is accepted.
+func SetPasswordPolicy(p *password.Policy) {
+	policy = p
+}
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+
+	checks := map[string]func(p *password.Policy, pw string) bool{
+		tagLength:  (*password.Policy).ValidLength,
+		tagBytes:   (*password.Policy).ValidBytes,
+		tagClasses: (*password.Policy).ValidClasses,
+		tagBanned:  func(p *password.Policy, pw string) bool { return !p.Banned(pw) },
+	}
+	for tag, check := range checks {
+		_ = v.RegisterValidation(tag, func(fl validator.FieldLevel) bool {
+			return policy == nil || check(policy, fl.Field().String())
+		})
+	}
+	v.RegisterAlias(tagPassword, strings.Join([]string{tagLength, tagBytes, tagClasses, tagBanned}, ","))
+
+	_ = v.RegisterValidation(tagNotEmail, func(fl validator.FieldLevel) bool {
+		email := fl.Parent().FieldByName(fl.Param())
+		return !email.IsValid() || !password.MatchesEmail(fl.Field().String(), email.String())
+	})
+
+	return v
 }
 
-func Validate(c *Credentials) error {
-	if err := validator.New().Struct(c); err != nil {
+// Validate checks the struct against its `validate` tags.
+func Validate(v any) error {
+	if err := validate.Struct(v); err != nil {
 		errs := err.(validator.ValidationErrors)
 		return formatErrors(errs)
 	}
@@ -83,6 +148,16 @@ func formatErrors(validationErrors validator.ValidationErrors) error {
 			message = fmt.Sprintf("field %s is required", f)
 		case "email":
 			message = fmt.Sprintf("field %s is not a valid email", f)
+		case tagLength:
+			message = fmt.Sprintf("field %s must be %d to %d characters long", f, policy.MinLength, policy.MaxLength)
+		case tagBytes:
+			message = fmt.Sprintf("field %s must not exceed %d bytes", f, policy.MaxBytes)
+		case tagClasses:
+			message = fmt.Sprintf("field %s must contain %s", f, strings.Join(policy.Classes(), ", "))
+		case tagBanned:
+			message = fmt.Sprintf("field %s is too common", f)
+		case tagNotEmail:
+			message = fmt.Sprintf("field %s must not match the email", f)
 		default:
 			message = fmt.Sprintf("field %s is not valid", f)
 		}
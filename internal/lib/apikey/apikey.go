@@ -0,0 +1,63 @@
+// Package apikey implements long-lived keys for machine access. A key
+// reads `ak_<prefix>_<secret>`, it is stored as a hash and the prefix
+// is kept in the clear to tell the keys apart.
+package apikey
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	httplib "github.com/korikhin/auth/internal/lib/http"
+	"github.com/korikhin/auth/internal/lib/jwt"
+	"github.com/korikhin/auth/internal/lib/password"
+)
+
+const (
+	// Scheme of the Authorization header the keys are sent with
+	Scheme = "ApiKey"
+
+	keyPrefix  = "ak_"
+	prefixSize = 4
+	secretSize = 32
+)
+
+var ErrKeyMissing = errors.New("api key is missing")
+
+// Generate returns a new key along with its prefix and hash.
+func Generate() (key, prefix string, hash []byte, err error) {
+	var buf [prefixSize + secretSize]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", "", nil, err
+	}
+
+	prefix = keyPrefix + hex.EncodeToString(buf[:prefixSize])
+	key = prefix + "_" + base64.RawURLEncoding.EncodeToString(buf[prefixSize:])
+
+	return key, prefix, Hash(key), nil
+}
+
+// Hash returns the hash of the key as it is stored.
+func Hash(key string) []byte {
+	return password.HashToken(key)
+}
+
+// FromRequest returns the key of the `Authorization: ApiKey <key>` header,
+// ErrKeyMissing means the request is authorized otherwise, if at all.
+func FromRequest(r *http.Request) (string, error) {
+	const op = "apikey.FromRequest"
+
+	scheme, key, _ := strings.Cut(r.Header.Get(httplib.HeaderAuth), " ")
+	if !strings.EqualFold(scheme, Scheme) {
+		return "", fmt.Errorf("%s: %w", op, ErrKeyMissing)
+	}
+	if key = strings.TrimSpace(key); key == "" {
+		return "", fmt.Errorf("%s: %w", op, jwt.ErrTokenInvalid)
+	}
+
+	return key, nil
+}
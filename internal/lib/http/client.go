@@ -0,0 +1,79 @@
+package http
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Proxies are the networks of trusted reverse proxies.
+type Proxies []netip.Prefix
+
+func ParseProxies(cidrs []string) (Proxies, error) {
+	p := make(Proxies, 0, len(cidrs))
+	for _, c := range cidrs {
+		prefix, err := netip.ParsePrefix(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %w", err)
+		}
+		p = append(p, prefix.Masked())
+	}
+
+	return p, nil
+}
+
+func (p Proxies) trusted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+
+	addr = addr.Unmap()
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ClientIP returns the address the request came from. If the peer is
+// a trusted proxy, the address is taken from `X-Forwarded-For` as the
+// rightmost one not belonging to a trusted proxy.
+func (p Proxies) ClientIP(r *http.Request) string {
+	ip := ClientIP(r)
+	if !p.trusted(ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, h := range r.Header.Values(HeaderForwardedFor) {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		ip = hop
+		if !p.trusted(hop) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// ClientIP returns the address of the peer.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
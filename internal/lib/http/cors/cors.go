@@ -17,6 +17,7 @@ func New(c config.CORS) func(http.Handler) http.Handler {
 			http.MethodGet,
 			http.MethodPost,
 			http.MethodPut,
+			http.MethodPatch,
 			http.MethodDelete,
 		}),
 		handlers.AllowedHeaders([]string{
@@ -13,19 +13,29 @@ const (
 	HeaderIfModifiedSince = "If-Modified-Since"
 	HeaderKeepAlive       = "Keep-Alive"
 	HeaderOrigin          = "Origin"
+	HeaderPragma          = "Pragma"
 	HeaderRange           = "Range"
+	HeaderRetryAfter      = "Retry-After"
 	HeaderUserAgent       = "User-Agent"
+	HeaderWWWAuthenticate = "WWW-Authenticate"
 
 	HeaderCSRFToken     = "X-CSRF-Token"
 	HeaderCustomHeader  = "X-CustomHeader"
+	HeaderForwardedFor  = "X-Forwarded-For"
 	HeaderRequestedWith = "X-Requested-With"
 	HeaderRequestID     = "X-Request-ID"
 	HeaderRequiredRole  = "X-Required-Role"
+
+	// draft-ietf-httpapi-ratelimit-headers
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
 )
 
 // Content types
 const (
 	ContentTypeJSON = "application/json"
+	ContentTypeForm = "application/x-www-form-urlencoded"
 
 	// TODO: Ensure that the API complies with RFC 7807
 	ContentTypeProblemJSON = "application/problem+json"
@@ -0,0 +1,37 @@
+package jwt
+
+import (
+	"time"
+
+	"github.com/korikhin/auth/internal/domain/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// APIKeyClaims returns the claims equivalent to an access token of the user
+// for requests authorized with the key. The permissions are limited to the
+// scopes of the key the user still has, the claims are never signed.
+// The role is left out, so the key is authorized by its scopes only
+// and never passes for an admin session.
+func APIKeyClaims(issuer string, user *models.User, k *models.APIKey) *Claims {
+	permissions := make([]string, 0, len(k.Scopes))
+	for _, s := range k.Scopes {
+		if user.HasPermission(s) {
+			permissions = append(permissions, s)
+		}
+	}
+
+	c := &Claims{
+		Permissions: permissions,
+		TokenScope:  scopeAccess,
+		APIKeyID:    k.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: numericDate(k.ExpiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Subject:   user.ID,
+			Issuer:    issuer,
+		},
+	}
+
+	return c
+}
@@ -0,0 +1,29 @@
+package jwt
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/korikhin/auth/internal/domain/models"
+)
+
+func TestAPIKeyClaims(t *testing.T) {
+	user := &models.User{
+		ID:          "1",
+		Role:        models.RoleAdmin,
+		Permissions: []string{"read", "write"},
+	}
+	k := &models.APIKey{ID: "key", Scopes: []string{"read", "delete"}}
+
+	c := APIKeyClaims("issuer", user, k)
+
+	if c.UserRole != "" {
+		t.Errorf("role = %q, want none", c.UserRole)
+	}
+	if !slices.Equal(c.Permissions, []string{"read"}) {
+		t.Errorf("permissions = %v, want [read]", c.Permissions)
+	}
+	if c.Subject != user.ID || c.APIKeyID != k.ID {
+		t.Errorf("subject = %q, key = %q", c.Subject, c.APIKeyID)
+	}
+}
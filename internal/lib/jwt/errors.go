@@ -46,8 +46,11 @@ func parent(err, target error, targetComparable bool) error {
 				return p
 			}
 		case interface{ Unwrap() []error }:
-			for _, err := range x.Unwrap() {
-				if p := parent(err, target, targetComparable); p != nil {
+			for _, e := range x.Unwrap() {
+				if targetComparable && e == target {
+					return err
+				}
+				if p := parent(e, target, targetComparable); p != nil {
 					return p
 				}
 			}
@@ -0,0 +1,57 @@
+package jwt
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestExpiredOnly(t *testing.T) {
+	key := []byte("secret")
+	now := time.Now()
+
+	sign := func(c jwt.RegisteredClaims) string {
+		s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	parse := func(token string, opts ...jwt.ParserOption) error {
+		keyFunc := func(*jwt.Token) (interface{}, error) { return key, nil }
+		_, err := jwt.ParseWithClaims(token, &jwt.RegisteredClaims{}, keyFunc, opts...)
+		return err
+	}
+
+	expired := jwt.RegisteredClaims{
+		Issuer:    "issuer",
+		IssuedAt:  jwt.NewNumericDate(now.Add(-2 * time.Hour)),
+		ExpiresAt: jwt.NewNumericDate(now.Add(-time.Hour)),
+	}
+	valid := expired
+	valid.ExpiresAt = jwt.NewNumericDate(now.Add(time.Hour))
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"valid", parse(sign(valid)), false},
+		{"expired", parse(sign(expired), jwt.WithIssuer("issuer")), true},
+		{"expired and wrong issuer", parse(sign(expired), jwt.WithIssuer("other")), false},
+		{"wrong issuer", parse(sign(valid), jwt.WithIssuer("other")), false},
+		{"expired and bad signature", parse(sign(expired)[:len(sign(expired))-2] + "xx"), false},
+		{"unrelated", errors.New("unrelated"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExpiredOnly(tt.err); got != tt.want {
+				t.Errorf("ExpiredOnly(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+)
+
+// JWK is a public JSON Web Key (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+
+	// EC and OKP
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+func (k *Key) JWK() JWK {
+	j := publicJWK(k.Public)
+	j.KeyID = k.ID
+	j.Use = "sig"
+	j.Algorithm = k.Method.Alg()
+
+	return j
+}
+
+func publicJWK(pubk crypto.PublicKey) JWK {
+	switch k := pubk.(type) {
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return JWK{
+			KeyType: "EC",
+			Curve:   k.Curve.Params().Name,
+			X:       b64(k.X.FillBytes(make([]byte, size))),
+			Y:       b64(k.Y.FillBytes(make([]byte, size))),
+		}
+	case *rsa.PublicKey:
+		return JWK{
+			KeyType: "RSA",
+			N:       b64(k.N.Bytes()),
+			E:       b64(big.NewInt(int64(k.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		return JWK{
+			KeyType: "OKP",
+			Curve:   "Ed25519",
+			X:       b64(k),
+		}
+	default:
+		return JWK{}
+	}
+}
+
+// PublicKey decodes the key, ErrUnsupported means the type or the curve
+// is not one the service can verify signatures with.
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	switch j.KeyType {
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, ErrUnsupported
+		}
+		x, err := unb64(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := unb64(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		k := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(k.X, k.Y) {
+			return nil, ErrUnsupported
+		}
+		return k, nil
+	case "RSA":
+		n, err := unb64(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := unb64(j.E)
+		if err != nil {
+			return nil, err
+		}
+		if len(e) > 4 {
+			return nil, ErrUnsupported
+		}
+		k := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if k.N.BitLen() < rsaMinBits || k.E < 3 {
+			return nil, ErrUnsupported
+		}
+		return k, nil
+	case "OKP":
+		if j.Curve != "Ed25519" {
+			return nil, ErrUnsupported
+		}
+		x, err := unb64(j.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, ErrUnsupported
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, ErrUnsupported
+	}
+}
+
+// Thumbprint computes the RFC 7638 thumbprint of the public key.
+func Thumbprint(pubk crypto.PublicKey) string {
+	j := publicJWK(pubk)
+
+	// Required members only, in lexicographic order
+	var data []byte
+	switch j.KeyType {
+	case "EC":
+		data, _ = json.Marshal(struct {
+			Curve   string `json:"crv"`
+			KeyType string `json:"kty"`
+			X       string `json:"x"`
+			Y       string `json:"y"`
+		}{j.Curve, j.KeyType, j.X, j.Y})
+	case "RSA":
+		data, _ = json.Marshal(struct {
+			E       string `json:"e"`
+			KeyType string `json:"kty"`
+			N       string `json:"n"`
+		}{j.E, j.KeyType, j.N})
+	case "OKP":
+		data, _ = json.Marshal(struct {
+			Curve   string `json:"crv"`
+			KeyType string `json:"kty"`
+			X       string `json:"x"`
+		}{j.Curve, j.KeyType, j.X})
+	}
+
+	sum := sha256.Sum256(data)
+	return b64(sum[:])
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func unb64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
@@ -4,8 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"slices"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/korikhin/auth/internal/config"
@@ -16,8 +18,24 @@ import (
 
 const (
 	refreshTokenCookie = "_example.com.rt"
-	scopeAccess        = ">"
-	scopeRefresh       = "*"
+	// refreshTokenPath covers every API route, whichever one sets the cookie
+	refreshTokenPath = "/api"
+	scopeAccess      = ">"
+	scopeRefresh     = "*"
+	scopeVerify      = "@"
+	scopeMFA         = "!"
+)
+
+// Authentication methods (RFC 8176)
+const (
+	AMRPassword = "pwd"
+	AMROTP      = "otp"
+	AMRMFA      = "mfa"
+	// Passkeys
+	AMRHardwareKey  = "hwk"
+	AMRUserPresence = "user"
+	// Login with an external identity provider, not registered in RFC 8176
+	AMRFederated = "fed"
 )
 
 var (
@@ -32,20 +50,71 @@ type Claims struct {
 	jwt.RegisteredClaims
 
 	// UserID     uint64 `json:"uid"`
-	// UserRole   string `json:"rol"`
-	TokenScope string `json:"scp"`
+	UserRole    string   `json:"rol,omitempty"`
+	Permissions []string `json:"prm,omitempty"`
+	TokenScope  string   `json:"scp"`
+
+	// Family groups tokens issued within the same login session
+	Family string `json:"fam,omitempty"`
+
+	// Email is the address being verified, in verification tokens only
+	Email string `json:"eml,omitempty"`
+
+	// AMR lists the methods the session was authenticated with
+	AMR []string `json:"amr,omitempty"`
+
+	// AuthTime is when the session was authenticated, it is carried over on rotation
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+
+	// ClientID and Scope are set in tokens issued to OAuth clients
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+
+	// APIKeyID is set when the request is authorized with an API key
+	APIKeyID string `json:"-"`
+}
+
+// Grant is the authorization of an OAuth client the tokens are issued to,
+// zero for first-party sessions.
+type Grant struct {
+	ClientID string
+	Scope    string
+}
+
+// Grant returns the authorization the token is issued under.
+func (c Claims) Grant() Grant {
+	return Grant{ClientID: c.ClientID, Scope: c.Scope}
+}
+
+// Machine reports whether the token is issued to the client itself
+// rather than on behalf of a user.
+func (c Claims) Machine() bool {
+	return c.ClientID != "" && c.Subject == c.ClientID
+}
+
+// AuthenticatedAt returns the time the session was authenticated,
+// zero for tokens issued before it was recorded.
+func (c Claims) AuthenticatedAt() time.Time {
+	if c.AuthTime == nil {
+		return time.Time{}
+	}
+
+	return c.AuthTime.Time
+}
+
+// HasMFA reports whether the session passed the second factor.
+func (c Claims) HasMFA() bool {
+	return slices.Contains(c.AMR, AMRMFA)
 }
 
 // Check required claims
 func (c Claims) Validate() error {
-	if c.TokenScope != scopeAccess && c.TokenScope != scopeRefresh {
+	switch c.TokenScope {
+	case scopeAccess, scopeRefresh, scopeVerify, scopeMFA:
+	default:
 		return ErrTokenInvalidScope
 	}
 
-	// if c.UserRole == "" {
-	// 	return jwt.ErrTokenRequiredClaimMissing
-	// }
-
 	if c.Issuer == "" {
 		return jwt.ErrTokenRequiredClaimMissing
 	}
@@ -55,7 +124,7 @@ func (c Claims) Validate() error {
 	}
 
 	// NOTE: The type of Subject may change to string
-	if _, err := strconv.ParseUint(c.Subject, 10, 64); err != nil {
+	if _, err := strconv.ParseUint(c.Subject, 10, 64); err != nil && !c.Machine() {
 		return jwt.ErrTokenInvalidSubject
 	}
 
@@ -92,8 +161,7 @@ func (opts *ValidationOptions) WithOptions() []jwt.ParserOption {
 }
 
 type JWTService struct {
-	pk      interface{}
-	pubk    interface{}
+	keys    atomic.Pointer[Keyring]
 	once    sync.Once
 	Options config.JWT
 }
@@ -103,32 +171,83 @@ func NewService(c config.JWT) *JWTService {
 }
 
 // Lazy load
-func (a *JWTService) loadKeys() {
+func (a *JWTService) loadKeys() *Keyring {
 	const fatalMsg = "failed to initialize key management: please check system configuration"
 
 	a.once.Do(func() {
-		pk, err := getPrivateKey()
-		if err != nil {
-			log.Fatal(fatalMsg)
+		if a.keys.Load() != nil {
+			return
 		}
-		a.pk = pk
-
-		pubk, err := getPublicKey()
-		if err != nil {
+		if err := a.Reload(); err != nil {
 			log.Fatal(fatalMsg)
 		}
-		a.pubk = pubk
 	})
+
+	return a.keys.Load()
+}
+
+// Reload reads the keyring again and swaps it in atomically.
+// On failure the current keyring is kept.
+func (a *JWTService) Reload() error {
+	kr, err := loadKeyring(a.Options)
+	if err != nil {
+		return err
+	}
+	a.keys.Store(kr)
+
+	return nil
+}
+
+// JWKS returns the public keys for verifying tokens issued by the service.
+func (a *JWTService) JWKS() JWKSet {
+	keys := a.loadKeys().Keys()
+
+	set := JWKSet{Keys: make([]JWK, 0, len(keys))}
+	for _, k := range keys {
+		set.Keys = append(set.Keys, k.JWK())
+	}
+
+	return set
+}
+
+func (a *JWTService) keyFunc(kr *Keyring) jwt.Keyfunc {
+	return func(t *jwt.Token) (interface{}, error) {
+		kid, ok := t.Header["kid"]
+		if !ok {
+			// Tokens issued before key IDs were introduced have no `kid`,
+			// they are accepted only while there is a single key
+			if len(kr.keys) != 1 {
+				return nil, ErrKeyIDMissing
+			}
+			return publicKey(t, kr.Active())
+		}
+
+		s, _ := kid.(string)
+		k, ok := kr.Key(s)
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+
+		return publicKey(t, k)
+	}
+}
+
+// publicKey returns the public key of k if the token is signed with its algorithm.
+func publicKey(t *jwt.Token, k *Key) (interface{}, error) {
+	if t.Method.Alg() != k.Method.Alg() {
+		return nil, jwt.ErrTokenSignatureInvalid
+	}
+
+	return k.Public, nil
 }
 
 func (a *JWTService) validate(token, scope string, opts ValidationOptions) (*Claims, error) {
 	const op = "jwt.Validate"
 
-	a.loadKeys()
+	kr := a.loadKeys()
 
-	t, err := jwt.ParseWithClaims(token, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return a.pubk, nil
-	}, opts.WithOptions()...)
+	popts := append(opts.WithOptions(), jwt.WithValidMethods(kr.Algorithms()))
+	t, err := jwt.ParseWithClaims(token, &Claims{}, a.keyFunc(kr), popts...)
 
 	var isExpiredOnly bool
 	if err != nil {
@@ -145,6 +264,16 @@ func (a *JWTService) validate(token, scope string, opts ValidationOptions) (*Cla
 	if c.TokenScope != scope {
 		return nil, fmt.Errorf("%s: %w", op, ErrTokenInvalidScope)
 	}
+	if scope == scopeRefresh && (c.ID == "" || c.Family == "") {
+		return nil, fmt.Errorf("%s: %w", op, ErrTokenInvalid)
+	}
+	if scope == scopeVerify && c.Email == "" {
+		return nil, fmt.Errorf("%s: %w", op, ErrTokenInvalid)
+	}
+	// Tokens issued before roles were introduced have no `rol`
+	if scope == scopeAccess && c.UserRole == "" && !c.Machine() {
+		c.UserRole = models.RoleUser
+	}
 	if isExpiredOnly {
 		return c, ErrTokenExpiredOnly
 	}
@@ -160,10 +289,18 @@ func (a *JWTService) ValidateRefresh(token string, opts ValidationOptions) (*Cla
 	return a.validate(token, scopeRefresh, opts)
 }
 
-func (a *JWTService) issue(user models.User, scope string) (string, time.Time, error) {
+func (a *JWTService) ValidateVerification(token string, opts ValidationOptions) (*Claims, error) {
+	return a.validate(token, scopeVerify, opts)
+}
+
+func (a *JWTService) ValidateMFAChallenge(token string, opts ValidationOptions) (*Claims, error) {
+	return a.validate(token, scopeMFA, opts)
+}
+
+func (a *JWTService) issue(user models.User, scope, family string, amr []string, authTime time.Time, g Grant) (string, *Claims, error) {
 	const op = "jwt.Issue"
 
-	a.loadKeys()
+	k := a.loadKeys().Active()
 
 	var ttl time.Duration
 	switch scope {
@@ -171,15 +308,23 @@ func (a *JWTService) issue(user models.User, scope string) (string, time.Time, e
 		ttl = a.Options.AccessTTL
 	case scopeRefresh:
 		ttl = a.Options.RefreshTTL
+	case scopeVerify:
+		ttl = a.Options.VerifyTTL
+	case scopeMFA:
+		ttl = a.Options.MFATTL
 	default:
-		return "", time.Time{}, fmt.Errorf("%s: %w", op, ErrTokenInvalidScope)
+		return "", nil, fmt.Errorf("%s: %w", op, ErrTokenInvalidScope)
 	}
 
 	exp := time.Now().Add(ttl)
 	c := &Claims{
 		// UserID:     user.ID,
-		// UserRole:   user.Role,
 		TokenScope: scope,
+		Family:     family,
+		AMR:        amr,
+		ClientID:   g.ClientID,
+		Scope:      g.Scope,
+		AuthTime:   numericDate(authTime),
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(exp),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -188,19 +333,93 @@ func (a *JWTService) issue(user models.User, scope string) (string, time.Time, e
 		},
 	}
 
-	t := jwt.NewWithClaims(jwt.SigningMethodES256, c)
-	s, err := t.SignedString(a.pk)
+	// Authorization data goes to access tokens only, refresh tokens
+	// pick up the current one from storage on rotation
+	if scope == scopeAccess {
+		c.UserRole = user.Role
+		c.Permissions = user.Permissions
+	}
+
+	if scope == scopeVerify {
+		c.Email = user.Email
+	}
+
+	// Client tokens are meant for the client only
+	if scope == scopeAccess && g.ClientID != "" {
+		c.Audience = jwt.ClaimStrings{g.ClientID}
+	}
+
+	if scope == scopeRefresh {
+		id, err := NewID()
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: %w", op, err)
+		}
+		c.ID = id
+	}
+
+	t := jwt.NewWithClaims(k.Method, c)
+	t.Header["kid"] = k.ID
+	s, err := t.SignedString(k.Private)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return s, c, nil
+}
+
+// IssueAccess issues an access token bound to the refresh token family,
+// so that revoking the session is visible on introspection.
+func (a *JWTService) IssueAccess(user *models.User, family string, amr []string, authTime time.Time, g Grant) (string, time.Time, error) {
+	s, c, err := a.issue(*user, scopeAccess, family, amr, authTime, g)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return s, c.ExpiresAt.Time, nil
+}
+
+// IssueRefresh issues a refresh token with a unique ID (jti) within
+// the given family. An empty family starts a new one. The authentication
+// methods, the time and the grant are carried over to the tokens issued
+// on rotation.
+func (a *JWTService) IssueRefresh(user *models.User, family string, amr []string, authTime time.Time, g Grant) (string, *Claims, error) {
+	if family == "" {
+		id, err := NewID()
+		if err != nil {
+			return "", nil, fmt.Errorf("jwt.IssueRefresh: %w", err)
+		}
+		family = id
+	}
+
+	return a.issue(*user, scopeRefresh, family, amr, authTime, g)
+}
+
+// IssueClientAccess issues an access token to the client acting on its
+// own behalf (client credentials grant), it is not bound to any session.
+func (a *JWTService) IssueClientAccess(clientID, scope string) (string, time.Time, error) {
+	s, c, err := a.issue(models.User{ID: clientID}, scopeAccess, "", nil, time.Time{}, Grant{ClientID: clientID, Scope: scope})
 	if err != nil {
-		return "", time.Time{}, fmt.Errorf("%s: %w", op, err)
+		return "", time.Time{}, err
 	}
 
-	return s, exp, nil
+	return s, c.ExpiresAt.Time, nil
 }
 
-func (a *JWTService) IssueAccess(user *models.User) (string, time.Time, error) {
-	return a.issue(*user, scopeAccess)
+// IssueVerification issues a token proving the ownership of the current
+// email of the user. It is void once the email changes.
+func (a *JWTService) IssueVerification(user *models.User) (string, error) {
+	s, _, err := a.issue(*user, scopeVerify, "", nil, time.Time{}, Grant{})
+	return s, err
 }
 
-func (a *JWTService) IssueRefresh(user *models.User) (string, time.Time, error) {
-	return a.issue(*user, scopeRefresh)
+// IssueMFAChallenge issues a token proving the user has passed
+// the first factor with the given methods, to be exchanged for a session
+// with the second one.
+func (a *JWTService) IssueMFAChallenge(user *models.User, amr []string) (string, time.Time, error) {
+	s, c, err := a.issue(*user, scopeMFA, "", amr, time.Time{}, Grant{})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return s, c.ExpiresAt.Time, nil
 }
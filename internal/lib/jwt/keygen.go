@@ -0,0 +1,104 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+// GenerateKey creates a private key suitable for the algorithm.
+func GenerateKey(alg string) (crypto.Signer, error) {
+	switch alg {
+	case "ES256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ES384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ES512":
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case "RS256", "PS256":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case "RS384", "PS384":
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case "RS512", "PS512":
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case "EdDSA":
+		_, pk, err := ed25519.GenerateKey(rand.Reader)
+		return pk, err
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, alg)
+	}
+}
+
+// EncodePrivateKey encodes the key to PEM using the block types
+// the keyring loader expects: `EC PRIVATE KEY` for ECDSA,
+// `RSA PRIVATE KEY` for RSA and `PRIVATE KEY` (PKCS #8) for Ed25519.
+func EncodePrivateKey(pk crypto.Signer) ([]byte, error) {
+	block := &pem.Block{}
+
+	var err error
+	switch k := pk.(type) {
+	case *ecdsa.PrivateKey:
+		block.Type = keyTypePrivate
+		block.Bytes, err = x509.MarshalECPrivateKey(k)
+	case *rsa.PrivateKey:
+		block.Type = keyTypePrivateRSA
+		block.Bytes = x509.MarshalPKCS1PrivateKey(k)
+	case ed25519.PrivateKey:
+		block.Type = keyTypePrivatePKCS8
+		block.Bytes, err = x509.MarshalPKCS8PrivateKey(k)
+	default:
+		return nil, fmt.Errorf("%w: key type %T", ErrUnsupported, pk)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(block), nil
+}
+
+// EncodePublicKey encodes the key to a PKIX `PUBLIC KEY` PEM block.
+func EncodePublicKey(pubk crypto.PublicKey) ([]byte, error) {
+	data, err := x509.MarshalPKIXPublicKey(pubk)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: keyTypePublic, Bytes: data}), nil
+}
+
+// ParseKey reads a private or public PEM encoded key.
+// The key ID is set to the thumbprint of the key.
+func ParseKey(data []byte, alg string) (*Key, error) {
+	if pk, err := parsePrivateKey(data); err == nil {
+		return newKey("", alg, pk, pk.Public())
+	}
+
+	pubk, err := parsePublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse key: neither private nor public key")
+	}
+
+	return newKey("", alg, nil, pubk)
+}
+
+// ReadKeysDir reads all keys from the keys directory,
+// regardless of whether any of them can be used for signing.
+func ReadKeysDir(dir, alg string) ([]*Key, error) {
+	kr := &Keyring{keys: make(map[string]*Key)}
+	if _, err := kr.loadDir(dir, alg); err != nil {
+		return nil, err
+	}
+
+	return kr.Keys(), nil
+}
+
+// FileNames returns the private and public key file names for the key ID.
+func FileNames(kid string) (string, string) {
+	return kid + privateKeySuffix, kid + publicKeySuffix
+}
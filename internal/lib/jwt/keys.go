@@ -1,65 +1,387 @@
 package jwt
 
 import (
+	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/korikhin/auth/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
 )
 
-// Expected key paths
-// Prefix `/etc` added for Render.com deployment
+// Expected key file names inside the keys directory:
+//
+//	<kid>.PRIVATE.pem  private key, may be used for signing
+//	<kid>.PUBLIC.pem   public key, verification only (e.g. a retired key)
+//
+// An empty kid (i.e. the legacy `.PRIVATE.pem` and `.PUBLIC.pem` pair)
+// is replaced with the RFC 7638 thumbprint of the key.
 const (
-	privateKeyPath = "/etc/secrets/.PRIVATE.pem"
-	publicKeyPath  = "/etc/secrets/.PUBLIC.pem"
+	privateKeySuffix = ".PRIVATE.pem"
+	publicKeySuffix  = ".PUBLIC.pem"
 )
 
 // PEM block types
 const (
-	keyTypePrivate = "EC PRIVATE KEY"
-	keyTypePublic  = "PUBLIC KEY"
+	keyTypePrivate      = "EC PRIVATE KEY"
+	keyTypePrivateRSA   = "RSA PRIVATE KEY"
+	keyTypePrivatePKCS8 = "PRIVATE KEY"
+	keyTypePublic       = "PUBLIC KEY"
+	keyTypePublicRSA    = "RSA PUBLIC KEY"
+)
+
+// Shorter RSA keys are rejected
+const rsaMinBits = 2048
+
+var (
+	ErrNoSigningKey  = errors.New("no signing key")
+	ErrUnknownKey    = errors.New("unknown key")
+	ErrKeyIDMissing  = errors.New("key id is missing, several keys are in use")
+	ErrAmbiguousKeys = errors.New("several signing keys found, active key must be set")
+	ErrUnsupported   = errors.New("unsupported key or algorithm")
 )
 
-func getPrivateKey() (*ecdsa.PrivateKey, error) {
-	data, err := os.ReadFile(privateKeyPath)
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer // nil for verification-only keys
+	Public  crypto.PublicKey
+}
+
+// Keyring is an immutable set of keys, one of which is used for signing.
+type Keyring struct {
+	keys   map[string]*Key
+	active *Key
+}
+
+func (kr *Keyring) Active() *Key {
+	return kr.active
+}
+
+func (kr *Keyring) Key(kid string) (*Key, bool) {
+	k, ok := kr.keys[kid]
+	return k, ok
+}
+
+// Keys returns all keys ordered by ID.
+func (kr *Keyring) Keys() []*Key {
+	keys := make([]*Key, 0, len(kr.keys))
+	for _, k := range kr.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	return keys
+}
+
+// Algorithms returns the distinct algorithms of the keys, the only ones
+// accepted on validation.
+func (kr *Keyring) Algorithms() []string {
+	seen := make(map[string]bool)
+	var algs []string
+	for _, k := range kr.Keys() {
+		if alg := k.Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+
+	return algs
+}
+
+// loadKeyring collects keys from the keys directory and the explicitly
+// configured private key (a file or an inline value), the latter being
+// active unless another active key is set.
+func loadKeyring(c config.JWT) (*Keyring, error) {
+	const op = "jwt.loadKeyring"
+
+	kr := &Keyring{keys: make(map[string]*Key)}
+
+	explicit, err := explicitKey(c)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var signers []*Key
+	if c.KeysDir != "" {
+		signers, err = kr.loadDir(c.KeysDir, c.Algorithm)
+		// The directory is optional once a key is given explicitly
+		if err != nil && !(explicit != nil && errors.Is(err, os.ErrNotExist)) {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	activeKID := c.ActiveKey
+	if explicit != nil {
+		kr.keys[explicit.ID] = explicit
+		if activeKID == "" {
+			activeKID = explicit.ID
+		}
+	}
+
+	switch {
+	case activeKID != "":
+		k, ok := kr.keys[activeKID]
+		if !ok || k.Private == nil {
+			return nil, fmt.Errorf("%s: %w: %s", op, ErrNoSigningKey, activeKID)
+		}
+		kr.active = k
+	case len(signers) == 1:
+		kr.active = signers[0]
+	case len(signers) == 0:
+		return nil, fmt.Errorf("%s: %w", op, ErrNoSigningKey)
+	default:
+		return nil, fmt.Errorf("%s: %w", op, ErrAmbiguousKeys)
+	}
+
+	// The configured algorithm is a hard requirement for the signing key only
+	if c.Algorithm != "" && kr.active.Method.Alg() != c.Algorithm {
+		return nil, fmt.Errorf("%s: %w: %s key cannot sign %s", op, ErrUnsupported, kr.active.ID, c.Algorithm)
+	}
+
+	return kr, nil
+}
+
+func (kr *Keyring) loadDir(dir, alg string) ([]*Key, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read keys directory: %w", err)
+	}
+
+	// Private keys go first, so that public files of the same kid are skipped
+	sort.Slice(entries, func(i, j int) bool {
+		pi := strings.HasSuffix(entries[i].Name(), privateKeySuffix)
+		pj := strings.HasSuffix(entries[j].Name(), privateKeySuffix)
+		if pi != pj {
+			return pi
+		}
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	var signers []*Key
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			continue
+		}
+
+		var k *Key
+		var err error
+		switch {
+		case strings.HasSuffix(name, privateKeySuffix):
+			var pk crypto.Signer
+			if pk, err = getPrivateKey(filepath.Join(dir, name)); err == nil {
+				k, err = newKey(strings.TrimSuffix(name, privateKeySuffix), alg, pk, pk.Public())
+				signers = append(signers, k)
+			}
+		case strings.HasSuffix(name, publicKeySuffix):
+			var pubk crypto.PublicKey
+			if pubk, err = getPublicKey(filepath.Join(dir, name)); err == nil {
+				k, err = newKey(strings.TrimSuffix(name, publicKeySuffix), alg, nil, pubk)
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		if _, ok := kr.keys[k.ID]; !ok {
+			kr.keys[k.ID] = k
+		}
+	}
+
+	return signers, nil
+}
+
+func explicitKey(c config.JWT) (*Key, error) {
+	var data []byte
+	switch {
+	case c.PrivateKey != "" && c.PrivateKeyFile != "":
+		return nil, fmt.Errorf("private key and private key file are mutually exclusive")
+	case c.PrivateKey != "":
+		var err error
+		if data, err = decodeKeyValue(c.PrivateKey); err != nil {
+			return nil, err
+		}
+	case c.PrivateKeyFile != "":
+		var err error
+		if data, err = os.ReadFile(c.PrivateKeyFile); err != nil {
+			return nil, fmt.Errorf("cannot read private key file")
+		}
+	default:
+		return nil, nil
+	}
+
+	pk, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return newKey(c.KeyID, c.Algorithm, pk, pk.Public())
+}
+
+// decodeKeyValue accepts a PEM encoded key either as is
+// or additionally encoded with base64, which is easier to pass via env.
+func decodeKeyValue(v string) ([]byte, error) {
+	v = strings.TrimSpace(v)
+	if strings.HasPrefix(v, "-----BEGIN") {
+		return []byte(v), nil
+	}
+
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if data, err := enc.DecodeString(v); err == nil {
+			return data, nil
+		}
+	}
+
+	return nil, fmt.Errorf("key value is neither PEM nor base64 encoded PEM")
+}
+
+func newKey(kid, alg string, pk crypto.Signer, pubk crypto.PublicKey) (*Key, error) {
+	m, err := signingMethod(pubk, alg)
+	if err != nil {
+		return nil, err
+	}
+	if kid == "" {
+		kid = Thumbprint(pubk)
+	}
+
+	return &Key{
+		ID:      kid,
+		Method:  m,
+		Private: pk,
+		Public:  pubk,
+	}, nil
+}
+
+// signingMethod picks the method matching the key type. The preferred
+// algorithm is used when the key supports it, which matters for RSA keys
+// usable with both PKCS #1 v1.5 and PSS.
+func signingMethod(pubk crypto.PublicKey, alg string) (jwt.SigningMethod, error) {
+	var m jwt.SigningMethod
+	var allowed []jwt.SigningMethod
+
+	switch k := pubk.(type) {
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			m = jwt.SigningMethodES256
+		case elliptic.P384():
+			m = jwt.SigningMethodES384
+		case elliptic.P521():
+			m = jwt.SigningMethodES512
+		default:
+			return nil, fmt.Errorf("%w: curve %s", ErrUnsupported, k.Curve.Params().Name)
+		}
+	case *rsa.PublicKey:
+		if k.N.BitLen() < rsaMinBits {
+			return nil, fmt.Errorf("%w: RSA key shorter than %d bits", ErrUnsupported, rsaMinBits)
+		}
+		m = jwt.SigningMethodRS256
+		allowed = []jwt.SigningMethod{
+			jwt.SigningMethodRS256, jwt.SigningMethodRS384, jwt.SigningMethodRS512,
+			jwt.SigningMethodPS256, jwt.SigningMethodPS384, jwt.SigningMethodPS512,
+		}
+	case ed25519.PublicKey:
+		m = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("%w: key type %T", ErrUnsupported, pubk)
+	}
+
+	for _, a := range allowed {
+		if a.Alg() == alg {
+			return a, nil
+		}
+	}
+
+	return m, nil
+}
+
+func getPrivateKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("cannot read private key file")
 	}
 
+	return parsePrivateKey(data)
+}
+
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
 	block, _ := pem.Decode(data)
-	if block == nil || block.Type != keyTypePrivate {
+	if block == nil {
 		return nil, fmt.Errorf("cannot decode PEM block containing private key")
 	}
 
-	key, err := x509.ParseECPrivateKey(block.Bytes)
+	var key any
+	var err error
+	switch block.Type {
+	case keyTypePrivate:
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case keyTypePrivateRSA:
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case keyTypePrivatePKCS8:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("cannot decode PEM block containing private key")
+	}
 	if err != nil {
 		return nil, fmt.Errorf("cannot parse private key")
 	}
 
-	return key, nil
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey, *rsa.PrivateKey, ed25519.PrivateKey:
+		return k.(crypto.Signer), nil
+	default:
+		return nil, fmt.Errorf("private key is of the wrong type")
+	}
 }
 
-func getPublicKey() (*ecdsa.PublicKey, error) {
-	data, err := os.ReadFile(publicKeyPath)
+func getPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("cannot read public key file")
 	}
 
+	return parsePublicKey(data)
+}
+
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
 	block, _ := pem.Decode(data)
-	if block == nil || block.Type != keyTypePublic {
+	if block == nil {
 		return nil, fmt.Errorf("cannot decode PEM block containing public key")
 	}
 
-	ukey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	var key any
+	var err error
+	switch block.Type {
+	case keyTypePublic:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case keyTypePublicRSA:
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("cannot decode PEM block containing public key")
+	}
 	if err != nil {
 		return nil, fmt.Errorf("cannot parse public key")
 	}
 
-	key, ok := ukey.(*ecdsa.PublicKey)
-	if !ok {
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
 		return nil, fmt.Errorf("public key is of the wrong type")
 	}
-
-	return key, nil
 }
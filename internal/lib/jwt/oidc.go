@@ -0,0 +1,113 @@
+package jwt
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/korikhin/auth/internal/domain/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Scopes of OpenID Connect, each releases a set of user claims
+const (
+	ScopeOpenID = "openid"
+	ScopeEmail  = "email"
+)
+
+// Scopes lists the OpenID Connect scopes supported by the service.
+var Scopes = []string{ScopeOpenID, ScopeEmail}
+
+// UserClaims are the claims about the user released to OAuth clients
+// according to the granted scope.
+type UserClaims struct {
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+}
+
+// NewUserClaims returns the claims about the user the scope allows
+// to release (OpenID Connect Core, section 5.4).
+func NewUserClaims(user *models.User, scope string) UserClaims {
+	var c UserClaims
+	if HasScope(scope, ScopeEmail) {
+		verified := user.EmailVerified
+		c.Email = user.Email
+		c.EmailVerified = &verified
+	}
+
+	return c
+}
+
+// HasScope reports whether the space-delimited scope contains s.
+func HasScope(scope, s string) bool {
+	return slices.Contains(strings.Fields(scope), s)
+}
+
+// IDClaims are the claims of an ID token (OpenID Connect Core, section 2).
+// ID tokens have no `scp` and are never accepted as access tokens.
+type IDClaims struct {
+	jwt.RegisteredClaims
+	UserClaims
+
+	Nonce    string           `json:"nonce,omitempty"`
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	AMR      []string         `json:"amr,omitempty"`
+	// AuthorizedParty is the client the token is issued to
+	AuthorizedParty string `json:"azp,omitempty"`
+}
+
+// IDToken describes the authentication an ID token is issued for.
+type IDToken struct {
+	Nonce    string
+	AuthTime time.Time
+	AMR      []string
+}
+
+// IssueID issues an ID token for the client the user has authorized,
+// with the user claims allowed by the scope of the grant.
+func (a *JWTService) IssueID(user *models.User, g Grant, t IDToken) (string, error) {
+	const op = "jwt.IssueID"
+
+	k := a.loadKeys().Active()
+
+	now := time.Now()
+	c := &IDClaims{
+		UserClaims:      NewUserClaims(user, g.Scope),
+		Nonce:           t.Nonce,
+		AuthTime:        numericDate(t.AuthTime),
+		AMR:             t.AMR,
+		AuthorizedParty: g.ClientID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(a.Options.AccessTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Subject:   user.ID,
+			Issuer:    a.Options.Issuer,
+			Audience:  jwt.ClaimStrings{g.ClientID},
+		},
+	}
+
+	tok := jwt.NewWithClaims(k.Method, c)
+	tok.Header["kid"] = k.ID
+	s, err := tok.SignedString(k.Private)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return s, nil
+}
+
+// Algorithms returns the algorithms tokens are signed with.
+func (a *JWTService) Algorithms() []string {
+	return a.loadKeys().Algorithms()
+}
+
+// numericDate returns nil for the zero time, so that the claim is omitted.
+func numericDate(t time.Time) *jwt.NumericDate {
+	if t.IsZero() {
+		return nil
+	}
+
+	return jwt.NewNumericDate(t)
+}
@@ -1,6 +1,8 @@
 package jwt
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
 	"net/http"
 	"strings"
@@ -9,6 +11,16 @@ import (
 	httplib "github.com/korikhin/auth/internal/lib/http"
 )
 
+// NewID returns a random URL-safe identifier suitable for `jti` claims.
+func NewID() (string, error) {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf[:]), nil
+}
+
 func GetAccessToken(r *http.Request) (string, error) {
 	const op = "jwt.GetAccessToken"
 
@@ -46,6 +58,7 @@ func SetRefreshToken(w http.ResponseWriter, token string, exp time.Time) {
 	c := &http.Cookie{
 		Name:     refreshTokenCookie,
 		Value:    token,
+		Path:     refreshTokenPath,
 		HttpOnly: true,
 		Secure:   true,
 		SameSite: http.SameSiteStrictMode,
@@ -54,3 +67,18 @@ func SetRefreshToken(w http.ResponseWriter, token string, exp time.Time) {
 
 	http.SetCookie(w, c)
 }
+
+// ClearRefreshToken asks the client to drop the refresh token cookie.
+func ClearRefreshToken(w http.ResponseWriter) {
+	c := &http.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    "",
+		Path:     refreshTokenPath,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   -1,
+	}
+
+	http.SetCookie(w, c)
+}
@@ -0,0 +1,164 @@
+package lockout
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/korikhin/auth/internal/config"
+	"github.com/korikhin/auth/internal/lib/api"
+	httplib "github.com/korikhin/auth/internal/lib/http"
+	"github.com/korikhin/auth/internal/lib/http/codec"
+	"github.com/korikhin/auth/internal/storage"
+)
+
+type Scope string
+
+const (
+	ScopeAccount Scope = "account"
+	ScopeIP      Scope = "ip"
+)
+
+// Lock is an active lock of logins within the scope.
+type Lock struct {
+	Scope Scope
+	Until time.Time
+}
+
+// RetryAfter returns the time left in whole seconds, at least one.
+func (l *Lock) RetryAfter() int {
+	s := int((time.Until(l.Until) + time.Second - 1) / time.Second)
+	return max(s, 1)
+}
+
+// Write responds with `423` for locked accounts and `429`
+// for locked client addresses, along with `Retry-After`.
+func (l *Lock) Write(w http.ResponseWriter) {
+	w.Header().Set(httplib.HeaderRetryAfter, strconv.Itoa(l.RetryAfter()))
+
+	if l.Scope == ScopeAccount {
+		codec.ResponseJSON(w, api.Error("account is temporarily locked"), http.StatusLocked)
+		return
+	}
+	codec.ResponseJSON(w, api.Error("too many failed attempts"), http.StatusTooManyRequests)
+}
+
+// Guard counts failed logins and locks the accounts and client
+// addresses with too many of them.
+type Guard struct {
+	c config.Lockout
+	s storage.LoginAttemptStorage
+}
+
+func New(c config.Lockout, s storage.LoginAttemptStorage) *Guard {
+	return &Guard{c: c, s: s}
+}
+
+// Check returns the lock in effect for the account or the address, if any.
+func (g *Guard) Check(email, ip string) (*Lock, error) {
+	const op = "lockout.Check"
+
+	if !g.c.Enabled {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), g.s.Options().ReadTimeout)
+	defer cancel()
+
+	for _, k := range []struct {
+		scope Scope
+		key   string
+	}{
+		{ScopeIP, ipKey(ip)},
+		{ScopeAccount, accountKey(email)},
+	} {
+		until, err := g.s.LoginLockedUntil(ctx, k.key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if !until.IsZero() {
+			return &Lock{Scope: k.scope, Until: until}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Fail counts the failure for both the account and the address.
+// It returns the locks the failure has caused.
+func (g *Guard) Fail(email, ip string) ([]Lock, error) {
+	const op = "lockout.Fail"
+
+	if !g.c.Enabled {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), g.s.Options().WriteTimeout)
+	defer cancel()
+
+	var locks []Lock
+	for _, k := range []struct {
+		scope     Scope
+		key       string
+		threshold int
+	}{
+		{ScopeIP, ipKey(ip), g.c.IPThreshold},
+		{ScopeAccount, accountKey(email), g.c.AccountThreshold},
+	} {
+		failures, err := g.s.AddLoginFailure(ctx, k.key, g.c.Window)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if failures < k.threshold {
+			continue
+		}
+
+		until := time.Now().Add(g.delay(failures - k.threshold))
+		if err := g.s.LockLogin(ctx, k.key, until); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		locks = append(locks, Lock{Scope: k.scope, Until: until})
+	}
+
+	return locks, nil
+}
+
+// Succeed clears the failures of the account. Failures of the address
+// are kept, otherwise a valid account would let the client go on guessing.
+func (g *Guard) Succeed(email string) error {
+	const op = "lockout.Succeed"
+
+	if !g.c.Enabled {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), g.s.Options().WriteTimeout)
+	defer cancel()
+
+	if err := g.s.ResetLoginFailures(ctx, accountKey(email)); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// delay doubles the base delay n times up to the maximum.
+func (g *Guard) delay(n int) time.Duration {
+	d := g.c.BaseDelay
+	for i := 0; i < n && d < g.c.MaxDelay; i++ {
+		d *= 2
+	}
+
+	return min(d, g.c.MaxDelay)
+}
+
+func accountKey(email string) string {
+	return fmt.Sprintf("%s:%s", ScopeAccount, strings.ToLower(email))
+}
+
+func ipKey(ip string) string {
+	return fmt.Sprintf("%s:%s", ScopeIP, ip)
+}
@@ -0,0 +1,47 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// File appends messages to a file as JSON lines, handy for integration tests.
+type File struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFile(path string) (*File, error) {
+	if path == "" {
+		return nil, fmt.Errorf("notify.NewFile: path is empty")
+	}
+
+	return &File{path: path}, nil
+}
+
+func (n *File) Notify(ctx context.Context, m Message) error {
+	const op = "notify.File.Notify"
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
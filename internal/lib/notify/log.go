@@ -0,0 +1,29 @@
+package notify
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/korikhin/auth/internal/lib/logger"
+)
+
+// Log writes messages to the log. Never use it in production,
+// messages contain secrets such as reset tokens.
+type Log struct {
+	log *slog.Logger
+}
+
+func NewLog(log *slog.Logger) *Log {
+	return &Log{log: log.With(logger.Component("notify/log"))}
+}
+
+func (n *Log) Notify(ctx context.Context, m Message) error {
+	n.log.Info(
+		"notification",
+		slog.String("to", m.To),
+		slog.String("subject", m.Subject),
+		slog.String("body", m.Body),
+	)
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"github.com/korikhin/auth/internal/config"
+	"github.com/korikhin/auth/internal/lib/logger"
+)
+
+// Deliveries in the background are given up after this long
+const asyncTimeout = 30 * time.Second
+
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Notifier delivers messages to users, e.g. password reset links.
+type Notifier interface {
+	Notify(ctx context.Context, m Message) error
+}
+
+func New(c config.Notifier, log *slog.Logger) (Notifier, error) {
+	switch c.Driver {
+	case config.NotifierLog:
+		return NewLog(log), nil
+	case config.NotifierFile:
+		return NewFile(c.Path)
+	case config.NotifierSMTP:
+		return NewSMTP(c.SMTPAddress, c.From, c.SMTPUsername, c.SMTPPassword)
+	default:
+		return nil, fmt.Errorf("unknown notifier driver: %q", c.Driver)
+	}
+}
+
+// Async delivers the message in the background, so that neither slow
+// delivery nor its failure shows in the response. Failures are logged.
+func Async(log *slog.Logger, n Notifier, m Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), asyncTimeout)
+		defer cancel()
+
+		if err := n.Notify(ctx, m); err != nil {
+			log.Error("failed to deliver notification", slog.String("subject", m.Subject), logger.Error(err))
+		}
+	}()
+}
+
+// Link appends the token to the base URL as the `token` query parameter.
+// The bare token is returned if there is no valid base URL.
+func Link(base, token string) string {
+	u, err := url.Parse(base)
+	if err != nil || base == "" {
+		return token
+	}
+
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
@@ -0,0 +1,333 @@
+// Package oidc is the relying party side of OpenID Connect: it logs users
+// in with external identity providers with the authorization code flow
+// and PKCE, the ID tokens are validated against the keys of the provider.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/korikhin/auth/internal/config"
+	libjwt "github.com/korikhin/auth/internal/lib/jwt"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// Clocks of the providers are not ours
+	leeway = 1 * time.Minute
+	// Unknown key IDs refresh the key set at most this often
+	keysMinAge      = 1 * time.Minute
+	maxResponseSize = 1 << 20
+)
+
+var defaultScopes = []string{"openid", "email"}
+
+// Algorithms accepted for ID tokens, `none` and HMAC never are
+var algorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+var (
+	ErrProvider       = errors.New("identity provider request failed")
+	ErrIDTokenInvalid = errors.New("id token is invalid")
+)
+
+// Claims are the claims of the ID token the login relies on.
+type Claims struct {
+	jwt.RegisteredClaims
+
+	Nonce           string `json:"nonce"`
+	Email           string `json:"email"`
+	EmailVerified   bool   `json:"email_verified"`
+	AuthorizedParty string `json:"azp"`
+}
+
+// metadata is the part of the discovery document in use.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Provider is an external identity provider. The discovery document
+// and the keys are fetched on first use and cached.
+type Provider struct {
+	c      config.IdentityProvider
+	client *http.Client
+
+	mu     sync.Mutex
+	meta   *metadata
+	keys   map[string]crypto.PublicKey
+	keysAt time.Time
+}
+
+func New(c config.IdentityProvider, timeout time.Duration) *Provider {
+	if len(c.Scopes) == 0 {
+		c.Scopes = defaultScopes
+	}
+
+	return &Provider{
+		c:      c,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (p *Provider) Name() string {
+	return p.c.Name
+}
+
+// Issuer returns the issuer the provider is configured with,
+// it identifies the accounts along with the subject.
+func (p *Provider) Issuer() string {
+	return p.c.Issuer
+}
+
+// AuthURL returns the authorization request to send the browser to.
+func (p *Provider) AuthURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	const op = "oidc.AuthURL"
+
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	u, err := url.Parse(meta.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w: %w", op, ErrProvider, err)
+	}
+
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.c.ClientID)
+	q.Set("redirect_uri", p.c.CallbackURL)
+	q.Set("scope", strings.Join(p.c.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", challenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// Exchange redeems the code and returns the claims of the ID token,
+// which must carry the nonce of the login.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	const op = "oidc.Exchange"
+
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.c.CallbackURL},
+		"code_verifier": {verifier},
+	}
+	if p.c.ClientSecret == "" {
+		form.Set("client_id", p.c.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.c.ClientSecret != "" {
+		// The credentials are form-encoded first (RFC 6749, section 2.3.1)
+		req.SetBasicAuth(url.QueryEscape(p.c.ClientID), url.QueryEscape(p.c.ClientSecret))
+	}
+
+	resp := &tokenResponse{}
+	status, err := p.do(req, resp)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if status != http.StatusOK || resp.IDToken == "" {
+		return nil, fmt.Errorf("%s: %w: status %d: %s %s", op, ErrProvider, status, resp.Error, resp.ErrorDescription)
+	}
+
+	claims, err := p.validate(ctx, meta, resp.IDToken, nonce)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return claims, nil
+}
+
+func (p *Provider) validate(ctx context.Context, meta *metadata, token, nonce string) (*Claims, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(algorithms),
+		jwt.WithIssuer(meta.Issuer),
+		jwt.WithAudience(p.c.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(leeway),
+	}
+
+	keyFunc := func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, meta, kid)
+	}
+
+	claims := &Claims{}
+	if _, err := jwt.ParseWithClaims(token, claims, keyFunc, opts...); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrIDTokenInvalid, err)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: subject is missing", ErrIDTokenInvalid)
+	}
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce does not match", ErrIDTokenInvalid)
+	}
+	// OpenID Connect Core, section 3.1.3.7
+	if (len(claims.Audience) > 1 || claims.AuthorizedParty != "") && claims.AuthorizedParty != p.c.ClientID {
+		return nil, fmt.Errorf("%w: issued to another party", ErrIDTokenInvalid)
+	}
+
+	return claims, nil
+}
+
+func (p *Provider) metadata(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	u := strings.TrimSuffix(p.c.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &metadata{}
+	status, err := p.do(req, meta)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("%w: discovery status %d", ErrProvider, status)
+	}
+
+	// OpenID Connect Discovery, section 4.3
+	if meta.Issuer != p.c.Issuer {
+		return nil, fmt.Errorf("%w: issuer mismatch: %q", ErrProvider, meta.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: discovery document is incomplete", ErrProvider)
+	}
+
+	p.meta = meta
+	return meta, nil
+}
+
+// key returns the key with the ID, the key set is refreshed if the ID
+// is unknown, as the provider may have rotated the keys.
+func (p *Provider) key(ctx context.Context, meta *metadata, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if k, ok := p.lookup(kid); ok {
+		return k, nil
+	}
+	if time.Since(p.keysAt) < keysMinAge {
+		return nil, libjwt.ErrUnknownKey
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, meta.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &libjwt.JWKSet{}
+	status, err := p.do(req, set)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("%w: jwks status %d", ErrProvider, status)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, j := range set.Keys {
+		if j.Use != "" && j.Use != "sig" {
+			continue
+		}
+		// Keys of other types are of no use here
+		k, err := j.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[j.KeyID] = k
+	}
+	p.keys = keys
+	p.keysAt = time.Now()
+
+	if k, ok := p.lookup(kid); ok {
+		return k, nil
+	}
+
+	return nil, libjwt.ErrUnknownKey
+}
+
+// lookup must be called with the lock held. Tokens with no key ID
+// are accepted only if the provider has a single key.
+func (p *Provider) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k, true
+		}
+	}
+
+	k, ok := p.keys[kid]
+	return k, ok
+}
+
+func (p *Provider) do(req *http.Request, v any) (int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrProvider, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrProvider, err)
+	}
+
+	// Error responses of the token endpoint are JSON as well
+	if err := json.Unmarshal(body, v); err != nil && resp.StatusCode == http.StatusOK {
+		return 0, fmt.Errorf("%w: %w", ErrProvider, err)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// challenge derives the S256 code challenge (RFC 7636, section 4.2).
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,301 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/korikhin/auth/internal/config"
+	libjwt "github.com/korikhin/auth/internal/lib/jwt"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	clientID = "client"
+	nonce    = "nonce"
+)
+
+// fakeProvider serves discovery, the key set and a token endpoint
+// that returns whatever ID token the test sets.
+type fakeProvider struct {
+	srv *httptest.Server
+
+	mu        sync.Mutex
+	issuer    string
+	keys      map[string]*ecdsa.PrivateKey
+	idToken   string
+	jwksCalls int
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	t.Helper()
+
+	f := &fakeProvider{keys: make(map[string]*ecdsa.PrivateKey)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		json.NewEncoder(w).Encode(metadata{
+			Issuer:                f.issuer,
+			AuthorizationEndpoint: f.srv.URL + "/authorize",
+			TokenEndpoint:         f.srv.URL + "/token",
+			JWKSURI:               f.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		f.jwksCalls++
+		set := libjwt.JWKSet{}
+		for kid, k := range f.keys {
+			key := &libjwt.Key{ID: kid, Method: jwt.SigningMethodES256, Public: &k.PublicKey}
+			set.Keys = append(set.Keys, key.JWK())
+		}
+		json.NewEncoder(w).Encode(set)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		json.NewEncoder(w).Encode(tokenResponse{IDToken: f.idToken})
+	})
+
+	f.srv = httptest.NewServer(mux)
+	f.issuer = f.srv.URL
+	t.Cleanup(f.srv.Close)
+
+	return f
+}
+
+// rotate replaces the key set with a single new key.
+func (f *fakeProvider) rotate(t *testing.T, kid string) *ecdsa.PrivateKey {
+	t.Helper()
+
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys = map[string]*ecdsa.PrivateKey{kid: k}
+
+	return k
+}
+
+func (f *fakeProvider) setIDToken(token string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.idToken = token
+}
+
+func (f *fakeProvider) keySetFetches() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.jwksCalls
+}
+
+func (f *fakeProvider) provider() *Provider {
+	return New(config.IdentityProvider{
+		Name:     "fake",
+		Issuer:   f.srv.URL,
+		ClientID: clientID,
+	}, time.Second)
+}
+
+// claims returns valid claims of the ID token, for the tests to spoil.
+func (f *fakeProvider) claims() *Claims {
+	now := time.Now()
+	return &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    f.srv.URL,
+			Subject:   "subject",
+			Audience:  jwt.ClaimStrings{clientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+		Nonce:         nonce,
+		Email:         "user@example.com",
+		EmailVerified: true,
+	}
+}
+
+func sign(t *testing.T, c *Claims, kid string, k *ecdsa.PrivateKey) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, c)
+	token.Header["kid"] = kid
+
+	s, err := token.SignedString(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	f := newFakeProvider(t)
+	f.issuer = "https://evil.example"
+
+	_, err := f.provider().AuthURL(context.Background(), "state", nonce, "verifier")
+	if !errors.Is(err, ErrProvider) {
+		t.Fatalf("error = %v, want %v", err, ErrProvider)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	f := newFakeProvider(t)
+	key := f.rotate(t, "a")
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		spoil func(c *Claims)
+		key   *ecdsa.PrivateKey
+		want  error
+	}{
+		{name: "valid"},
+		{name: "bad signature", key: other, want: ErrIDTokenInvalid},
+		{
+			name:  "wrong issuer",
+			spoil: func(c *Claims) { c.Issuer = "https://evil.example" },
+			want:  ErrIDTokenInvalid,
+		},
+		{
+			name:  "wrong audience",
+			spoil: func(c *Claims) { c.Audience = jwt.ClaimStrings{"other"} },
+			want:  ErrIDTokenInvalid,
+		},
+		{
+			name:  "wrong nonce",
+			spoil: func(c *Claims) { c.Nonce = "other" },
+			want:  ErrIDTokenInvalid,
+		},
+		{
+			name:  "wrong authorized party",
+			spoil: func(c *Claims) { c.AuthorizedParty = "other" },
+			want:  ErrIDTokenInvalid,
+		},
+		{
+			name:  "several audiences without authorized party",
+			spoil: func(c *Claims) { c.Audience = jwt.ClaimStrings{clientID, "other"} },
+			want:  ErrIDTokenInvalid,
+		},
+		{
+			name: "several audiences with authorized party",
+			spoil: func(c *Claims) {
+				c.Audience = jwt.ClaimStrings{clientID, "other"}
+				c.AuthorizedParty = clientID
+			},
+		},
+		{
+			name:  "expired",
+			spoil: func(c *Claims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour)) },
+			want:  ErrIDTokenInvalid,
+		},
+		{
+			name:  "subject missing",
+			spoil: func(c *Claims) { c.Subject = "" },
+			want:  ErrIDTokenInvalid,
+		},
+	}
+
+	p := f.provider()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := f.claims()
+			if tt.spoil != nil {
+				tt.spoil(c)
+			}
+			k := key
+			if tt.key != nil {
+				k = tt.key
+			}
+			f.setIDToken(sign(t, c, "a", k))
+
+			got, err := p.Exchange(context.Background(), "code", "verifier", nonce)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("error = %v, want %v", err, tt.want)
+			}
+			if err == nil && got.Subject != "subject" {
+				t.Errorf("subject = %q, want %q", got.Subject, "subject")
+			}
+		})
+	}
+}
+
+func TestExchangeHMAC(t *testing.T) {
+	f := newFakeProvider(t)
+	f.rotate(t, "a")
+
+	// A token signed with a public value as the HMAC secret
+	s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, f.claims()).SignedString([]byte(clientID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.setIDToken(s)
+
+	if _, err := f.provider().Exchange(context.Background(), "code", "verifier", nonce); !errors.Is(err, ErrIDTokenInvalid) {
+		t.Fatalf("error = %v, want %v", err, ErrIDTokenInvalid)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	f := newFakeProvider(t)
+	p := f.provider()
+
+	exchange := func() error {
+		_, err := p.Exchange(context.Background(), "code", "verifier", nonce)
+		return err
+	}
+
+	old := f.rotate(t, "a")
+	f.setIDToken(sign(t, f.claims(), "a", old))
+	if err := exchange(); err != nil {
+		t.Fatalf("first key: %v", err)
+	}
+
+	// Unknown key IDs refetch the key set at most once in keysMinAge
+	next := f.rotate(t, "b")
+	f.setIDToken(sign(t, f.claims(), "b", next))
+	if err := exchange(); !errors.Is(err, ErrIDTokenInvalid) {
+		t.Fatalf("error = %v, want %v", err, ErrIDTokenInvalid)
+	}
+	if n := f.keySetFetches(); n != 1 {
+		t.Fatalf("key set fetched %d times, want 1", n)
+	}
+
+	p.mu.Lock()
+	p.keysAt = time.Now().Add(-keysMinAge)
+	p.mu.Unlock()
+
+	if err := exchange(); err != nil {
+		t.Fatalf("rotated key: %v", err)
+	}
+	if n := f.keySetFetches(); n != 2 {
+		t.Errorf("key set fetched %d times, want 2", n)
+	}
+
+	// The retired key is gone with the refetch
+	f.setIDToken(sign(t, f.claims(), "a", old))
+	if err := exchange(); !errors.Is(err, ErrIDTokenInvalid) {
+		t.Errorf("retired key: error = %v, want %v", err, ErrIDTokenInvalid)
+	}
+}
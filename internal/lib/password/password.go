@@ -0,0 +1,69 @@
+package password
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/korikhin/auth/internal/config"
+)
+
+var (
+	ErrMismatch    = errors.New("password does not match")
+	ErrUnknownHash = errors.New("unknown password hash format")
+)
+
+// Hasher hashes passwords with one algorithm and its parameters.
+type Hasher interface {
+	Hash(password string) ([]byte, error)
+	Compare(hash []byte, password string) error
+	// NeedsRehash reports whether the hash was made by another
+	// algorithm or with other parameters than the current ones.
+	NeedsRehash(hash []byte) bool
+}
+
+// New returns the hasher configured for new passwords.
+func New(c config.Password) (Hasher, error) {
+	switch c.Algorithm {
+	case config.Argon2id:
+		return NewArgon2id(c.Argon2Memory, c.Argon2Iterations, c.Argon2Parallelism)
+	case config.Bcrypt:
+		return NewBcrypt(c.BcryptCost)
+	default:
+		return nil, fmt.Errorf("unknown password hashing algorithm: %q", c.Algorithm)
+	}
+}
+
+// Compare returns nil if the password matches the hash. Any supported
+// format is accepted, the parameters are taken from the hash itself.
+func Compare(hash []byte, password string) error {
+	switch {
+	case bytes.HasPrefix(hash, []byte(argon2idPrefix)):
+		return (&Argon2id{}).Compare(hash, password)
+	case isBcrypt(hash):
+		return (&Bcrypt{}).Compare(hash, password)
+	default:
+		return ErrUnknownHash
+	}
+}
+
+// NewToken returns a random single-use token to be sent to the user
+// and its hash to be stored instead of the token itself.
+func NewToken() (string, []byte, error) {
+	var buf [32]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", nil, err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(buf[:])
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the hash of the token as it is stored.
+func HashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
@@ -0,0 +1,146 @@
+package password
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/korikhin/auth/internal/config"
+)
+
+// bcrypt ignores everything past 72 bytes
+const bcryptMaxBytes = 72
+
+// Policy describes what new passwords must look like.
+type Policy struct {
+	MinLength int
+	MaxLength int
+	// MaxBytes is the limit of the hashing algorithm, 0 if there is none
+	MaxBytes int
+
+	Upper  bool
+	Lower  bool
+	Digit  bool
+	Symbol bool
+
+	banned map[string]struct{}
+}
+
+func NewPolicy(c config.Password) (*Policy, error) {
+	const op = "password.NewPolicy"
+
+	if c.MinLength < 1 || c.MaxLength < c.MinLength {
+		return nil, fmt.Errorf("%s: invalid length limits: [%d, %d]", op, c.MinLength, c.MaxLength)
+	}
+
+	p := &Policy{
+		MinLength: c.MinLength,
+		MaxLength: c.MaxLength,
+		Upper:     c.RequireUpper,
+		Lower:     c.RequireLower,
+		Digit:     c.RequireDigit,
+		Symbol:    c.RequireSymbol,
+	}
+	if c.Algorithm == config.Bcrypt {
+		p.MaxBytes = bcryptMaxBytes
+	}
+
+	if c.BannedFile != "" {
+		banned, err := readBanned(c.BannedFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		p.banned = banned
+	}
+
+	return p, nil
+}
+
+func (p *Policy) ValidLength(password string) bool {
+	n := utf8.RuneCountInString(password)
+	return n >= p.MinLength && n <= p.MaxLength
+}
+
+func (p *Policy) ValidBytes(password string) bool {
+	return p.MaxBytes == 0 || len(password) <= p.MaxBytes
+}
+
+func (p *Policy) ValidClasses(password string) bool {
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+
+	return (!p.Upper || upper) && (!p.Lower || lower) && (!p.Digit || digit) && (!p.Symbol || symbol)
+}
+
+// Classes returns the names of the required character classes.
+func (p *Policy) Classes() []string {
+	var classes []string
+	if p.Upper {
+		classes = append(classes, "an uppercase letter")
+	}
+	if p.Lower {
+		classes = append(classes, "a lowercase letter")
+	}
+	if p.Digit {
+		classes = append(classes, "a digit")
+	}
+	if p.Symbol {
+		classes = append(classes, "a symbol")
+	}
+
+	return classes
+}
+
+func (p *Policy) Banned(password string) bool {
+	_, ok := p.banned[strings.ToLower(password)]
+	return ok
+}
+
+// MatchesEmail reports whether the password is the email or its local part.
+func MatchesEmail(password, email string) bool {
+	if email == "" {
+		return false
+	}
+
+	local, _, _ := strings.Cut(email, "@")
+	return strings.EqualFold(password, email) || strings.EqualFold(password, local)
+}
+
+// readBanned reads the list skipping blank lines and `#` comments.
+func readBanned(path string) (map[string]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	banned := make(map[string]struct{})
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		banned[strings.ToLower(line)] = struct{}{}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	return banned, nil
+}
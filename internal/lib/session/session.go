@@ -0,0 +1,135 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/lib/jwt"
+	"github.com/korikhin/auth/internal/storage"
+)
+
+type Tokens struct {
+	Access     string
+	AccessExp  time.Time
+	Refresh    string
+	RefreshExp time.Time
+}
+
+// Set writes the tokens to the response: the access token
+// to the Authorization header, the refresh token to the cookie.
+func (t *Tokens) Set(w http.ResponseWriter) {
+	jwt.SetRefreshToken(w, t.Refresh, t.RefreshExp)
+	jwt.SetAccessToken(w, t.Access)
+}
+
+// Start issues a token pair opening a new refresh token family,
+// authenticated with the given methods just now.
+func Start(ctx context.Context, a *jwt.JWTService, s storage.SessionStorage, user *models.User, amr []string) (*Tokens, error) {
+	const op = "session.Start"
+
+	t, err := issue(a, user, "", amr, time.Now(), jwt.Grant{}, func(rt *models.RefreshToken) error {
+		return s.SaveRefreshToken(ctx, rt)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return t, nil
+}
+
+// StartGrant issues a token pair to the OAuth client acting on behalf
+// of the user, the refresh token opens a family of its own. The user was
+// authenticated at authTime, when the grant was approved.
+func StartGrant(ctx context.Context, a *jwt.JWTService, s storage.SessionStorage, user *models.User, amr []string, authTime time.Time, g jwt.Grant) (*Tokens, error) {
+	const op = "session.StartGrant"
+
+	t, err := issue(a, user, "", amr, authTime, g, func(rt *models.RefreshToken) error {
+		return s.SaveRefreshToken(ctx, rt)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return t, nil
+}
+
+// Rotate exchanges the refresh token described by claims for a new pair
+// within the same family.
+//
+// Presenting an already rotated token is treated as theft: the whole family
+// is revoked, so neither the attacker nor the victim can use it anymore.
+func Rotate(ctx context.Context, a *jwt.JWTService, s storage.SessionStorage, user *models.User, claims *jwt.Claims) (*Tokens, error) {
+	const op = "session.Rotate"
+
+	t, err := issue(a, user, claims.Family, claims.AMR, claims.AuthenticatedAt(), claims.Grant(), func(rt *models.RefreshToken) error {
+		return s.RotateRefreshToken(ctx, claims.ID, rt)
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrRefreshTokenReused) {
+			if rerr := s.RevokeTokenFamily(ctx, claims.Family); rerr != nil {
+				err = errors.Join(err, rerr)
+			}
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return t, nil
+}
+
+// MFAEnrolled reports whether the user has confirmed a second factor,
+// logins of such users must pass it before a session is started.
+func MFAEnrolled(s storage.MFAStorage, userID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Options().ReadTimeout)
+	defer cancel()
+
+	t, err := s.TOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrMFANotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return t.Confirmed, nil
+}
+
+// IsInvalid reports whether the rotation failed because of the presented
+// token rather than the server.
+func IsInvalid(err error) bool {
+	return errors.Is(err, storage.ErrRefreshTokenNotFound) ||
+		errors.Is(err, storage.ErrRefreshTokenRevoked) ||
+		errors.Is(err, storage.ErrRefreshTokenReused)
+}
+
+func issue(a *jwt.JWTService, user *models.User, family string, amr []string, authTime time.Time, g jwt.Grant, save func(*models.RefreshToken) error) (*Tokens, error) {
+	refreshToken, rc, err := a.IssueRefresh(user, family, amr, authTime, g)
+	if err != nil {
+		return nil, err
+	}
+
+	rt := &models.RefreshToken{
+		ID:        rc.ID,
+		FamilyID:  rc.Family,
+		UserID:    user.ID,
+		ExpiresAt: rc.ExpiresAt.Time,
+	}
+	if err := save(rt); err != nil {
+		return nil, err
+	}
+
+	accessToken, exp, err := a.IssueAccess(user, rc.Family, amr, authTime, g)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tokens{
+		Access:     accessToken,
+		AccessExp:  exp,
+		Refresh:    refreshToken,
+		RefreshExp: rc.ExpiresAt.Time,
+	}, nil
+}
@@ -0,0 +1,115 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// The SHA1 seed of RFC 6238, Appendix B, base32 encoded
+var rfcSecret = encoding.EncodeToString([]byte("12345678901234567890"))
+
+// The SHA1 vectors of RFC 6238, Appendix B, cut to the 6 digits
+// of the codes used here, which are the last digits of the 8 digit ones.
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestGenerate(t *testing.T) {
+	key, err := encoding.DecodeString(rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range rfcVectors {
+		if got := generate(key, Step(time.Unix(v.unix, 0))); got != v.code {
+			t.Errorf("generate(T = %d) = %s, want %s", v.unix, got, v.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, v := range rfcVectors {
+		at := time.Unix(v.unix, 0)
+
+		step, ok := Validate(rfcSecret, v.code, at)
+		if !ok || step != Step(at) {
+			t.Errorf("Validate(T = %d) = %d, %v, want %d, true", v.unix, step, ok, Step(at))
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	key, err := encoding.DecodeString(rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first second of the step, the previous step ended a second ago
+	now := time.Unix(1111111110, 0)
+	current := Step(now)
+
+	tests := []struct {
+		name  string
+		steps int64
+		want  bool
+	}{
+		{name: "two steps behind", steps: -2},
+		{name: "one step behind", steps: -1, want: true},
+		{name: "current step", steps: 0, want: true},
+		{name: "one step ahead", steps: 1, want: true},
+		{name: "two steps ahead", steps: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := generate(key, current+tt.steps)
+
+			step, ok := Validate(rfcSecret, code, now)
+			if ok != tt.want {
+				t.Fatalf("Validate() = %v, want %v", ok, tt.want)
+			}
+			if ok && step != current+tt.steps {
+				t.Errorf("step = %d, want %d", step, current+tt.steps)
+			}
+		})
+	}
+}
+
+func TestValidateMalformed(t *testing.T) {
+	at := time.Unix(59, 0)
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+	}{
+		{name: "short code", secret: rfcSecret, code: "87082"},
+		{name: "long code", secret: rfcSecret, code: "94287082"},
+		{name: "empty code", secret: rfcSecret},
+		{name: "bad secret", secret: "not base32!", code: "287082"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := Validate(tt.secret, tt.code, at); ok {
+				t.Error("code is accepted")
+			}
+		})
+	}
+}
+
+// Secrets may be typed in lower case from the otpauth URI.
+func TestValidateLowerCaseSecret(t *testing.T) {
+	if _, ok := Validate(strings.ToLower(rfcSecret), "287082", time.Unix(59, 0)); !ok {
+		t.Error("code is rejected")
+	}
+}
@@ -0,0 +1,389 @@
+// Package webauthn implements the relying party side of the Web
+// Authentication ceremonies (https://www.w3.org/TR/webauthn-2/):
+// registration of public key credentials and assertions with them.
+//
+// Attestation formats `none` and `packed` are accepted, certificate
+// chains are not checked as the server requests no attestation.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/korikhin/auth/internal/config"
+)
+
+// Client data types
+const (
+	TypeCreate = "webauthn.create"
+	TypeGet    = "webauthn.get"
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent  = 1 << 0
+	flagUserVerified = 1 << 2
+	flagAttested     = 1 << 6
+	flagExtensions   = 1 << 7
+)
+
+var (
+	ErrInvalidClientData = errors.New("invalid client data")
+	ErrInvalidAuthData   = errors.New("invalid authenticator data")
+	ErrInvalidSignature  = errors.New("invalid signature")
+	ErrUnsupportedFormat = errors.New("unsupported attestation format")
+)
+
+// oidAAGUID is the certificate extension holding the authenticator model.
+var oidAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+// Bytes is binary data encoded as unpadded base64url in JSON,
+// the way browsers serialize credentials.
+type Bytes []byte
+
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	v, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+
+	*b = v
+	return nil
+}
+
+// RelyingParty is the server the credentials are scoped to.
+type RelyingParty struct {
+	// ID is the domain of the credentials, e.g. `example.com`
+	ID   string
+	Name string
+	// Origins allowed to run the ceremonies, e.g. `https://app.example.com`
+	Origins []string
+	// UserVerification requires the authenticator to verify the user
+	// with a PIN or biometrics rather than only test their presence
+	UserVerification bool
+	Timeout          time.Duration
+}
+
+func New(c config.WebAuthn) *RelyingParty {
+	origins := c.Origins
+	if len(origins) == 0 {
+		origins = []string{fmt.Sprintf("https://%s", c.RPID)}
+	}
+
+	return &RelyingParty{
+		ID:               c.RPID,
+		Name:             c.RPName,
+		Origins:          origins,
+		UserVerification: c.UserVerification,
+		Timeout:          c.Timeout,
+	}
+}
+
+// ClientData is the data the browser passes to the authenticator.
+type ClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+
+	raw []byte
+}
+
+// ParseClientData decodes `clientDataJSON`, the challenge is then
+// used to look up the ceremony it belongs to.
+func ParseClientData(raw []byte) (*ClientData, error) {
+	c := &ClientData{raw: raw}
+	if err := json.Unmarshal(raw, c); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidClientData, err)
+	}
+	if c.Challenge == "" {
+		return nil, fmt.Errorf("%w: challenge is missing", ErrInvalidClientData)
+	}
+
+	return c, nil
+}
+
+// Credential is a registered public key credential.
+type Credential struct {
+	ID []byte
+	// PublicKey is the COSE encoded key
+	PublicKey    []byte
+	AAGUID       []byte
+	SignCount    uint32
+	UserVerified bool
+}
+
+// Assertion is the outcome of a verified assertion.
+type Assertion struct {
+	SignCount    uint32
+	UserVerified bool
+}
+
+// authData is the parsed authenticator data (section 6.1).
+type authData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+
+	// Present in registrations only
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+func parseAuthData(data []byte) (*authData, error) {
+	if len(data) < 37 {
+		return nil, fmt.Errorf("%w: too short", ErrInvalidAuthData)
+	}
+
+	a := &authData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[37:]
+
+	if a.flags&flagAttested != 0 {
+		if len(rest) < 18 {
+			return nil, fmt.Errorf("%w: credential data is too short", ErrInvalidAuthData)
+		}
+		a.aaguid = rest[:16]
+
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if n == 0 || n > 1023 || len(rest) < n {
+			return nil, fmt.Errorf("%w: invalid credential ID", ErrInvalidAuthData)
+		}
+		a.credentialID, rest = rest[:n], rest[n:]
+
+		_, size, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: public key: %w", ErrInvalidAuthData, err)
+		}
+		a.publicKey, rest = rest[:size], rest[size:]
+	}
+
+	if a.flags&flagExtensions != 0 {
+		_, size, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: extensions: %w", ErrInvalidAuthData, err)
+		}
+		rest = rest[size:]
+	}
+
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: trailing data", ErrInvalidAuthData)
+	}
+
+	return a, nil
+}
+
+// VerifyRegistration checks the attestation of a new credential
+// (section 7.1) issued for the challenge, and returns the credential.
+func (rp *RelyingParty) VerifyRegistration(c *ClientData, challenge string, attestationObject []byte) (*Credential, error) {
+	if err := rp.verifyClientData(c, TypeCreate, challenge); err != nil {
+		return nil, err
+	}
+
+	v, n, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(attestationObject) {
+		return nil, fmt.Errorf("%w: trailing data", errCBOR)
+	}
+
+	att, _ := v.(map[any]any)
+	format, _ := att["fmt"].(string)
+	stmt, _ := att["attStmt"].(map[any]any)
+	rawAuthData, _ := att["authData"].([]byte)
+	if stmt == nil || rawAuthData == nil {
+		return nil, fmt.Errorf("%w: invalid attestation object", errCBOR)
+	}
+
+	a, err := parseAuthData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthData(a); err != nil {
+		return nil, err
+	}
+	if a.credentialID == nil {
+		return nil, fmt.Errorf("%w: credential data is missing", ErrInvalidAuthData)
+	}
+
+	key, err := parsePublicKey(a.publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(c.raw)
+	signed := append(append([]byte(nil), rawAuthData...), clientDataHash[:]...)
+
+	switch format {
+	case "none":
+		if len(stmt) != 0 {
+			return nil, fmt.Errorf("%w: none with a statement", ErrUnsupportedFormat)
+		}
+	case "packed":
+		if err := verifyPacked(stmt, signed, key, a.aaguid); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+	}
+
+	cred := &Credential{
+		ID:           bytes.Clone(a.credentialID),
+		PublicKey:    bytes.Clone(a.publicKey),
+		AAGUID:       bytes.Clone(a.aaguid),
+		SignCount:    a.signCount,
+		UserVerified: a.flags&flagUserVerified != 0,
+	}
+
+	return cred, nil
+}
+
+// verifyPacked checks the `packed` statement (section 8.2), signed either
+// by the credential itself or by an attestation certificate.
+func verifyPacked(stmt map[any]any, signed []byte, key *publicKey, aaguid []byte) error {
+	alg, _ := stmt["alg"].(int64)
+	sig, _ := stmt["sig"].([]byte)
+	if sig == nil {
+		return fmt.Errorf("%w: packed without a signature", ErrUnsupportedFormat)
+	}
+
+	x5c, ok := stmt["x5c"].([]any)
+	if !ok {
+		// Self attestation
+		if alg != key.alg {
+			return fmt.Errorf("%w: algorithm mismatch", ErrInvalidSignature)
+		}
+		if !key.verify(signed, sig) {
+			return ErrInvalidSignature
+		}
+		return nil
+	}
+
+	if len(x5c) == 0 {
+		return fmt.Errorf("%w: empty certificate chain", ErrUnsupportedFormat)
+	}
+
+	der, _ := x5c[0].([]byte)
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUnsupportedFormat, err)
+	}
+
+	if !slices.Contains(Algorithms, alg) {
+		return fmt.Errorf("%w: algorithm %d", ErrUnsupportedKey, alg)
+	}
+	if !(&publicKey{alg: alg, key: cert.PublicKey}).verify(signed, sig) {
+		return ErrInvalidSignature
+	}
+
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidAAGUID) {
+			continue
+		}
+
+		var id []byte
+		if _, err := asn1.Unmarshal(ext.Value, &id); err != nil || !bytes.Equal(id, aaguid) {
+			return fmt.Errorf("%w: aaguid mismatch", ErrUnsupportedFormat)
+		}
+	}
+
+	return nil
+}
+
+// VerifyAssertion checks the assertion (section 7.2) issued for the
+// challenge with the stored credential, signCount is the last one seen.
+func (rp *RelyingParty) VerifyAssertion(c *ClientData, challenge string, cred *Credential, rawAuthData, sig []byte) (*Assertion, error) {
+	if err := rp.verifyClientData(c, TypeGet, challenge); err != nil {
+		return nil, err
+	}
+
+	a, err := parseAuthData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthData(a); err != nil {
+		return nil, err
+	}
+
+	key, err := parsePublicKey(cred.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(c.raw)
+	signed := append(append([]byte(nil), rawAuthData...), clientDataHash[:]...)
+	if !key.verify(signed, sig) {
+		return nil, ErrInvalidSignature
+	}
+
+	// A counter that does not grow means the authenticator may have been
+	// cloned. Authenticators without counters always report zero
+	if (a.signCount != 0 || cred.SignCount != 0) && a.signCount <= cred.SignCount {
+		return nil, fmt.Errorf("%w: signature counter went back", ErrInvalidAuthData)
+	}
+
+	assertion := &Assertion{
+		SignCount:    a.signCount,
+		UserVerified: a.flags&flagUserVerified != 0,
+	}
+
+	return assertion, nil
+}
+
+func (rp *RelyingParty) verifyClientData(c *ClientData, typ, challenge string) error {
+	if c.Type != typ {
+		return fmt.Errorf("%w: type %q", ErrInvalidClientData, c.Type)
+	}
+	if subtle.ConstantTimeCompare([]byte(c.Challenge), []byte(challenge)) != 1 {
+		return fmt.Errorf("%w: challenge mismatch", ErrInvalidClientData)
+	}
+	if !slices.Contains(rp.Origins, c.Origin) {
+		return fmt.Errorf("%w: origin %q", ErrInvalidClientData, c.Origin)
+	}
+	if c.CrossOrigin {
+		return fmt.Errorf("%w: cross-origin", ErrInvalidClientData)
+	}
+
+	return nil
+}
+
+func (rp *RelyingParty) verifyAuthData(a *authData) error {
+	hash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(a.rpIDHash, hash[:]) {
+		return fmt.Errorf("%w: relying party mismatch", ErrInvalidAuthData)
+	}
+	if a.flags&flagUserPresent == 0 {
+		return fmt.Errorf("%w: user is not present", ErrInvalidAuthData)
+	}
+	if rp.UserVerification && a.flags&flagUserVerified == 0 {
+		return fmt.Errorf("%w: user is not verified", ErrInvalidAuthData)
+	}
+
+	return nil
+}
@@ -0,0 +1,441 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+const (
+	rpID      = "example.com"
+	origin    = "https://example.com"
+	challenge = "Y2hhbGxlbmdl"
+)
+
+// cborMap keeps the order of the pairs, so that encoding is deterministic.
+type cborMap [][2]any
+
+// encodeCBOR covers the items the ceremonies use.
+func encodeCBOR(v any) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n <= 0xff:
+			return []byte{major<<5 | 24, byte(n)}
+		case n <= 0xffff:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		default:
+			return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+		}
+	}
+
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case []any:
+		b := head(4, uint64(len(v)))
+		for _, item := range v {
+			b = append(b, encodeCBOR(item)...)
+		}
+		return b
+	case cborMap:
+		b := head(5, uint64(len(v)))
+		for _, kv := range v {
+			b = append(b, encodeCBOR(kv[0])...)
+			b = append(b, encodeCBOR(kv[1])...)
+		}
+		return b
+	}
+
+	panic("unsupported item")
+}
+
+// authenticator is a software authenticator with an ES256 credential.
+type authenticator struct {
+	key    *ecdsa.PrivateKey
+	id     []byte
+	aaguid []byte
+	rpID   string
+	flags  byte
+	count  uint32
+}
+
+func newAuthenticator(t *testing.T) *authenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &authenticator{
+		key:    key,
+		id:     []byte("credential-id"),
+		aaguid: make([]byte, 16),
+		rpID:   rpID,
+		flags:  flagUserPresent | flagUserVerified,
+	}
+}
+
+func (a *authenticator) coseKey() []byte {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.key.X.FillBytes(x)
+	a.key.Y.FillBytes(y)
+
+	return encodeCBOR(cborMap{{1, 2}, {3, int(AlgES256)}, {-1, 1}, {-2, x}, {-3, y}})
+}
+
+func (a *authenticator) authData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+
+	flags := a.flags
+	if attested {
+		flags |= flagAttested
+	}
+
+	b := append(rpIDHash[:], flags)
+	b = binary.BigEndian.AppendUint32(b, a.count)
+	if attested {
+		b = append(b, a.aaguid...)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(a.id)))
+		b = append(b, a.id...)
+		b = append(b, a.coseKey()...)
+	}
+
+	return b
+}
+
+func (a *authenticator) sign(t *testing.T, authData, clientData []byte) []byte {
+	t.Helper()
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sig
+}
+
+// attest returns the attestation object of the given format.
+func (a *authenticator) attest(t *testing.T, format string, clientData []byte) []byte {
+	t.Helper()
+
+	authData := a.authData(true)
+
+	stmt := cborMap{}
+	if format == "packed" {
+		stmt = cborMap{{"alg", int(AlgES256)}, {"sig", a.sign(t, authData, clientData)}}
+	}
+
+	return encodeCBOR(cborMap{{"fmt", format}, {"attStmt", stmt}, {"authData", authData}})
+}
+
+func clientData(t *testing.T, typ, challenge, origin string) (*ClientData, []byte) {
+	t.Helper()
+
+	raw, err := json.Marshal(map[string]any{
+		"type":      typ,
+		"challenge": challenge,
+		"origin":    origin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := ParseClientData(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c, raw
+}
+
+func relyingParty() *RelyingParty {
+	return &RelyingParty{ID: rpID, Name: "Test", Origins: []string{origin}}
+}
+
+func TestVerifyRegistration(t *testing.T) {
+	for _, format := range []string{"none", "packed"} {
+		t.Run(format, func(t *testing.T) {
+			a := newAuthenticator(t)
+			a.count = 1
+
+			c, raw := clientData(t, TypeCreate, challenge, origin)
+			cred, err := relyingParty().VerifyRegistration(c, challenge, a.attest(t, format, raw))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if string(cred.ID) != string(a.id) {
+				t.Errorf("credential ID = %q, want %q", cred.ID, a.id)
+			}
+			if string(cred.PublicKey) != string(a.coseKey()) {
+				t.Error("public key does not match the authenticator")
+			}
+			if cred.SignCount != 1 || !cred.UserVerified {
+				t.Errorf("sign count = %d, user verified = %t", cred.SignCount, cred.UserVerified)
+			}
+		})
+	}
+}
+
+func TestVerifyRegistrationRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(rp *RelyingParty, a *authenticator)
+		// attestation overrides the attestation object if set
+		attestation func(t *testing.T, a *authenticator, raw []byte) []byte
+		typ         string
+		challenge   string
+		origin      string
+		want        error
+	}{
+		{
+			name:  "relying party mismatch",
+			setup: func(_ *RelyingParty, a *authenticator) { a.rpID = "evil.example" },
+			want:  ErrInvalidAuthData,
+		},
+		{
+			name:   "origin mismatch",
+			origin: "https://evil.example",
+			want:   ErrInvalidClientData,
+		},
+		{
+			name:      "challenge mismatch",
+			challenge: "b3RoZXI",
+			want:      ErrInvalidClientData,
+		},
+		{
+			name: "wrong type",
+			typ:  TypeGet,
+			want: ErrInvalidClientData,
+		},
+		{
+			name:  "user not present",
+			setup: func(_ *RelyingParty, a *authenticator) { a.flags = flagUserVerified },
+			want:  ErrInvalidAuthData,
+		},
+		{
+			name: "user not verified",
+			setup: func(rp *RelyingParty, a *authenticator) {
+				rp.UserVerification = true
+				a.flags = flagUserPresent
+			},
+			want: ErrInvalidAuthData,
+		},
+		{
+			name: "packed signed by another key",
+			attestation: func(t *testing.T, a *authenticator, raw []byte) []byte {
+				other := newAuthenticator(t)
+				authData := a.authData(true)
+				stmt := cborMap{{"alg", int(AlgES256)}, {"sig", other.sign(t, authData, raw)}}
+				return encodeCBOR(cborMap{{"fmt", "packed"}, {"attStmt", stmt}, {"authData", authData}})
+			},
+			want: ErrInvalidSignature,
+		},
+		{
+			name: "none with a statement",
+			attestation: func(t *testing.T, a *authenticator, raw []byte) []byte {
+				authData := a.authData(true)
+				stmt := cborMap{{"sig", a.sign(t, authData, raw)}}
+				return encodeCBOR(cborMap{{"fmt", "none"}, {"attStmt", stmt}, {"authData", authData}})
+			},
+			want: ErrUnsupportedFormat,
+		},
+		{
+			name: "unknown format",
+			attestation: func(t *testing.T, a *authenticator, raw []byte) []byte {
+				return encodeCBOR(cborMap{{"fmt", "fido-u2f"}, {"attStmt", cborMap{}}, {"authData", a.authData(true)}})
+			},
+			want: ErrUnsupportedFormat,
+		},
+		{
+			name: "credential data missing",
+			attestation: func(t *testing.T, a *authenticator, raw []byte) []byte {
+				return encodeCBOR(cborMap{{"fmt", "none"}, {"attStmt", cborMap{}}, {"authData", a.authData(false)}})
+			},
+			want: ErrInvalidAuthData,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := relyingParty()
+			a := newAuthenticator(t)
+			if tt.setup != nil {
+				tt.setup(rp, a)
+			}
+
+			typ, ch, org := TypeCreate, challenge, origin
+			if tt.typ != "" {
+				typ = tt.typ
+			}
+			if tt.challenge != "" {
+				ch = tt.challenge
+			}
+			if tt.origin != "" {
+				org = tt.origin
+			}
+			c, raw := clientData(t, typ, ch, org)
+
+			attestation := a.attest(t, "packed", raw)
+			if tt.attestation != nil {
+				attestation = tt.attestation(t, a, raw)
+			}
+
+			if _, err := rp.VerifyRegistration(c, challenge, attestation); !errors.Is(err, tt.want) {
+				t.Fatalf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	a := newAuthenticator(t)
+	rp := relyingParty()
+
+	c, raw := clientData(t, TypeCreate, challenge, origin)
+	cred, err := rp.VerifyRegistration(c, challenge, a.attest(t, "none", raw))
+	if err != nil {
+		t.Fatalf("registration: %v", err)
+	}
+
+	assert := func(a *authenticator, challenge, origin string) (*ClientData, []byte, []byte) {
+		c, raw := clientData(t, TypeGet, challenge, origin)
+		authData := a.authData(false)
+		return c, authData, a.sign(t, authData, raw)
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		a.count = 5
+		c, authData, sig := assert(a, challenge, origin)
+
+		got, err := rp.VerifyAssertion(c, challenge, cred, authData, sig)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got.SignCount != 5 || !got.UserVerified {
+			t.Errorf("sign count = %d, user verified = %t", got.SignCount, got.UserVerified)
+		}
+	})
+
+	t.Run("without counters", func(t *testing.T) {
+		a.count = 0
+		c, authData, sig := assert(a, challenge, origin)
+
+		stored := *cred
+		stored.SignCount = 0
+		if _, err := rp.VerifyAssertion(c, challenge, &stored, authData, sig); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("without user verification", func(t *testing.T) {
+		a.count = 6
+		a.flags = flagUserPresent
+		defer func() { a.flags = flagUserPresent | flagUserVerified }()
+		c, authData, sig := assert(a, challenge, origin)
+
+		got, err := rp.VerifyAssertion(c, challenge, cred, authData, sig)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got.UserVerified {
+			t.Error("user verified without the flag")
+		}
+	})
+
+	tests := []struct {
+		name      string
+		count     uint32
+		stored    uint32
+		rpID      string
+		flags     byte
+		uv        bool
+		challenge string
+		origin    string
+		forge     bool
+		want      error
+	}{
+		{name: "counter went back", count: 3, stored: 5, want: ErrInvalidAuthData},
+		{name: "counter repeated", count: 5, stored: 5, want: ErrInvalidAuthData},
+		{name: "counter reset to zero", count: 0, stored: 5, want: ErrInvalidAuthData},
+		{name: "relying party mismatch", count: 6, rpID: "evil.example", want: ErrInvalidAuthData},
+		{name: "user not present", count: 6, flags: flagUserVerified, want: ErrInvalidAuthData},
+		{name: "user not verified", count: 6, flags: flagUserPresent, uv: true, want: ErrInvalidAuthData},
+		{name: "origin mismatch", count: 6, origin: "https://evil.example", want: ErrInvalidClientData},
+		{name: "challenge mismatch", count: 6, challenge: "b3RoZXI", want: ErrInvalidClientData},
+		{name: "signed by another key", count: 6, forge: true, want: ErrInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer := *a
+			signer.count = tt.count
+			if tt.rpID != "" {
+				signer.rpID = tt.rpID
+			}
+			if tt.flags != 0 {
+				signer.flags = tt.flags
+			}
+			if tt.forge {
+				signer.key = newAuthenticator(t).key
+			}
+
+			ch, org := challenge, origin
+			if tt.challenge != "" {
+				ch = tt.challenge
+			}
+			if tt.origin != "" {
+				org = tt.origin
+			}
+			c, authData, sig := assert(&signer, ch, org)
+
+			stored := *cred
+			stored.SignCount = tt.stored
+
+			rp := relyingParty()
+			rp.UserVerification = tt.uv
+
+			if _, err := rp.VerifyAssertion(c, challenge, &stored, authData, sig); !errors.Is(err, tt.want) {
+				t.Fatalf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseClientData(t *testing.T) {
+	if _, err := ParseClientData([]byte(`{"type":"webauthn.get"}`)); !errors.Is(err, ErrInvalidClientData) {
+		t.Errorf("missing challenge: error = %v", err)
+	}
+	if _, err := ParseClientData([]byte(`not json`)); !errors.Is(err, ErrInvalidClientData) {
+		t.Errorf("malformed: error = %v", err)
+	}
+
+	raw := []byte(`{"type":"webauthn.get","challenge":"` + base64.RawURLEncoding.EncodeToString([]byte("x")) + `","origin":"https://example.com","crossOrigin":true}`)
+	c, err := ParseClientData(raw)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := relyingParty().verifyClientData(c, TypeGet, c.Challenge); !errors.Is(err, ErrInvalidClientData) {
+		t.Errorf("cross-origin: error = %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/storage"
+)
+
+func (s *Storage) SaveAPIKey(ctx context.Context, k *models.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kk := copyAPIKey(*k)
+	if kk.CreatedAt.IsZero() {
+		kk.CreatedAt = time.Now()
+	}
+	s.apiKeys[k.ID] = kk
+	s.apiKeyHashes[string(k.Hash)] = k.ID
+	return nil
+}
+
+func (s *Storage) APIKeyByHash(ctx context.Context, hash []byte) (*models.APIKey, error) {
+	const op = "storage.memory.APIKeyByHash"
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.apiKeyHashes[string(hash)]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrAPIKeyNotFound)
+	}
+
+	return copyAPIKey(*s.apiKeys[id]), nil
+}
+
+func (s *Storage) APIKeys(ctx context.Context, userID string) ([]models.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]models.APIKey, 0)
+	for _, k := range s.apiKeys {
+		if k.UserID == userID {
+			keys = append(keys, *copyAPIKey(*k))
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].ID < keys[j].ID
+		}
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	return keys, nil
+}
+
+func (s *Storage) RevokeAPIKey(ctx context.Context, userID string, id string) error {
+	const op = "storage.memory.RevokeAPIKey"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, ok := s.apiKeys[id]
+	if !ok || k.UserID != userID || !k.RevokedAt.IsZero() {
+		return fmt.Errorf("%s: %w", op, storage.ErrAPIKeyNotFound)
+	}
+
+	k.RevokedAt = time.Now()
+	return nil
+}
+
+func (s *Storage) UseAPIKey(ctx context.Context, id string, at time.Time) error {
+	const op = "storage.memory.UseAPIKey"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, ok := s.apiKeys[id]
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrAPIKeyNotFound)
+	}
+
+	k.LastUsedAt = at
+	return nil
+}
+
+// deleteAPIKeys must be called with the lock held.
+func (s *Storage) deleteAPIKeys(userID string) {
+	for id, k := range s.apiKeys {
+		if k.UserID == userID {
+			delete(s.apiKeyHashes, string(k.Hash))
+			delete(s.apiKeys, id)
+		}
+	}
+}
+
+func copyAPIKey(k models.APIKey) *models.APIKey {
+	k.Hash = slices.Clone(k.Hash)
+	k.Scopes = slices.Clone(k.Scopes)
+	return &k
+}
@@ -0,0 +1,104 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/storage"
+)
+
+func (s *Storage) SaveExternalLogin(ctx context.Context, l *models.ExternalLogin) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Expired logins are left by users who never came back from the provider
+	now := time.Now()
+	for h, other := range s.externalLogins {
+		if !now.Before(other.ExpiresAt) {
+			delete(s.externalLogins, h)
+		}
+	}
+
+	ll := *l
+	s.externalLogins[string(l.StateHash)] = &ll
+	return nil
+}
+
+func (s *Storage) ConsumeExternalLogin(ctx context.Context, stateHash []byte) (*models.ExternalLogin, error) {
+	const op = "storage.memory.ConsumeExternalLogin"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, ok := s.externalLogins[string(stateHash)]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrExternalLoginInvalid)
+	}
+
+	delete(s.externalLogins, string(stateHash))
+	if !time.Now().Before(l.ExpiresAt) {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrExternalLoginInvalid)
+	}
+
+	return l, nil
+}
+
+func (s *Storage) Identity(ctx context.Context, issuer, subject string) (*models.Identity, error) {
+	const op = "storage.memory.Identity"
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i, ok := s.identities[identityKey{issuer, subject}]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrIdentityNotFound)
+	}
+
+	ii := *i
+	return &ii, nil
+}
+
+func (s *Storage) SaveIdentity(ctx context.Context, i *models.Identity) error {
+	const op = "storage.memory.SaveIdentity"
+
+	userID, err := strconv.ParseUint(i.UserID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	k := identityKey{i.Issuer, i.Subject}
+	if _, ok := s.identities[k]; ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrIdentityAlreadyExists)
+	}
+
+	ii := *i
+	if ii.CreatedAt.IsZero() {
+		ii.CreatedAt = time.Now()
+	}
+	s.identities[k] = &ii
+	return nil
+}
+
+type identityKey struct {
+	issuer  string
+	subject string
+}
+
+// deleteIdentities must be called with the lock held.
+func (s *Storage) deleteIdentities(userID string) {
+	for k, i := range s.identities {
+		if i.UserID == userID {
+			delete(s.identities, k)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package memory
+
+import (
+	"context"
+	"time"
+)
+
+type loginAttempts struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+func (s *Storage) AddLoginFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	// Keys are made up by clients, e.g. emails that do not exist,
+	// so the stale ones are swept at most once a window
+	if now.Sub(s.loginsSweptAt) > window {
+		s.sweepLogins(now, window)
+	}
+
+	a, ok := s.logins[key]
+	if !ok {
+		a = &loginAttempts{}
+		s.logins[key] = a
+	}
+	if now.Sub(a.lastFailure) > window {
+		a.failures = 0
+	}
+	a.failures++
+	a.lastFailure = now
+
+	return a.failures, nil
+}
+
+func (s *Storage) LockLogin(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.logins[key]
+	if !ok {
+		a = &loginAttempts{}
+		s.logins[key] = a
+	}
+	a.lockedUntil = until
+
+	return nil
+}
+
+func (s *Storage) LoginLockedUntil(ctx context.Context, key string) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, ok := s.logins[key]
+	if !ok || !time.Now().Before(a.lockedUntil) {
+		return time.Time{}, nil
+	}
+
+	return a.lockedUntil, nil
+}
+
+func (s *Storage) ResetLoginFailures(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.logins, key)
+	return nil
+}
+
+// sweepLogins removes the attempts with neither a failure within the window
+// nor a lock in effect. Must be called with the lock held.
+func (s *Storage) sweepLogins(now time.Time, window time.Duration) {
+	for key, a := range s.logins {
+		if now.Sub(a.lastFailure) > window && !now.Before(a.lockedUntil) {
+			delete(s.logins, key)
+		}
+	}
+	s.loginsSweptAt = now
+}
@@ -0,0 +1,310 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/korikhin/auth/internal/config"
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/storage"
+)
+
+// Storage is an in-memory backend intended for tests and local development.
+// It is safe for concurrent use; all data is lost once the process exits.
+type Storage struct {
+	mu     sync.RWMutex
+	opts   storage.Options
+	lastID uint64
+	users  map[uint64]models.User
+	emails map[string]uint64
+	tokens map[string]*models.RefreshToken
+	resets map[string]*resetToken
+	logins map[string]*loginAttempts
+	totps  map[string]*models.TOTP
+	codes  map[string]*recoveryCode
+
+	challenges  map[string]*models.WebAuthnChallenge
+	credentials map[string]*models.WebAuthnCredential
+
+	clients   map[string]*models.Client
+	authCodes map[string]*models.AuthorizationCode
+
+	identities     map[identityKey]*models.Identity
+	externalLogins map[string]*models.ExternalLogin
+
+	apiKeys      map[string]*models.APIKey
+	apiKeyHashes map[string]string
+
+	// loginsSweptAt is when stale login attempts were last removed
+	loginsSweptAt time.Time
+}
+
+var _ storage.Storage = (*Storage)(nil)
+
+func New(config config.Storage) *Storage {
+	opts := storage.Options{
+		ReadTimeout:  config.ReadTimeout,
+		WriteTimeout: config.WriteTimeout,
+	}
+
+	return &Storage{
+		opts:   opts,
+		users:  make(map[uint64]models.User),
+		emails: make(map[string]uint64),
+		tokens: make(map[string]*models.RefreshToken),
+		resets: make(map[string]*resetToken),
+		logins: make(map[string]*loginAttempts),
+		totps:  make(map[string]*models.TOTP),
+		codes:  make(map[string]*recoveryCode),
+
+		challenges:  make(map[string]*models.WebAuthnChallenge),
+		credentials: make(map[string]*models.WebAuthnCredential),
+
+		clients:   make(map[string]*models.Client),
+		authCodes: make(map[string]*models.AuthorizationCode),
+
+		identities:     make(map[identityKey]*models.Identity),
+		externalLogins: make(map[string]*models.ExternalLogin),
+
+		apiKeys:      make(map[string]*models.APIKey),
+		apiKeyHashes: make(map[string]string),
+	}
+}
+
+// Options returns the configured operation timeouts.
+func (s *Storage) Options() storage.Options {
+	return s.opts
+}
+
+// Stop is a no-op kept for parity with other backends.
+func (s *Storage) Stop() {}
+
+func (s *Storage) User(ctx context.Context, id string) (*models.User, error) {
+	const op = "storage.memory.User"
+
+	userID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return copyUser(user), nil
+}
+
+func (s *Storage) UserByEmail(ctx context.Context, email string) (*models.User, error) {
+	const op = "storage.memory.UserByEmail"
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	userID, ok := s.emails[email]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return copyUser(s.users[userID]), nil
+}
+
+func (s *Storage) SaveUser(ctx context.Context, email string, hash []byte) (uint64, error) {
+	const op = "storage.memory.SaveUser"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.emails[email]; ok {
+		return 0, fmt.Errorf("%s: %w", op, storage.ErrUserAlreadyExists)
+	}
+
+	s.lastID++
+	userID := s.lastID
+
+	s.users[userID] = models.User{
+		ID:           strconv.FormatUint(userID, 10),
+		Email:        email,
+		PasswordHash: append([]byte(nil), hash...),
+		Role:         models.RoleUser,
+	}
+	s.emails[email] = userID
+
+	return userID, nil
+}
+
+func (s *Storage) SetUserRole(ctx context.Context, id string, role string, permissions []string) error {
+	const op = "storage.memory.SetUserRole"
+
+	userID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	user.Role = role
+	user.Permissions = append([]string(nil), permissions...)
+	s.users[userID] = user
+
+	return nil
+}
+
+func (s *Storage) Users(ctx context.Context, after uint64, limit int) ([]models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]uint64, 0, len(s.users))
+	for id := range s.users {
+		if id > after {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	users := make([]models.User, 0, len(ids))
+	for _, id := range ids {
+		user := copyUser(s.users[id])
+		user.PasswordHash = nil
+		users = append(users, *user)
+	}
+
+	return users, nil
+}
+
+func (s *Storage) UpdateUserEmail(ctx context.Context, id string, email string) error {
+	const op = "storage.memory.UpdateUserEmail"
+
+	userID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+	if other, ok := s.emails[email]; ok && other != userID {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserAlreadyExists)
+	}
+
+	delete(s.emails, user.Email)
+	s.emails[email] = userID
+	user.EmailVerified = user.EmailVerified && user.Email == email
+	user.Email = email
+	s.users[userID] = user
+
+	return nil
+}
+
+func (s *Storage) VerifyUserEmail(ctx context.Context, id string, email string) error {
+	const op = "storage.memory.VerifyUserEmail"
+
+	userID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok || user.Email != email {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	user.EmailVerified = true
+	s.users[userID] = user
+
+	return nil
+}
+
+func (s *Storage) UpdateUserPassword(ctx context.Context, id string, hash []byte) error {
+	const op = "storage.memory.UpdateUserPassword"
+
+	userID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	user.PasswordHash = append([]byte(nil), hash...)
+	s.users[userID] = user
+
+	return nil
+}
+
+func (s *Storage) DeleteUser(ctx context.Context, id string) error {
+	const op = "storage.memory.DeleteUser"
+
+	userID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	delete(s.users, userID)
+	delete(s.emails, user.Email)
+	for tid, t := range s.tokens {
+		if t.UserID == id {
+			delete(s.tokens, tid)
+		}
+	}
+	for h, t := range s.resets {
+		if t.UserID == id {
+			delete(s.resets, h)
+		}
+	}
+	s.deleteMFA(id)
+	s.deleteWebAuthn(id)
+	s.deleteAuthorizationCodes(id)
+	s.deleteIdentities(id)
+	s.deleteAPIKeys(id)
+
+	return nil
+}
+
+func (s *Storage) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// copyUser detaches the returned user from the stored one,
+// so callers cannot mutate the storage state by accident.
+func copyUser(u models.User) *models.User {
+	u.PasswordHash = append([]byte(nil), u.PasswordHash...)
+	u.Permissions = append([]string(nil), u.Permissions...)
+	return &u
+}
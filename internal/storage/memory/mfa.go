@@ -0,0 +1,123 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/storage"
+)
+
+type recoveryCode struct {
+	userID string
+	used   bool
+}
+
+func (s *Storage) SaveTOTP(ctx context.Context, userID string, secret string) error {
+	const op = "storage.memory.SaveTOTP"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.totps[userID]; ok && t.Confirmed {
+		return fmt.Errorf("%s: %w", op, storage.ErrMFAAlreadyEnabled)
+	}
+
+	s.totps[userID] = &models.TOTP{UserID: userID, Secret: secret}
+	return nil
+}
+
+func (s *Storage) TOTP(ctx context.Context, userID string) (*models.TOTP, error) {
+	const op = "storage.memory.TOTP"
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.totps[userID]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrMFANotFound)
+	}
+
+	c := *t
+	return &c, nil
+}
+
+func (s *Storage) ConfirmTOTP(ctx context.Context, userID string, step int64, recoveryCodes [][]byte) error {
+	const op = "storage.memory.ConfirmTOTP"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.totps[userID]
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrMFANotFound)
+	}
+	if t.Confirmed {
+		return fmt.Errorf("%s: %w", op, storage.ErrMFAAlreadyEnabled)
+	}
+
+	t.Confirmed = true
+	t.LastStep = step
+
+	s.deleteRecoveryCodes(userID)
+	for _, h := range recoveryCodes {
+		s.codes[string(h)] = &recoveryCode{userID: userID}
+	}
+
+	return nil
+}
+
+func (s *Storage) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	const op = "storage.memory.UseTOTPStep"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.totps[userID]
+	if !ok || !t.Confirmed {
+		return fmt.Errorf("%s: %w", op, storage.ErrMFANotFound)
+	}
+	if step <= t.LastStep {
+		return fmt.Errorf("%s: %w", op, storage.ErrMFACodeUsed)
+	}
+
+	t.LastStep = step
+	return nil
+}
+
+func (s *Storage) UseRecoveryCode(ctx context.Context, userID string, hash []byte) error {
+	const op = "storage.memory.UseRecoveryCode"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.codes[string(hash)]
+	if !ok || c.used || c.userID != userID {
+		return fmt.Errorf("%s: %w", op, storage.ErrMFACodeUsed)
+	}
+
+	c.used = true
+	return nil
+}
+
+func (s *Storage) DeleteMFA(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteMFA(userID)
+	return nil
+}
+
+// deleteMFA must be called with the lock held.
+func (s *Storage) deleteMFA(userID string) {
+	delete(s.totps, userID)
+	s.deleteRecoveryCodes(userID)
+}
+
+func (s *Storage) deleteRecoveryCodes(userID string) {
+	for h, c := range s.codes {
+		if c.userID == userID {
+			delete(s.codes, h)
+		}
+	}
+}
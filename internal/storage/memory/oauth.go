@@ -0,0 +1,125 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/storage"
+)
+
+func (s *Storage) SaveClient(ctx context.Context, c *models.Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clients[c.ID] = copyClient(*c)
+	return nil
+}
+
+func (s *Storage) Client(ctx context.Context, id string) (*models.Client, error) {
+	const op = "storage.memory.Client"
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.clients[id]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrClientNotFound)
+	}
+
+	return copyClient(*c), nil
+}
+
+func (s *Storage) Clients(ctx context.Context) ([]models.Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	clients := make([]models.Client, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, *copyClient(*c))
+	}
+
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ID < clients[j].ID
+	})
+
+	return clients, nil
+}
+
+func (s *Storage) DeleteClient(ctx context.Context, id string) error {
+	const op = "storage.memory.DeleteClient"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.clients[id]; !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrClientNotFound)
+	}
+
+	delete(s.clients, id)
+	for h, c := range s.authCodes {
+		if c.ClientID == id {
+			delete(s.authCodes, h)
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveAuthorizationCode(ctx context.Context, c *models.AuthorizationCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Expired codes are left by clients that never exchanged them
+	now := time.Now()
+	for h, other := range s.authCodes {
+		if !now.Before(other.ExpiresAt) {
+			delete(s.authCodes, h)
+		}
+	}
+
+	cc := *c
+	cc.AMR = slices.Clone(c.AMR)
+	s.authCodes[string(c.Hash)] = &cc
+	return nil
+}
+
+func (s *Storage) ConsumeAuthorizationCode(ctx context.Context, hash []byte) (*models.AuthorizationCode, error) {
+	const op = "storage.memory.ConsumeAuthorizationCode"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.authCodes[string(hash)]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrAuthorizationCodeInvalid)
+	}
+
+	delete(s.authCodes, string(hash))
+	if !time.Now().Before(c.ExpiresAt) {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrAuthorizationCodeInvalid)
+	}
+
+	return c, nil
+}
+
+// deleteAuthorizationCodes must be called with the lock held.
+func (s *Storage) deleteAuthorizationCodes(userID string) {
+	for h, c := range s.authCodes {
+		if c.UserID == userID {
+			delete(s.authCodes, h)
+		}
+	}
+}
+
+func copyClient(c models.Client) *models.Client {
+	c.SecretHash = slices.Clone(c.SecretHash)
+	c.RedirectURIs = slices.Clone(c.RedirectURIs)
+	c.GrantTypes = slices.Clone(c.GrantTypes)
+	c.Scopes = slices.Clone(c.Scopes)
+
+	return &c
+}
@@ -0,0 +1,43 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/storage"
+)
+
+type resetToken struct {
+	models.ResetToken
+	used bool
+}
+
+func (s *Storage) SaveResetToken(ctx context.Context, t *models.ResetToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.resets[string(t.Hash)] = &resetToken{ResetToken: *t}
+	return nil
+}
+
+func (s *Storage) ConsumeResetToken(ctx context.Context, hash []byte) (string, error) {
+	const op = "storage.memory.ConsumeResetToken"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.resets[string(hash)]
+	if !ok || t.used || !time.Now().Before(t.ExpiresAt) {
+		return "", fmt.Errorf("%s: %w", op, storage.ErrResetTokenInvalid)
+	}
+
+	for _, other := range s.resets {
+		if other.UserID == t.UserID {
+			other.used = true
+		}
+	}
+
+	return t.UserID, nil
+}
@@ -0,0 +1,103 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/storage"
+)
+
+func (s *Storage) SaveRefreshToken(ctx context.Context, t *models.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rt := *t
+	s.tokens[t.ID] = &rt
+	return nil
+}
+
+func (s *Storage) RotateRefreshToken(ctx context.Context, id string, next *models.RefreshToken) error {
+	const op = "storage.memory.RotateRefreshToken"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[id]
+	switch {
+	case !ok || t.FamilyID != next.FamilyID:
+		return fmt.Errorf("%s: %w", op, storage.ErrRefreshTokenNotFound)
+	case !t.RevokedAt.IsZero():
+		return fmt.Errorf("%s: %w", op, storage.ErrRefreshTokenRevoked)
+	case !t.RotatedAt.IsZero():
+		return fmt.Errorf("%s: %w", op, storage.ErrRefreshTokenReused)
+	}
+
+	t.RotatedAt = time.Now()
+	rt := *next
+	s.tokens[next.ID] = &rt
+
+	return nil
+}
+
+func (s *Storage) RefreshToken(ctx context.Context, id string) (*models.RefreshToken, error) {
+	const op = "storage.memory.RefreshToken"
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.tokens[id]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrRefreshTokenNotFound)
+	}
+
+	rt := *t
+	return &rt, nil
+}
+
+func (s *Storage) FamilyRevoked(ctx context.Context, familyID string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found := false
+	for _, t := range s.tokens {
+		if t.FamilyID != familyID {
+			continue
+		}
+		if !t.RevokedAt.IsZero() {
+			return true, nil
+		}
+		found = true
+	}
+
+	return !found, nil
+}
+
+func (s *Storage) RevokeUserTokens(ctx context.Context, userID string, keep string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, t := range s.tokens {
+		if t.UserID == userID && t.FamilyID != keep && t.RevokedAt.IsZero() {
+			t.RevokedAt = now
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) RevokeTokenFamily(ctx context.Context, familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, t := range s.tokens {
+		if t.FamilyID == familyID && t.RevokedAt.IsZero() {
+			t.RevokedAt = now
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/storage"
+)
+
+func (s *Storage) SaveWebAuthnChallenge(ctx context.Context, c *models.WebAuthnChallenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Expired challenges are left by abandoned ceremonies
+	now := time.Now()
+	for h, other := range s.challenges {
+		if !now.Before(other.ExpiresAt) {
+			delete(s.challenges, h)
+		}
+	}
+
+	cc := *c
+	s.challenges[string(c.Hash)] = &cc
+	return nil
+}
+
+func (s *Storage) ConsumeWebAuthnChallenge(ctx context.Context, hash []byte) (*models.WebAuthnChallenge, error) {
+	const op = "storage.memory.ConsumeWebAuthnChallenge"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.challenges[string(hash)]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrChallengeInvalid)
+	}
+
+	delete(s.challenges, string(hash))
+	if !time.Now().Before(c.ExpiresAt) {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrChallengeInvalid)
+	}
+
+	return c, nil
+}
+
+func (s *Storage) SaveWebAuthnCredential(ctx context.Context, c *models.WebAuthnCredential) error {
+	const op = "storage.memory.SaveWebAuthnCredential"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.credentials[string(c.ID)]; ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrCredentialAlreadyExists)
+	}
+
+	cc := *c
+	cc.CreatedAt = time.Now()
+	s.credentials[string(c.ID)] = &cc
+	return nil
+}
+
+func (s *Storage) WebAuthnCredential(ctx context.Context, id []byte) (*models.WebAuthnCredential, error) {
+	const op = "storage.memory.WebAuthnCredential"
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.credentials[string(id)]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrCredentialNotFound)
+	}
+
+	cc := *c
+	return &cc, nil
+}
+
+func (s *Storage) WebAuthnCredentials(ctx context.Context, userID string) ([]models.WebAuthnCredential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	creds := make([]models.WebAuthnCredential, 0)
+	for _, c := range s.credentials {
+		if c.UserID == userID {
+			creds = append(creds, *c)
+		}
+	}
+
+	sort.Slice(creds, func(i, j int) bool {
+		if creds[i].CreatedAt.Equal(creds[j].CreatedAt) {
+			return bytes.Compare(creds[i].ID, creds[j].ID) < 0
+		}
+		return creds[i].CreatedAt.Before(creds[j].CreatedAt)
+	})
+
+	return creds, nil
+}
+
+func (s *Storage) UseWebAuthnCredential(ctx context.Context, id []byte, signCount uint32) error {
+	const op = "storage.memory.UseWebAuthnCredential"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.credentials[string(id)]
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrCredentialNotFound)
+	}
+
+	c.SignCount = signCount
+	c.LastUsedAt = time.Now()
+	return nil
+}
+
+func (s *Storage) DeleteWebAuthnCredential(ctx context.Context, userID string, id []byte) error {
+	const op = "storage.memory.DeleteWebAuthnCredential"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.credentials[string(id)]
+	if !ok || c.UserID != userID {
+		return fmt.Errorf("%s: %w", op, storage.ErrCredentialNotFound)
+	}
+
+	delete(s.credentials, string(id))
+	return nil
+}
+
+// deleteWebAuthn must be called with the lock held.
+func (s *Storage) deleteWebAuthn(userID string) {
+	for id, c := range s.credentials {
+		if c.UserID == userID {
+			delete(s.credentials, id)
+		}
+	}
+	for h, c := range s.challenges {
+		if c.UserID == userID {
+			delete(s.challenges, h)
+		}
+	}
+}
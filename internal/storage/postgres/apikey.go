@@ -0,0 +1,183 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/storage"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func (s *Storage) SaveAPIKey(ctx context.Context, k *models.APIKey) error {
+	const op = "storage.postgres.SaveAPIKey"
+
+	userID, err := strconv.ParseUint(k.UserID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	query := `
+		insert into public.api_keys(id, user_id, name, prefix, hash, scopes, expires_at)
+		values (@id, @user_id, @name, @prefix, @hash, @scopes, @expires_at);
+	`
+	args := pgx.NamedArgs{
+		"id":         k.ID,
+		"user_id":    userID,
+		"name":       k.Name,
+		"prefix":     k.Prefix,
+		"hash":       k.Hash,
+		"scopes":     nonNil(k.Scopes),
+		"expires_at": pgtype.Timestamptz{Time: k.ExpiresAt, Valid: !k.ExpiresAt.IsZero()},
+	}
+
+	if _, err := s.pool.Exec(ctx, query, args); err != nil {
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+const apiKeyColumns = `id, user_id, name, prefix, hash, scopes, expires_at, last_used_at, revoked_at, created_at`
+
+func scanAPIKey(row pgx.Row) (*models.APIKey, error) {
+	k := &models.APIKey{}
+	var userID uint64
+	var expiresAt, lastUsed, revokedAt pgtype.Timestamptz
+
+	err := row.Scan(&k.ID, &userID, &k.Name, &k.Prefix, &k.Hash, &k.Scopes, &expiresAt, &lastUsed, &revokedAt, &k.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	k.UserID = strconv.FormatUint(userID, 10)
+	k.ExpiresAt = expiresAt.Time
+	k.LastUsedAt = lastUsed.Time
+	k.RevokedAt = revokedAt.Time
+
+	return k, nil
+}
+
+func (s *Storage) APIKeyByHash(ctx context.Context, hash []byte) (*models.APIKey, error) {
+	const op = "storage.postgres.APIKeyByHash"
+
+	query := `
+		select ` + apiKeyColumns + `
+		from public.api_keys
+		where hash = @hash;
+	`
+	args := pgx.NamedArgs{
+		"hash": hash,
+	}
+
+	k, err := scanAPIKey(s.pool.QueryRow(ctx, query, args))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrAPIKeyNotFound)
+		}
+		err = sanitizeError(err)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return k, nil
+}
+
+func (s *Storage) APIKeys(ctx context.Context, userID string) ([]models.APIKey, error) {
+	const op = "storage.postgres.APIKeys"
+
+	id, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	query := `
+		select ` + apiKeyColumns + `
+		from public.api_keys
+		where user_id = @user_id
+		order by created_at, id;
+	`
+	args := pgx.NamedArgs{
+		"user_id": id,
+	}
+
+	rows, err := s.pool.Query(ctx, query, args)
+	if err != nil {
+		err = sanitizeError(err)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	keys, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.APIKey, error) {
+		k, err := scanAPIKey(row)
+		if err != nil {
+			return models.APIKey{}, err
+		}
+		return *k, nil
+	})
+	if err != nil {
+		err = sanitizeError(err)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return keys, nil
+}
+
+func (s *Storage) RevokeAPIKey(ctx context.Context, userID string, id string) error {
+	const op = "storage.postgres.RevokeAPIKey"
+
+	uid, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	query := `
+		update public.api_keys
+		set revoked_at = now()
+		where id = @id and user_id = @user_id and revoked_at is null;
+	`
+	args := pgx.NamedArgs{
+		"id":      id,
+		"user_id": uid,
+	}
+
+	tag, err := s.pool.Exec(ctx, query, args)
+	if err != nil {
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAPIKeyNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) UseAPIKey(ctx context.Context, id string, at time.Time) error {
+	const op = "storage.postgres.UseAPIKey"
+
+	query := `
+		update public.api_keys
+		set last_used_at = @at
+		where id = @id;
+	`
+	args := pgx.NamedArgs{
+		"id": id,
+		"at": at,
+	}
+
+	tag, err := s.pool.Exec(ctx, query, args)
+	if err != nil {
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAPIKeyNotFound)
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/storage"
+
+	codes "github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func (s *Storage) SaveExternalLogin(ctx context.Context, l *models.ExternalLogin) error {
+	const op = "storage.postgres.SaveExternalLogin"
+
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		// Expired logins are left by users who never came back from the provider
+		query := `
+			delete from public.external_logins
+			where expires_at <= now();
+		`
+		if _, err := tx.Exec(ctx, query); err != nil {
+			return err
+		}
+
+		query = `
+			insert into public.external_logins(state_hash, provider, nonce, code_verifier, expires_at)
+			values (@state_hash, @provider, @nonce, @code_verifier, @expires_at);
+		`
+		args := pgx.NamedArgs{
+			"state_hash":    l.StateHash,
+			"provider":      l.Provider,
+			"nonce":         l.Nonce,
+			"code_verifier": l.CodeVerifier,
+			"expires_at":    l.ExpiresAt,
+		}
+
+		_, err := tx.Exec(ctx, query, args)
+		return err
+	})
+	if err != nil {
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) ConsumeExternalLogin(ctx context.Context, stateHash []byte) (*models.ExternalLogin, error) {
+	const op = "storage.postgres.ConsumeExternalLogin"
+
+	query := `
+		delete from public.external_logins
+		where state_hash = @state_hash
+		returning provider, nonce, code_verifier, expires_at, expires_at > now();
+	`
+	args := pgx.NamedArgs{
+		"state_hash": stateHash,
+	}
+
+	l := &models.ExternalLogin{StateHash: stateHash}
+	var active bool
+	err := s.pool.QueryRow(ctx, query, args).Scan(&l.Provider, &l.Nonce, &l.CodeVerifier, &l.ExpiresAt, &active)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrExternalLoginInvalid)
+		}
+		err = sanitizeError(err)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if !active {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrExternalLoginInvalid)
+	}
+
+	return l, nil
+}
+
+func (s *Storage) Identity(ctx context.Context, issuer, subject string) (*models.Identity, error) {
+	const op = "storage.postgres.Identity"
+
+	query := `
+		select user_id, provider, email, created_at
+		from public.identities
+		where issuer = @issuer and subject = @subject;
+	`
+	args := pgx.NamedArgs{
+		"issuer":  issuer,
+		"subject": subject,
+	}
+
+	i := &models.Identity{Issuer: issuer, Subject: subject}
+	var userID uint64
+	err := s.pool.QueryRow(ctx, query, args).Scan(&userID, &i.Provider, &i.Email, &i.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrIdentityNotFound)
+		}
+		err = sanitizeError(err)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	i.UserID = strconv.FormatUint(userID, 10)
+	return i, nil
+}
+
+func (s *Storage) SaveIdentity(ctx context.Context, i *models.Identity) error {
+	const op = "storage.postgres.SaveIdentity"
+
+	userID, err := strconv.ParseUint(i.UserID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	query := `
+		insert into public.identities(issuer, subject, user_id, provider, email)
+		values (@issuer, @subject, @user_id, @provider, @email);
+	`
+	args := pgx.NamedArgs{
+		"issuer":   i.Issuer,
+		"subject":  i.Subject,
+		"user_id":  userID,
+		"provider": i.Provider,
+		"email":    i.Email,
+	}
+
+	if _, err := s.pool.Exec(ctx, query, args); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			switch pgErr.Code {
+			case codes.UniqueViolation:
+				return fmt.Errorf("%s: %w", op, storage.ErrIdentityAlreadyExists)
+			case codes.ForeignKeyViolation:
+				return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+			}
+		}
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
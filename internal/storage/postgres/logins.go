@@ -0,0 +1,144 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *Storage) AddLoginFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	const op = "storage.postgres.AddLoginFailure"
+
+	// Keys are made up by clients, e.g. emails that do not exist,
+	// so the stale ones are swept at most once a window
+	if s.sweepLoginsDue(window) {
+		if err := s.sweepLogins(ctx, window); err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	query := `
+		insert into public.login_attempts as a(key, failures, last_failure_at)
+		values (@key, 1, now())
+		on conflict (key) do update
+		set failures = case
+				when a.last_failure_at < now() - @window::interval then 1
+				else a.failures + 1
+			end,
+			last_failure_at = now()
+		returning failures;
+	`
+	args := pgx.NamedArgs{
+		"key":    key,
+		"window": window,
+	}
+
+	var failures int
+	if err := s.pool.QueryRow(ctx, query, args).Scan(&failures); err != nil {
+		err = sanitizeError(err)
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return failures, nil
+}
+
+// sweepLoginsDue reports whether a window passed since the last sweep,
+// and if so claims the sweep so that concurrent failures skip it.
+func (s *Storage) sweepLoginsDue(window time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.loginsSweptAt) <= window {
+		return false
+	}
+	s.loginsSweptAt = now
+
+	return true
+}
+
+// sweepLogins removes the attempts out of the window that are not locked.
+func (s *Storage) sweepLogins(ctx context.Context, window time.Duration) error {
+	query := `
+		delete from public.login_attempts
+		where last_failure_at < now() - @window::interval
+			and (locked_until is null or locked_until <= now());
+	`
+	args := pgx.NamedArgs{
+		"window": window,
+	}
+
+	if _, err := s.pool.Exec(ctx, query, args); err != nil {
+		return sanitizeError(err)
+	}
+
+	return nil
+}
+
+func (s *Storage) LockLogin(ctx context.Context, key string, until time.Time) error {
+	const op = "storage.postgres.LockLogin"
+
+	query := `
+		insert into public.login_attempts(key, locked_until)
+		values (@key, @until)
+		on conflict (key) do update
+		set locked_until = @until;
+	`
+	args := pgx.NamedArgs{
+		"key":   key,
+		"until": until,
+	}
+
+	if _, err := s.pool.Exec(ctx, query, args); err != nil {
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) LoginLockedUntil(ctx context.Context, key string) (time.Time, error) {
+	const op = "storage.postgres.LoginLockedUntil"
+
+	query := `
+		select locked_until
+		from public.login_attempts
+		where key = @key and locked_until > now();
+	`
+	args := pgx.NamedArgs{
+		"key": key,
+	}
+
+	var until time.Time
+	if err := s.pool.QueryRow(ctx, query, args).Scan(&until); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return time.Time{}, nil
+		}
+		err = sanitizeError(err)
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return until, nil
+}
+
+func (s *Storage) ResetLoginFailures(ctx context.Context, key string) error {
+	const op = "storage.postgres.ResetLoginFailures"
+
+	query := `
+		delete from public.login_attempts
+		where key = @key;
+	`
+	args := pgx.NamedArgs{
+		"key": key,
+	}
+
+	if _, err := s.pool.Exec(ctx, query, args); err != nil {
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
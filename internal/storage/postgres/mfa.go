@@ -0,0 +1,232 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/storage"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *Storage) SaveTOTP(ctx context.Context, userID string, secret string) error {
+	const op = "storage.postgres.SaveTOTP"
+
+	id, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	query := `
+		insert into public.user_totp as t(user_id, secret)
+		values (@user_id, @secret)
+		on conflict (user_id) do update
+		set secret = excluded.secret, last_step = 0, created_at = now()
+		where t.confirmed_at is null;
+	`
+	args := pgx.NamedArgs{
+		"user_id": id,
+		"secret":  secret,
+	}
+
+	tag, err := s.pool.Exec(ctx, query, args)
+	if err != nil {
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrMFAAlreadyEnabled)
+	}
+
+	return nil
+}
+
+func (s *Storage) TOTP(ctx context.Context, userID string) (*models.TOTP, error) {
+	const op = "storage.postgres.TOTP"
+
+	id, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	query := `
+		select secret, confirmed_at is not null, last_step
+		from public.user_totp
+		where user_id = @user_id;
+	`
+	args := pgx.NamedArgs{
+		"user_id": id,
+	}
+
+	t := &models.TOTP{UserID: userID}
+	err = s.pool.QueryRow(ctx, query, args).Scan(&t.Secret, &t.Confirmed, &t.LastStep)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrMFANotFound)
+		}
+		err = sanitizeError(err)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return t, nil
+}
+
+func (s *Storage) ConfirmTOTP(ctx context.Context, userID string, step int64, recoveryCodes [][]byte) error {
+	const op = "storage.postgres.ConfirmTOTP"
+
+	id, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		query := `
+			update public.user_totp
+			set confirmed_at = now(), last_step = @step
+			where user_id = @user_id and confirmed_at is null;
+		`
+		args := pgx.NamedArgs{
+			"user_id": id,
+			"step":    step,
+		}
+
+		tag, err := tx.Exec(ctx, query, args)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return storage.ErrMFAAlreadyEnabled
+		}
+
+		return replaceRecoveryCodes(ctx, tx, id, recoveryCodes)
+	})
+	if err != nil {
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID uint64, hashes [][]byte) error {
+	query := `
+		delete from public.mfa_recovery_codes
+		where user_id = @user_id;
+	`
+	if _, err := tx.Exec(ctx, query, pgx.NamedArgs{"user_id": userID}); err != nil {
+		return err
+	}
+
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	rows := make([][]any, 0, len(hashes))
+	for _, h := range hashes {
+		rows = append(rows, []any{h, userID})
+	}
+
+	_, err := tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"public", "mfa_recovery_codes"},
+		[]string{"hash", "user_id"},
+		pgx.CopyFromRows(rows),
+	)
+
+	return err
+}
+
+func (s *Storage) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	const op = "storage.postgres.UseTOTPStep"
+
+	id, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	query := `
+		update public.user_totp
+		set last_step = @step
+		where user_id = @user_id and confirmed_at is not null and last_step < @step;
+	`
+	args := pgx.NamedArgs{
+		"user_id": id,
+		"step":    step,
+	}
+
+	tag, err := s.pool.Exec(ctx, query, args)
+	if err != nil {
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrMFACodeUsed)
+	}
+
+	return nil
+}
+
+func (s *Storage) UseRecoveryCode(ctx context.Context, userID string, hash []byte) error {
+	const op = "storage.postgres.UseRecoveryCode"
+
+	id, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	query := `
+		update public.mfa_recovery_codes
+		set used_at = now()
+		where hash = @hash and user_id = @user_id and used_at is null;
+	`
+	args := pgx.NamedArgs{
+		"user_id": id,
+		"hash":    hash,
+	}
+
+	tag, err := s.pool.Exec(ctx, query, args)
+	if err != nil {
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrMFACodeUsed)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteMFA(ctx context.Context, userID string) error {
+	const op = "storage.postgres.DeleteMFA"
+
+	id, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		if err := replaceRecoveryCodes(ctx, tx, id, nil); err != nil {
+			return err
+		}
+
+		query := `
+			delete from public.user_totp
+			where user_id = @user_id;
+		`
+		args := pgx.NamedArgs{
+			"user_id": id,
+		}
+
+		_, err := tx.Exec(ctx, query, args)
+		return err
+	})
+	if err != nil {
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,309 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/korikhin/auth/internal/storage"
+	"github.com/korikhin/auth/migrations"
+
+	codes "github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Advisory lock key shared by all replicas, so only one of them
+// migrates the schema at a time. Spells "auth" in ASCII.
+const migrationLockKey int64 = 0x61757468
+
+const (
+	suffixUp   = ".up.sql"
+	suffixDown = ".down.sql"
+)
+
+type migration struct {
+	storage.Migration
+	up   string
+	down string
+}
+
+var _ storage.Migrator = (*Storage)(nil)
+
+func loadMigrations(fsys fs.FS) ([]migration, error) {
+	const op = "storage.postgres.loadMigrations"
+
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	byVersion := make(map[uint64]*migration)
+	for _, f := range files {
+		var base, suffix string
+		switch {
+		case strings.HasSuffix(f, suffixUp):
+			base, suffix = strings.TrimSuffix(f, suffixUp), suffixUp
+		case strings.HasSuffix(f, suffixDown):
+			base, suffix = strings.TrimSuffix(f, suffixDown), suffixDown
+		default:
+			return nil, fmt.Errorf("%s: unexpected file %s", op, f)
+		}
+
+		v, name, found := strings.Cut(path.Base(base), "_")
+		version, err := strconv.ParseUint(v, 10, 64)
+		if !found || err != nil || version == 0 {
+			return nil, fmt.Errorf("%s: invalid migration name %s", op, f)
+		}
+
+		data, err := fs.ReadFile(fsys, f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{Migration: storage.Migration{Version: version, Name: name}}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("%s: conflicting names for version %d", op, version)
+		}
+
+		if suffix == suffixUp {
+			m.up = string(data)
+		} else {
+			m.down = string(data)
+		}
+	}
+
+	ms := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, fmt.Errorf("%s: missing up migration for version %d", op, m.Version)
+		}
+		ms = append(ms, *m)
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+
+	return ms, nil
+}
+
+// withMigrationLock runs f on a dedicated connection holding the advisory lock
+// and makes sure the tracking table exists.
+func (s *Storage) withMigrationLock(ctx context.Context, f func(conn *pgxpool.Conn) error) error {
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return sanitizeError(err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "select pg_advisory_lock($1);", migrationLockKey); err != nil {
+		return sanitizeError(err)
+	}
+	defer func() {
+		// The lock is released with the session anyway, so the error is of no interest
+		_, _ = conn.Exec(context.Background(), "select pg_advisory_unlock($1);", migrationLockKey)
+	}()
+
+	query := `
+		create table if not exists public.schema_migrations (
+			version    bigint      primary key,
+			name       text        not null,
+			applied_at timestamptz not null default now()
+		);
+	`
+	if _, err := conn.Exec(ctx, query); err != nil {
+		return sanitizeError(err)
+	}
+
+	return f(conn)
+}
+
+// querier is either a pooled connection or the pool itself.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+func appliedMigrations(ctx context.Context, q querier) (map[uint64]time.Time, error) {
+	rows, err := q.Query(ctx, "select version, applied_at from public.schema_migrations;")
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[uint64]time.Time)
+	var version uint64
+	var appliedAt time.Time
+	_, err = pgx.ForEachRow(rows, []any{&version, &appliedAt}, func() error {
+		applied[version] = appliedAt
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return applied, nil
+}
+
+func (s *Storage) MigrateUp(ctx context.Context) ([]storage.Migration, error) {
+	const op = "storage.postgres.MigrateUp"
+
+	ms, err := loadMigrations(migrations.FS)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var done []storage.Migration
+	err = s.withMigrationLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range ms {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+
+			err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, m.up); err != nil {
+					return err
+				}
+
+				query := `
+					insert into public.schema_migrations(version, name)
+					values (@version, @name);
+				`
+				args := pgx.NamedArgs{
+					"version": m.Version,
+					"name":    m.Name,
+				}
+				_, err := tx.Exec(ctx, query, args)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("version %d: %w", m.Version, err)
+			}
+
+			done = append(done, m.Migration)
+		}
+
+		return nil
+	})
+	if err != nil {
+		err = sanitizeError(err)
+		return done, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return done, nil
+}
+
+func (s *Storage) MigrateDown(ctx context.Context) (*storage.Migration, error) {
+	const op = "storage.postgres.MigrateDown"
+
+	ms, err := loadMigrations(migrations.FS)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var done *storage.Migration
+	err = s.withMigrationLock(ctx, func(conn *pgxpool.Conn) error {
+		var version uint64
+		query := "select coalesce(max(version), 0) from public.schema_migrations;"
+		if err := conn.QueryRow(ctx, query).Scan(&version); err != nil {
+			return err
+		}
+		if version == 0 {
+			return storage.ErrNoMigrations
+		}
+
+		i := sort.Search(len(ms), func(i int) bool { return ms[i].Version >= version })
+		if i == len(ms) || ms[i].Version != version {
+			return fmt.Errorf("version %d is not known to this binary", version)
+		}
+		m := ms[i]
+
+		err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			if m.down != "" {
+				if _, err := tx.Exec(ctx, m.down); err != nil {
+					return err
+				}
+			}
+
+			query := "delete from public.schema_migrations where version = @version;"
+			_, err := tx.Exec(ctx, query, pgx.NamedArgs{"version": m.Version})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("version %d: %w", m.Version, err)
+		}
+
+		done = &m.Migration
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, storage.ErrNoMigrations) {
+			err = sanitizeError(err)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return done, nil
+}
+
+func (s *Storage) MigrationStatus(ctx context.Context) ([]storage.MigrationState, error) {
+	const op = "storage.postgres.MigrationStatus"
+
+	ms, err := loadMigrations(migrations.FS)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Status checks neither wait for a running migration nor touch the schema
+	applied, err := appliedMigrations(ctx, s.pool)
+	if err != nil && !isUndefinedTable(err) {
+		err = sanitizeError(err)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	states := make([]storage.MigrationState, 0, len(ms))
+	for _, m := range ms {
+		appliedAt, ok := applied[m.Version]
+		states = append(states, storage.MigrationState{
+			Migration: m.Migration,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+	}
+
+	return states, nil
+}
+
+func (s *Storage) SchemaVersion(ctx context.Context) (uint64, error) {
+	const op = "storage.postgres.SchemaVersion"
+
+	var version uint64
+	query := "select coalesce(max(version), 0) from public.schema_migrations;"
+	if err := s.pool.QueryRow(ctx, query).Scan(&version); err != nil {
+		// No migration has been applied yet
+		if isUndefinedTable(err) {
+			return 0, nil
+		}
+		err = sanitizeError(err)
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return version, nil
+}
+
+func isUndefinedTable(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == codes.UndefinedTable
+}
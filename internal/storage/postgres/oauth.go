@@ -0,0 +1,224 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/storage"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func (s *Storage) SaveClient(ctx context.Context, c *models.Client) error {
+	const op = "storage.postgres.SaveClient"
+
+	query := `
+		insert into public.oauth_clients(id, secret_hash, name, redirect_uris, grant_types, scopes, created_at)
+		values (@id, @secret_hash, @name, @redirect_uris, @grant_types, @scopes, @created_at);
+	`
+	args := pgx.NamedArgs{
+		"id":            c.ID,
+		"secret_hash":   c.SecretHash,
+		"name":          c.Name,
+		"redirect_uris": nonNil(c.RedirectURIs),
+		"grant_types":   nonNil(c.GrantTypes),
+		"scopes":        nonNil(c.Scopes),
+		"created_at":    c.CreatedAt,
+	}
+
+	if _, err := s.pool.Exec(ctx, query, args); err != nil {
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+const clientColumns = `id, secret_hash, name, redirect_uris, grant_types, scopes, created_at`
+
+func scanClient(row pgx.Row) (*models.Client, error) {
+	c := &models.Client{}
+	err := row.Scan(&c.ID, &c.SecretHash, &c.Name, &c.RedirectURIs, &c.GrantTypes, &c.Scopes, &c.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (s *Storage) Client(ctx context.Context, id string) (*models.Client, error) {
+	const op = "storage.postgres.Client"
+
+	query := `
+		select ` + clientColumns + `
+		from public.oauth_clients
+		where id = @id;
+	`
+	args := pgx.NamedArgs{
+		"id": id,
+	}
+
+	c, err := scanClient(s.pool.QueryRow(ctx, query, args))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrClientNotFound)
+		}
+		err = sanitizeError(err)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return c, nil
+}
+
+func (s *Storage) Clients(ctx context.Context) ([]models.Client, error) {
+	const op = "storage.postgres.Clients"
+
+	query := `
+		select ` + clientColumns + `
+		from public.oauth_clients
+		order by id;
+	`
+
+	rows, err := s.pool.Query(ctx, query)
+	if err != nil {
+		err = sanitizeError(err)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	clients, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.Client, error) {
+		c, err := scanClient(row)
+		if err != nil {
+			return models.Client{}, err
+		}
+		return *c, nil
+	})
+	if err != nil {
+		err = sanitizeError(err)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return clients, nil
+}
+
+func (s *Storage) DeleteClient(ctx context.Context, id string) error {
+	const op = "storage.postgres.DeleteClient"
+
+	// Codes are removed by the cascade
+	query := `
+		delete from public.oauth_clients
+		where id = @id;
+	`
+	args := pgx.NamedArgs{
+		"id": id,
+	}
+
+	tag, err := s.pool.Exec(ctx, query, args)
+	if err != nil {
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrClientNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveAuthorizationCode(ctx context.Context, c *models.AuthorizationCode) error {
+	const op = "storage.postgres.SaveAuthorizationCode"
+
+	userID, err := strconv.ParseUint(c.UserID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		// Expired codes are left by clients that never exchanged them
+		query := `
+			delete from public.oauth_authorization_codes
+			where expires_at <= now();
+		`
+		if _, err := tx.Exec(ctx, query); err != nil {
+			return err
+		}
+
+		query = `
+			insert into public.oauth_authorization_codes(
+				hash, client_id, user_id, redirect_uri, redirect_uri_given, scope, code_challenge, amr, auth_time, nonce, expires_at
+			)
+			values (
+				@hash, @client_id, @user_id, @redirect_uri, @redirect_uri_given, @scope, @code_challenge, @amr, @auth_time, @nonce, @expires_at
+			);
+		`
+		args := pgx.NamedArgs{
+			"hash":               c.Hash,
+			"client_id":          c.ClientID,
+			"user_id":            userID,
+			"redirect_uri":       c.RedirectURI,
+			"redirect_uri_given": c.RedirectURIGiven,
+			"scope":              c.Scope,
+			"code_challenge":     c.CodeChallenge,
+			"amr":                nonNil(c.AMR),
+			"auth_time":          pgtype.Timestamptz{Time: c.AuthTime, Valid: !c.AuthTime.IsZero()},
+			"nonce":              c.Nonce,
+			"expires_at":         c.ExpiresAt,
+		}
+
+		_, err := tx.Exec(ctx, query, args)
+		return err
+	})
+	if err != nil {
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) ConsumeAuthorizationCode(ctx context.Context, hash []byte) (*models.AuthorizationCode, error) {
+	const op = "storage.postgres.ConsumeAuthorizationCode"
+
+	query := `
+		delete from public.oauth_authorization_codes
+		where hash = @hash
+		returning client_id, user_id, redirect_uri, redirect_uri_given, scope, code_challenge, amr, auth_time, nonce, expires_at, expires_at > now();
+	`
+	args := pgx.NamedArgs{
+		"hash": hash,
+	}
+
+	c := &models.AuthorizationCode{Hash: hash}
+	var userID uint64
+	var authTime pgtype.Timestamptz
+	var active bool
+	err := s.pool.QueryRow(ctx, query, args).Scan(
+		&c.ClientID, &userID, &c.RedirectURI, &c.RedirectURIGiven, &c.Scope, &c.CodeChallenge, &c.AMR, &authTime, &c.Nonce, &c.ExpiresAt, &active,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrAuthorizationCodeInvalid)
+		}
+		err = sanitizeError(err)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if !active {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrAuthorizationCodeInvalid)
+	}
+
+	c.UserID = strconv.FormatUint(userID, 10)
+	c.AuthTime = authTime.Time
+	return c, nil
+}
+
+// nonNil keeps empty arrays from being stored as null.
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+
+	return s
+}
@@ -18,17 +18,17 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-type Options struct {
-	ReadTimeout  time.Duration
-	WriteTimeout time.Duration
-}
-
 type Storage struct {
-	pool    *pgxpool.Pool
-	mu      sync.Mutex
-	Options Options
+	pool *pgxpool.Pool
+	mu   sync.Mutex
+	opts storage.Options
+
+	// loginsSweptAt is when stale login attempts were last removed
+	loginsSweptAt time.Time
 }
 
+var _ storage.Storage = (*Storage)(nil)
+
 var (
 	poolOnce  sync.Once
 	pool      *pgxpool.Pool
@@ -99,12 +99,17 @@ func New(ctx context.Context, config config.Storage) (*Storage, error) {
 		return nil, fmt.Errorf("%s: %w", op, initErr)
 	}
 
-	opts := Options{
+	opts := storage.Options{
 		ReadTimeout:  config.ReadTimeout,
 		WriteTimeout: config.WriteTimeout,
 	}
 
-	return &Storage{pool: pool, Options: opts}, nil
+	return &Storage{pool: pool, opts: opts}, nil
+}
+
+// Options returns the configured operation timeouts.
+func (s *Storage) Options() storage.Options {
+	return s.opts
 }
 
 // Stop closes the connection pool and clears its resources.
@@ -133,7 +138,7 @@ func (s *Storage) User(ctx context.Context, id string) (*models.User, error) {
 	}
 
 	query := `
-		select email, hash
+		select email, email_verified, hash, role, permissions
 		from public.users
 		where id = @id;
 	`
@@ -142,7 +147,7 @@ func (s *Storage) User(ctx context.Context, id string) (*models.User, error) {
 	}
 
 	user := &models.User{}
-	err = s.pool.QueryRow(ctx, query, args).Scan(&user.Email, &user.PasswordHash)
+	err = s.pool.QueryRow(ctx, query, args).Scan(&user.Email, &user.EmailVerified, &user.PasswordHash, &user.Role, &user.Permissions)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
@@ -159,7 +164,7 @@ func (s *Storage) UserByEmail(ctx context.Context, email string) (*models.User,
 	const op = "storage.postgres.UserByEmail"
 
 	query := `
-		select id, hash
+		select id, email_verified, hash, role, permissions
 		from public.users
 		where email = @email;
 	`
@@ -168,7 +173,7 @@ func (s *Storage) UserByEmail(ctx context.Context, email string) (*models.User,
 	}
 
 	user := &models.User{}
-	err := s.pool.QueryRow(ctx, query, args).Scan(&user.ID, &user.PasswordHash)
+	err := s.pool.QueryRow(ctx, query, args).Scan(&user.ID, &user.EmailVerified, &user.PasswordHash, &user.Role, &user.Permissions)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
@@ -208,6 +213,203 @@ func (s *Storage) SaveUser(ctx context.Context, email string, hash []byte) (uint
 	return userID, nil
 }
 
+func (s *Storage) SetUserRole(ctx context.Context, id string, role string, permissions []string) error {
+	const op = "storage.postgres.SetUserRole"
+
+	userID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if permissions == nil {
+		permissions = []string{}
+	}
+
+	query := `
+		update public.users
+		set role = @role, permissions = @permissions
+		where id = @id;
+	`
+	args := pgx.NamedArgs{
+		"id":          userID,
+		"role":        role,
+		"permissions": permissions,
+	}
+
+	tag, err := s.pool.Exec(ctx, query, args)
+	if err != nil {
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) Users(ctx context.Context, after uint64, limit int) ([]models.User, error) {
+	const op = "storage.postgres.Users"
+
+	query := `
+		select id, email, email_verified, role, permissions
+		from public.users
+		where id > @after
+		order by id
+		limit @limit;
+	`
+	args := pgx.NamedArgs{
+		"after": after,
+		"limit": limit,
+	}
+
+	rows, err := s.pool.Query(ctx, query, args)
+	if err != nil {
+		err = sanitizeError(err)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	users := make([]models.User, 0, limit)
+	var user models.User
+	var userID uint64
+	_, err = pgx.ForEachRow(rows, []any{&userID, &user.Email, &user.EmailVerified, &user.Role, &user.Permissions}, func() error {
+		user.ID = strconv.FormatUint(userID, 10)
+		users = append(users, user)
+		// Detach the scan target from the appended user
+		user.Permissions = nil
+		return nil
+	})
+	if err != nil {
+		err = sanitizeError(err)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return users, nil
+}
+
+func (s *Storage) UpdateUserEmail(ctx context.Context, id string, email string) error {
+	const op = "storage.postgres.UpdateUserEmail"
+
+	userID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	query := `
+		update public.users
+		set email_verified = email_verified and email = @email, email = @email
+		where id = @id;
+	`
+	args := pgx.NamedArgs{
+		"id":    userID,
+		"email": email,
+	}
+
+	tag, err := s.pool.Exec(ctx, query, args)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && codes.IsIntegrityConstraintViolation(pgErr.Code) {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserAlreadyExists)
+		}
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) VerifyUserEmail(ctx context.Context, id string, email string) error {
+	const op = "storage.postgres.VerifyUserEmail"
+
+	userID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	query := `
+		update public.users
+		set email_verified = true
+		where id = @id and email = @email;
+	`
+	args := pgx.NamedArgs{
+		"id":    userID,
+		"email": email,
+	}
+
+	tag, err := s.pool.Exec(ctx, query, args)
+	if err != nil {
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) UpdateUserPassword(ctx context.Context, id string, hash []byte) error {
+	const op = "storage.postgres.UpdateUserPassword"
+
+	userID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	query := `
+		update public.users
+		set hash = @hash
+		where id = @id;
+	`
+	args := pgx.NamedArgs{
+		"id":   userID,
+		"hash": hash,
+	}
+
+	tag, err := s.pool.Exec(ctx, query, args)
+	if err != nil {
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteUser(ctx context.Context, id string) error {
+	const op = "storage.postgres.DeleteUser"
+
+	userID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Sessions are removed by the foreign key cascade
+	query := `
+		delete from public.users
+		where id = @id;
+	`
+	args := pgx.NamedArgs{
+		"id": userID,
+	}
+
+	tag, err := s.pool.Exec(ctx, query, args)
+	if err != nil {
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
 func (s *Storage) Ping(ctx context.Context) error {
 	const op = "storage.postgres.Ping"
 
@@ -0,0 +1,77 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/storage"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *Storage) SaveResetToken(ctx context.Context, t *models.ResetToken) error {
+	const op = "storage.postgres.SaveResetToken"
+
+	userID, err := strconv.ParseUint(t.UserID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	query := `
+		insert into public.password_reset_tokens(hash, user_id, expires_at)
+		values (@hash, @user_id, @expires_at);
+	`
+	args := pgx.NamedArgs{
+		"hash":       t.Hash,
+		"user_id":    userID,
+		"expires_at": t.ExpiresAt,
+	}
+
+	if _, err := s.pool.Exec(ctx, query, args); err != nil {
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) ConsumeResetToken(ctx context.Context, hash []byte) (string, error) {
+	const op = "storage.postgres.ConsumeResetToken"
+
+	var userID uint64
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		query := `
+			update public.password_reset_tokens
+			set used_at = now()
+			where hash = @hash and used_at is null and expires_at > now()
+			returning user_id;
+		`
+		args := pgx.NamedArgs{
+			"hash": hash,
+		}
+
+		if err := tx.QueryRow(ctx, query, args).Scan(&userID); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return storage.ErrResetTokenInvalid
+			}
+			return err
+		}
+
+		query = `
+			update public.password_reset_tokens
+			set used_at = now()
+			where user_id = @user_id and used_at is null;
+		`
+		_, err := tx.Exec(ctx, query, pgx.NamedArgs{"user_id": userID})
+		return err
+	})
+	if err != nil {
+		err = sanitizeError(err)
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return strconv.FormatUint(userID, 10), nil
+}
@@ -0,0 +1,203 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/storage"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func saveRefreshToken(ctx context.Context, q pgx.Tx, t *models.RefreshToken) error {
+	userID, err := strconv.ParseUint(t.UserID, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		insert into public.refresh_tokens(id, family_id, user_id, expires_at)
+		values (@id, @family_id, @user_id, @expires_at);
+	`
+	args := pgx.NamedArgs{
+		"id":         t.ID,
+		"family_id":  t.FamilyID,
+		"user_id":    userID,
+		"expires_at": t.ExpiresAt,
+	}
+
+	_, err = q.Exec(ctx, query, args)
+	return err
+}
+
+func (s *Storage) SaveRefreshToken(ctx context.Context, t *models.RefreshToken) error {
+	const op = "storage.postgres.SaveRefreshToken"
+
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		return saveRefreshToken(ctx, tx, t)
+	})
+	if err != nil {
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) RotateRefreshToken(ctx context.Context, id string, next *models.RefreshToken) error {
+	const op = "storage.postgres.RotateRefreshToken"
+
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		query := `
+			select family_id, rotated_at, revoked_at
+			from public.refresh_tokens
+			where id = @id
+			for update;
+		`
+		args := pgx.NamedArgs{
+			"id": id,
+		}
+
+		var familyID string
+		var rotatedAt, revokedAt *time.Time
+		err := tx.QueryRow(ctx, query, args).Scan(&familyID, &rotatedAt, &revokedAt)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return storage.ErrRefreshTokenNotFound
+			}
+			return err
+		}
+
+		switch {
+		case revokedAt != nil:
+			return storage.ErrRefreshTokenRevoked
+		case rotatedAt != nil:
+			return storage.ErrRefreshTokenReused
+		case familyID != next.FamilyID:
+			return storage.ErrRefreshTokenNotFound
+		}
+
+		query = `
+			update public.refresh_tokens
+			set rotated_at = now()
+			where id = @id;
+		`
+		if _, err := tx.Exec(ctx, query, args); err != nil {
+			return err
+		}
+
+		return saveRefreshToken(ctx, tx, next)
+	})
+	if err != nil {
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) RefreshToken(ctx context.Context, id string) (*models.RefreshToken, error) {
+	const op = "storage.postgres.RefreshToken"
+
+	query := `
+		select family_id, user_id, expires_at, rotated_at, revoked_at
+		from public.refresh_tokens
+		where id = @id;
+	`
+	args := pgx.NamedArgs{
+		"id": id,
+	}
+
+	var userID uint64
+	var rotatedAt, revokedAt *time.Time
+	t := &models.RefreshToken{ID: id}
+	err := s.pool.QueryRow(ctx, query, args).Scan(&t.FamilyID, &userID, &t.ExpiresAt, &rotatedAt, &revokedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrRefreshTokenNotFound)
+		}
+		err = sanitizeError(err)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	t.UserID = strconv.FormatUint(userID, 10)
+	if rotatedAt != nil {
+		t.RotatedAt = *rotatedAt
+	}
+	if revokedAt != nil {
+		t.RevokedAt = *revokedAt
+	}
+
+	return t, nil
+}
+
+func (s *Storage) FamilyRevoked(ctx context.Context, familyID string) (bool, error) {
+	const op = "storage.postgres.FamilyRevoked"
+
+	query := `
+		select count(*) = 0 or bool_or(revoked_at is not null)
+		from public.refresh_tokens
+		where family_id = @family_id;
+	`
+	args := pgx.NamedArgs{
+		"family_id": familyID,
+	}
+
+	var revoked bool
+	if err := s.pool.QueryRow(ctx, query, args).Scan(&revoked); err != nil {
+		err = sanitizeError(err)
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return revoked, nil
+}
+
+func (s *Storage) RevokeUserTokens(ctx context.Context, userID string, keep string) error {
+	const op = "storage.postgres.RevokeUserTokens"
+
+	id, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	query := `
+		update public.refresh_tokens
+		set revoked_at = now()
+		where user_id = @user_id and family_id <> @keep and revoked_at is null;
+	`
+	args := pgx.NamedArgs{
+		"user_id": id,
+		"keep":    keep,
+	}
+
+	if _, err := s.pool.Exec(ctx, query, args); err != nil {
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) RevokeTokenFamily(ctx context.Context, familyID string) error {
+	const op = "storage.postgres.RevokeTokenFamily"
+
+	query := `
+		update public.refresh_tokens
+		set revoked_at = now()
+		where family_id = @family_id and revoked_at is null;
+	`
+	args := pgx.NamedArgs{
+		"family_id": familyID,
+	}
+
+	if _, err := s.pool.Exec(ctx, query, args); err != nil {
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
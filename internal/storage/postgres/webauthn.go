@@ -0,0 +1,263 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/korikhin/auth/internal/domain/models"
+	"github.com/korikhin/auth/internal/storage"
+
+	codes "github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func (s *Storage) SaveWebAuthnChallenge(ctx context.Context, c *models.WebAuthnChallenge) error {
+	const op = "storage.postgres.SaveWebAuthnChallenge"
+
+	// Discoverable logins are not bound to a user
+	var userID *uint64
+	if c.UserID != "" {
+		id, err := strconv.ParseUint(c.UserID, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		userID = &id
+	}
+
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		// Expired challenges are left by abandoned ceremonies
+		query := `
+			delete from public.webauthn_challenges
+			where expires_at <= now();
+		`
+		if _, err := tx.Exec(ctx, query); err != nil {
+			return err
+		}
+
+		query = `
+			insert into public.webauthn_challenges(hash, user_id, type, expires_at)
+			values (@hash, @user_id, @type, @expires_at);
+		`
+		args := pgx.NamedArgs{
+			"hash":       c.Hash,
+			"user_id":    userID,
+			"type":       c.Type,
+			"expires_at": c.ExpiresAt,
+		}
+
+		_, err := tx.Exec(ctx, query, args)
+		return err
+	})
+	if err != nil {
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) ConsumeWebAuthnChallenge(ctx context.Context, hash []byte) (*models.WebAuthnChallenge, error) {
+	const op = "storage.postgres.ConsumeWebAuthnChallenge"
+
+	query := `
+		delete from public.webauthn_challenges
+		where hash = @hash
+		returning user_id, type, expires_at, expires_at > now();
+	`
+	args := pgx.NamedArgs{
+		"hash": hash,
+	}
+
+	c := &models.WebAuthnChallenge{Hash: hash}
+	var userID pgtype.Int8
+	var active bool
+	err := s.pool.QueryRow(ctx, query, args).Scan(&userID, &c.Type, &c.ExpiresAt, &active)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrChallengeInvalid)
+		}
+		err = sanitizeError(err)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if !active {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrChallengeInvalid)
+	}
+
+	if userID.Valid {
+		c.UserID = strconv.FormatInt(userID.Int64, 10)
+	}
+
+	return c, nil
+}
+
+func (s *Storage) SaveWebAuthnCredential(ctx context.Context, c *models.WebAuthnCredential) error {
+	const op = "storage.postgres.SaveWebAuthnCredential"
+
+	userID, err := strconv.ParseUint(c.UserID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	query := `
+		insert into public.webauthn_credentials(id, user_id, public_key, aaguid, sign_count)
+		values (@id, @user_id, @public_key, @aaguid, @sign_count);
+	`
+	args := pgx.NamedArgs{
+		"id":         c.ID,
+		"user_id":    userID,
+		"public_key": c.PublicKey,
+		"aaguid":     c.AAGUID,
+		"sign_count": int64(c.SignCount),
+	}
+
+	if _, err := s.pool.Exec(ctx, query, args); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == codes.UniqueViolation {
+			return fmt.Errorf("%s: %w", op, storage.ErrCredentialAlreadyExists)
+		}
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+const credentialColumns = `id, user_id, public_key, aaguid, sign_count, created_at, last_used_at`
+
+func scanCredential(row pgx.Row) (*models.WebAuthnCredential, error) {
+	c := &models.WebAuthnCredential{}
+	var userID uint64
+	var signCount int64
+	var lastUsed pgtype.Timestamptz
+
+	if err := row.Scan(&c.ID, &userID, &c.PublicKey, &c.AAGUID, &signCount, &c.CreatedAt, &lastUsed); err != nil {
+		return nil, err
+	}
+
+	c.UserID = strconv.FormatUint(userID, 10)
+	c.SignCount = uint32(signCount)
+	c.LastUsedAt = lastUsed.Time
+
+	return c, nil
+}
+
+func (s *Storage) WebAuthnCredential(ctx context.Context, id []byte) (*models.WebAuthnCredential, error) {
+	const op = "storage.postgres.WebAuthnCredential"
+
+	query := `
+		select ` + credentialColumns + `
+		from public.webauthn_credentials
+		where id = @id;
+	`
+	args := pgx.NamedArgs{
+		"id": id,
+	}
+
+	c, err := scanCredential(s.pool.QueryRow(ctx, query, args))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrCredentialNotFound)
+		}
+		err = sanitizeError(err)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return c, nil
+}
+
+func (s *Storage) WebAuthnCredentials(ctx context.Context, userID string) ([]models.WebAuthnCredential, error) {
+	const op = "storage.postgres.WebAuthnCredentials"
+
+	id, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	query := `
+		select ` + credentialColumns + `
+		from public.webauthn_credentials
+		where user_id = @user_id
+		order by created_at, id;
+	`
+	args := pgx.NamedArgs{
+		"user_id": id,
+	}
+
+	rows, err := s.pool.Query(ctx, query, args)
+	if err != nil {
+		err = sanitizeError(err)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	creds, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.WebAuthnCredential, error) {
+		c, err := scanCredential(row)
+		if err != nil {
+			return models.WebAuthnCredential{}, err
+		}
+		return *c, nil
+	})
+	if err != nil {
+		err = sanitizeError(err)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return creds, nil
+}
+
+func (s *Storage) UseWebAuthnCredential(ctx context.Context, id []byte, signCount uint32) error {
+	const op = "storage.postgres.UseWebAuthnCredential"
+
+	query := `
+		update public.webauthn_credentials
+		set sign_count = @sign_count, last_used_at = now()
+		where id = @id;
+	`
+	args := pgx.NamedArgs{
+		"id":         id,
+		"sign_count": int64(signCount),
+	}
+
+	tag, err := s.pool.Exec(ctx, query, args)
+	if err != nil {
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrCredentialNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteWebAuthnCredential(ctx context.Context, userID string, id []byte) error {
+	const op = "storage.postgres.DeleteWebAuthnCredential"
+
+	uid, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	query := `
+		delete from public.webauthn_credentials
+		where id = @id and user_id = @user_id;
+	`
+	args := pgx.NamedArgs{
+		"id":      id,
+		"user_id": uid,
+	}
+
+	tag, err := s.pool.Exec(ctx, query, args)
+	if err != nil {
+		err = sanitizeError(err)
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrCredentialNotFound)
+	}
+
+	return nil
+}
@@ -1,9 +1,233 @@
 package storage
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/korikhin/auth/internal/domain/models"
+)
 
 var (
 	ErrConnectionFailed  = errors.New("failed to connect to the storage")
 	ErrUserAlreadyExists = errors.New("user already exists")
 	ErrUserNotFound      = errors.New("user not found")
+
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenRevoked  = errors.New("refresh token is revoked")
+	ErrRefreshTokenReused   = errors.New("refresh token is already rotated")
+
+	ErrResetTokenInvalid = errors.New("reset token is invalid or expired")
+
+	ErrMFANotFound       = errors.New("mfa is not enrolled")
+	ErrMFAAlreadyEnabled = errors.New("mfa is already enabled")
+	ErrMFACodeUsed       = errors.New("mfa code is already used")
+
+	ErrChallengeInvalid        = errors.New("challenge is invalid or expired")
+	ErrCredentialNotFound      = errors.New("credential not found")
+	ErrCredentialAlreadyExists = errors.New("credential already exists")
+
+	ErrClientNotFound           = errors.New("client not found")
+	ErrAuthorizationCodeInvalid = errors.New("authorization code is invalid or expired")
+
+	ErrExternalLoginInvalid  = errors.New("external login is invalid or expired")
+	ErrIdentityNotFound      = errors.New("identity not found")
+	ErrIdentityAlreadyExists = errors.New("identity already exists")
+
+	ErrAPIKeyNotFound = errors.New("api key not found")
+
+	ErrNoMigrations = errors.New("no migrations to roll back")
 )
+
+// Options holds per-operation timeouts of a storage backend.
+type Options struct {
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+// Configured is implemented by every backend so that callers can derive
+// operation contexts from the backend's own timeouts.
+type Configured interface {
+	Options() Options
+}
+
+type UserProvider interface {
+	Configured
+	User(ctx context.Context, id string) (*models.User, error)
+	UserByEmail(ctx context.Context, email string) (*models.User, error)
+}
+
+type UserLister interface {
+	Configured
+	// Users returns up to limit users with IDs greater than after, ordered by ID.
+	Users(ctx context.Context, after uint64, limit int) ([]models.User, error)
+}
+
+type UserSaver interface {
+	Configured
+	SaveUser(ctx context.Context, email string, hash []byte) (uint64, error)
+	SetUserRole(ctx context.Context, id string, role string, permissions []string) error
+	// UpdateUserEmail changes the email, which then has to be verified again.
+	UpdateUserEmail(ctx context.Context, id string, email string) error
+	// VerifyUserEmail marks the email as verified, provided it is still
+	// the email of the user. Otherwise it fails with ErrUserNotFound.
+	VerifyUserEmail(ctx context.Context, id string, email string) error
+	UpdateUserPassword(ctx context.Context, id string, hash []byte) error
+	// DeleteUser removes the user along with all the sessions.
+	DeleteUser(ctx context.Context, id string) error
+}
+
+type SessionStorage interface {
+	Configured
+	SaveRefreshToken(ctx context.Context, t *models.RefreshToken) error
+	// RotateRefreshToken marks the token with the given ID as used and saves
+	// its successor from the same family in one step. It fails with
+	// ErrRefreshTokenReused if the token has already been rotated.
+	RotateRefreshToken(ctx context.Context, id string, next *models.RefreshToken) error
+	RevokeTokenFamily(ctx context.Context, familyID string) error
+	RefreshToken(ctx context.Context, id string) (*models.RefreshToken, error)
+	// FamilyRevoked reports whether the session the family belongs to is over.
+	// Unknown families are reported as revoked.
+	FamilyRevoked(ctx context.Context, familyID string) (bool, error)
+	// RevokeUserTokens revokes refresh tokens of the user, ending the sessions.
+	// The family given in keep, if any, is left intact.
+	RevokeUserTokens(ctx context.Context, userID string, keep string) error
+}
+
+type ResetTokenStorage interface {
+	Configured
+	SaveResetToken(ctx context.Context, t *models.ResetToken) error
+	// ConsumeResetToken marks the token as used and returns the owner ID.
+	// All other tokens of the user are invalidated as well.
+	ConsumeResetToken(ctx context.Context, hash []byte) (string, error)
+}
+
+// LoginAttemptStorage keeps failed login counters by arbitrary keys,
+// e.g. an account or a client address.
+type LoginAttemptStorage interface {
+	Configured
+	// AddLoginFailure counts a failure and returns the number of failures
+	// in a row. The count starts over if the previous failure is older
+	// than the window.
+	AddLoginFailure(ctx context.Context, key string, window time.Duration) (int, error)
+	LockLogin(ctx context.Context, key string, until time.Time) error
+	// LoginLockedUntil returns the end of the lock, zero time if there is none.
+	LoginLockedUntil(ctx context.Context, key string) (time.Time, error)
+	ResetLoginFailures(ctx context.Context, key string) error
+}
+
+type MFAStorage interface {
+	Configured
+	// SaveTOTP stores a new unconfirmed secret in place of an unconfirmed one.
+	// It fails with ErrMFAAlreadyEnabled if the user has a confirmed one.
+	SaveTOTP(ctx context.Context, userID string, secret string) error
+	TOTP(ctx context.Context, userID string) (*models.TOTP, error)
+	// ConfirmTOTP enables the secret accepted at the step and replaces
+	// the recovery codes with the given hashes.
+	ConfirmTOTP(ctx context.Context, userID string, step int64, recoveryCodes [][]byte) error
+	// UseTOTPStep records the step of an accepted code. It fails with
+	// ErrMFACodeUsed unless the step is newer than the last one.
+	UseTOTPStep(ctx context.Context, userID string, step int64) error
+	// UseRecoveryCode spends the code, ErrMFACodeUsed means there is
+	// no such unused code.
+	UseRecoveryCode(ctx context.Context, userID string, hash []byte) error
+	// DeleteMFA removes the secret and the recovery codes.
+	DeleteMFA(ctx context.Context, userID string) error
+}
+
+type WebAuthnStorage interface {
+	Configured
+	SaveWebAuthnChallenge(ctx context.Context, c *models.WebAuthnChallenge) error
+	// ConsumeWebAuthnChallenge removes the challenge and returns it,
+	// ErrChallengeInvalid means it is unknown or expired.
+	ConsumeWebAuthnChallenge(ctx context.Context, hash []byte) (*models.WebAuthnChallenge, error)
+	SaveWebAuthnCredential(ctx context.Context, c *models.WebAuthnCredential) error
+	WebAuthnCredential(ctx context.Context, id []byte) (*models.WebAuthnCredential, error)
+	WebAuthnCredentials(ctx context.Context, userID string) ([]models.WebAuthnCredential, error)
+	// UseWebAuthnCredential records the signature counter of an assertion.
+	UseWebAuthnCredential(ctx context.Context, id []byte, signCount uint32) error
+	DeleteWebAuthnCredential(ctx context.Context, userID string, id []byte) error
+}
+
+type ClientStorage interface {
+	Configured
+	SaveClient(ctx context.Context, c *models.Client) error
+	Client(ctx context.Context, id string) (*models.Client, error)
+	Clients(ctx context.Context) ([]models.Client, error)
+	// DeleteClient removes the client along with its pending codes.
+	DeleteClient(ctx context.Context, id string) error
+	SaveAuthorizationCode(ctx context.Context, c *models.AuthorizationCode) error
+	// ConsumeAuthorizationCode removes the code and returns it,
+	// ErrAuthorizationCodeInvalid means it is unknown or expired.
+	ConsumeAuthorizationCode(ctx context.Context, hash []byte) (*models.AuthorizationCode, error)
+}
+
+type IdentityStorage interface {
+	Configured
+	SaveExternalLogin(ctx context.Context, l *models.ExternalLogin) error
+	// ConsumeExternalLogin removes the login and returns it,
+	// ErrExternalLoginInvalid means it is unknown or expired.
+	ConsumeExternalLogin(ctx context.Context, stateHash []byte) (*models.ExternalLogin, error)
+	Identity(ctx context.Context, issuer, subject string) (*models.Identity, error)
+	// SaveIdentity links the identity to the user, ErrIdentityAlreadyExists
+	// means it is linked already, possibly to another user.
+	SaveIdentity(ctx context.Context, i *models.Identity) error
+}
+
+type APIKeyStorage interface {
+	Configured
+	SaveAPIKey(ctx context.Context, k *models.APIKey) error
+	// APIKeyByHash returns the key, revoked and expired ones included.
+	APIKeyByHash(ctx context.Context, hash []byte) (*models.APIKey, error)
+	// APIKeys returns the keys of the user in the order of creation.
+	APIKeys(ctx context.Context, userID string) ([]models.APIKey, error)
+	// RevokeAPIKey revokes the key of the user, ErrAPIKeyNotFound means
+	// there is no such key that is not revoked yet.
+	RevokeAPIKey(ctx context.Context, userID string, id string) error
+	UseAPIKey(ctx context.Context, id string, at time.Time) error
+}
+
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Storage is the full set of operations a backend must support.
+type Storage interface {
+	UserProvider
+	UserLister
+	UserSaver
+	SessionStorage
+	ResetTokenStorage
+	LoginAttemptStorage
+	MFAStorage
+	WebAuthnStorage
+	ClientStorage
+	IdentityStorage
+	APIKeyStorage
+	Pinger
+
+	// Stop releases the backend resources.
+	Stop()
+}
+
+type Migration struct {
+	Version uint64
+	Name    string
+}
+
+type MigrationState struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator is implemented by backends with a versioned schema.
+type Migrator interface {
+	// MigrateUp applies all pending migrations and returns them.
+	MigrateUp(ctx context.Context) ([]Migration, error)
+	// MigrateDown rolls back the latest applied migration and returns it.
+	MigrateDown(ctx context.Context) (*Migration, error)
+	MigrationStatus(ctx context.Context) ([]MigrationState, error)
+	// SchemaVersion returns the latest applied version, 0 if none.
+	SchemaVersion(ctx context.Context) (uint64, error)
+}
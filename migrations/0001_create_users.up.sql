@@ -0,0 +1,5 @@
+create table if not exists public.users (
+    id    bigserial primary key,
+    email text      not null unique,
+    hash  bytea     not null
+);
@@ -0,0 +1,12 @@
+create table if not exists public.refresh_tokens (
+    id         text        primary key,
+    family_id  text        not null,
+    user_id    bigint      not null references public.users(id) on delete cascade,
+    expires_at timestamptz not null,
+    created_at timestamptz not null default now(),
+    rotated_at timestamptz,
+    revoked_at timestamptz
+);
+
+create index if not exists refresh_tokens_family_id_idx on public.refresh_tokens(family_id);
+create index if not exists refresh_tokens_user_id_idx on public.refresh_tokens(user_id);
@@ -0,0 +1,3 @@
+alter table public.users
+    drop column if exists permissions,
+    drop column if exists role;
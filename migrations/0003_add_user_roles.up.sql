@@ -0,0 +1,3 @@
+alter table public.users
+    add column if not exists role        text   not null default 'user',
+    add column if not exists permissions text[] not null default '{}';
@@ -0,0 +1 @@
+drop table if exists public.password_reset_tokens;
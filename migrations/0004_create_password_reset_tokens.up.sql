@@ -0,0 +1,9 @@
+create table if not exists public.password_reset_tokens (
+    hash       bytea       primary key,
+    user_id    bigint      not null references public.users(id) on delete cascade,
+    expires_at timestamptz not null,
+    created_at timestamptz not null default now(),
+    used_at    timestamptz
+);
+
+create index if not exists password_reset_tokens_user_id_idx on public.password_reset_tokens(user_id);
@@ -0,0 +1,6 @@
+create table if not exists public.login_attempts (
+    key             text        primary key,
+    failures        integer     not null default 0,
+    last_failure_at timestamptz not null default now(),
+    locked_until    timestamptz
+);
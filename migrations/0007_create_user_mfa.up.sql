@@ -0,0 +1,15 @@
+create table if not exists public.user_totp (
+    user_id      bigint      primary key references public.users(id) on delete cascade,
+    secret       text        not null,
+    last_step    bigint      not null default 0,
+    created_at   timestamptz not null default now(),
+    confirmed_at timestamptz
+);
+
+create table if not exists public.mfa_recovery_codes (
+    hash    bytea       primary key,
+    user_id bigint      not null references public.users(id) on delete cascade,
+    used_at timestamptz
+);
+
+create index if not exists mfa_recovery_codes_user_id_idx on public.mfa_recovery_codes(user_id);
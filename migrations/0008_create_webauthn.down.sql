@@ -0,0 +1,2 @@
+drop table if exists public.webauthn_challenges;
+drop table if exists public.webauthn_credentials;
@@ -0,0 +1,18 @@
+create table if not exists public.webauthn_credentials (
+    id           bytea       primary key,
+    user_id      bigint      not null references public.users(id) on delete cascade,
+    public_key   bytea       not null,
+    aaguid       bytea       not null,
+    sign_count   bigint      not null default 0,
+    created_at   timestamptz not null default now(),
+    last_used_at timestamptz
+);
+
+create index if not exists webauthn_credentials_user_id_idx on public.webauthn_credentials(user_id);
+
+create table if not exists public.webauthn_challenges (
+    hash       bytea       primary key,
+    user_id    bigint      references public.users(id) on delete cascade,
+    type       text        not null,
+    expires_at timestamptz not null
+);
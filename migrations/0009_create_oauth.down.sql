@@ -0,0 +1,2 @@
+drop table if exists public.oauth_authorization_codes;
+drop table if exists public.oauth_clients;
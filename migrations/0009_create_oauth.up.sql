@@ -0,0 +1,20 @@
+create table if not exists public.oauth_clients (
+    id            text        primary key,
+    secret_hash   bytea,
+    name          text        not null,
+    redirect_uris text[]      not null default '{}',
+    grant_types   text[]      not null default '{}',
+    scopes        text[]      not null default '{}',
+    created_at    timestamptz not null default now()
+);
+
+create table if not exists public.oauth_authorization_codes (
+    hash           bytea       primary key,
+    client_id      text        not null references public.oauth_clients(id) on delete cascade,
+    user_id        bigint      not null references public.users(id) on delete cascade,
+    redirect_uri   text        not null,
+    scope          text        not null,
+    code_challenge text        not null,
+    amr            text[]      not null default '{}',
+    expires_at     timestamptz not null
+);
@@ -0,0 +1,3 @@
+alter table public.oauth_authorization_codes
+    drop column if exists auth_time,
+    drop column if exists nonce;
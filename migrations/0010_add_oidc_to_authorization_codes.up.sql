@@ -0,0 +1,3 @@
+alter table public.oauth_authorization_codes
+    add column if not exists nonce     text not null default '',
+    add column if not exists auth_time timestamptz;
@@ -0,0 +1,2 @@
+drop table if exists public.external_logins;
+drop table if exists public.identities;
@@ -0,0 +1,19 @@
+create table if not exists public.identities (
+    issuer     text        not null,
+    subject    text        not null,
+    user_id    bigint      not null references public.users(id) on delete cascade,
+    provider   text        not null,
+    email      text        not null,
+    created_at timestamptz not null default now(),
+    primary key (issuer, subject)
+);
+
+create index if not exists identities_user_id_idx on public.identities(user_id);
+
+create table if not exists public.external_logins (
+    state_hash    bytea       primary key,
+    provider      text        not null,
+    nonce         text        not null,
+    code_verifier text        not null,
+    expires_at    timestamptz not null
+);
@@ -0,0 +1,14 @@
+create table if not exists public.api_keys (
+    id           text        primary key,
+    user_id      bigint      not null references public.users(id) on delete cascade,
+    name         text        not null,
+    prefix       text        not null,
+    hash         bytea       not null unique,
+    scopes       text[]      not null default '{}',
+    expires_at   timestamptz,
+    last_used_at timestamptz,
+    revoked_at   timestamptz,
+    created_at   timestamptz not null default now()
+);
+
+create index if not exists api_keys_user_id_idx on public.api_keys(user_id);
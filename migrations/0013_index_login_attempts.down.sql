@@ -0,0 +1 @@
+drop index if exists public.login_attempts_last_failure_at_idx;
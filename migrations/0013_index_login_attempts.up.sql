@@ -0,0 +1 @@
+create index if not exists login_attempts_last_failure_at_idx on public.login_attempts(last_failure_at);
@@ -0,0 +1,2 @@
+alter table public.oauth_authorization_codes
+    drop column if exists redirect_uri_given;
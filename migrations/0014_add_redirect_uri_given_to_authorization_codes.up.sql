@@ -0,0 +1,2 @@
+alter table public.oauth_authorization_codes
+    add column if not exists redirect_uri_given boolean not null default true;
@@ -0,0 +1,10 @@
+// Package migrations embeds the versioned SQL schema of the postgres storage.
+//
+// Files are named `<version>_<name>.up.sql` and `<version>_<name>.down.sql`,
+// where version is a positive integer, unique across the directory.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS